@@ -6,12 +6,17 @@
 package alarm
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/dlibox/controller/sun"
 	"github.com/maruel/msgbus"
 )
 
@@ -49,55 +54,194 @@ func (w WeekdayBit) String() string {
 	return string(out[:])
 }
 
+// Step is a command sent some time after the alarm triggered.
+type Step struct {
+	Delay int // Seconds after the alarm triggered.
+	Cmd   rules.Command
+}
+
 // Alarm represents a single alarm.
+//
+// The alarm triggers either at Hour:Minute or, when Solar is set, at the solar
+// event plus Offset minutes.
+//
+// The alarm triggers once on Date if set. Otherwise it triggers on each of
+// Days between From and Until inclusively, when set. The days listed in Except
+// and the days covered by the events in the ExceptICS iCalendar file are
+// skipped.
+//
+// When the alarm triggers, Cmd is sent immediately, then each of Steps is sent
+// after its delay.
 type Alarm struct {
 	Enabled bool
 	Hour    int
 	Minute  int
+	Solar   sun.Event // If set, Hour and Minute are ignored.
+	Offset  int       // Offset in minutes relative to Solar; can be negative.
+	Date    Date      // If set, the alarm is one-shot and Days is ignored.
 	Days    WeekdayBit
-	Cmd     rules.Command
-	timer   *time.Timer
+	From    Date
+	Until   Date
+	Except  []Date
+	// ExceptICS is the path to an iCalendar file, e.g. holidays or vacations.
+	ExceptICS string
+	// Location is the IANA time zone name used to interpret Hour, Minute and
+	// Days, e.g. "America/Toronto". If empty, the controller's local time zone
+	// is used.
+	Location string
+	Cmd      rules.Command
+	Steps    []Step
+	// SkipNext is the trigger that will be skipped. It is set via SkipNext()
+	// and cleared once the trigger is skipped.
+	SkipNext time.Time
+
+	mu     sync.Mutex
+	pos    *sun.Position
+	loc    *time.Location
+	ics    map[Date]bool // Days loaded from ExceptICS.
+	b      msgbus.Bus    // Set while the alarm is armed.
+	timer  *time.Timer
+	next   time.Time     // Trigger the timer is armed for.
+	last   time.Time     // Last trigger, to never trigger twice when the clock goes back.
+	armed  time.Time     // When the timer was armed, to detect wall clock jumps.
+	snooze time.Time     // If set, trigger at this time.
+	steps  []*time.Timer // Pending steps of the current trigger.
 }
 
 // Next returns when the next trigger should be according to the alarm
 // schedule.
 //
-// Return 0 if not enabled.
+// Return 0 if not enabled or if the alarm will not trigger within a year.
 func (a *Alarm) Next(now time.Time) time.Time {
-	if a.Enabled && a.Days != 0 {
-		out := time.Date(now.Year(), now.Month(), now.Day(), a.Hour, a.Minute, 0, 0, now.Location())
-		if a.Days.IsEnabledFor(now.Weekday()) {
-			if now.Hour() < a.Hour || (now.Hour() == a.Hour && now.Minute() < a.Minute) {
-				return out
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nextLocked(now)
+}
+
+// MarshalJSON implements json.Marshaler.
+//
+// It holds the lock since SkipNext is cleared when the skipped trigger passes.
+func (a *Alarm) MarshalJSON() ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	type alarm Alarm
+	return json.Marshal((*alarm)(a))
+}
+
+func (a *Alarm) nextLocked(now time.Time) time.Time {
+	if !a.Enabled || (len(a.Date) == 0 && a.Days == 0) {
+		return time.Time{}
+	}
+	loc := a.loc
+	if loc == nil {
+		loc = now.Location()
+		if len(a.Location) != 0 {
+			var err error
+			if loc, err = time.LoadLocation(a.Location); err != nil {
+				return time.Time{}
 			}
 		}
-		for i := 1; i < 8; i++ {
-			out = out.Add(24 * time.Hour)
-			if a.Days.IsEnabledFor(out.Weekday()) {
-				return out
-			}
+	}
+	if len(a.Date) != 0 {
+		day, err := a.Date.day(loc)
+		if err != nil || a.isExcepted(a.Date) {
+			return time.Time{}
+		}
+		if t, ok := a.on(day); ok && t.After(now) {
+			return t
+		}
+		return time.Time{}
+	}
+	start := now.In(loc)
+	if len(a.From) != 0 {
+		from, err := a.From.day(loc)
+		if err != nil {
+			return time.Time{}
+		}
+		if from.After(start) {
+			start = from
+		}
+	}
+	var until time.Time
+	if len(a.Until) != 0 {
+		var err error
+		if until, err = a.Until.day(loc); err != nil {
+			return time.Time{}
+		}
+	}
+	// Use calendar day arithmetic so the wall clock time is respected across
+	// daylight saving time changes. Look up to a year ahead since solar events
+	// may not happen for months near the poles.
+	y, m, d := start.Date()
+	for i := 0; i < 367; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, loc)
+		if !until.IsZero() && day.After(until) {
+			break
+		}
+		if !a.Days.IsEnabledFor(day.Weekday()) || a.isExcepted(dateOf(day)) {
+			continue
+		}
+		if t, ok := a.on(day); ok && t.After(now) {
+			return t
 		}
-		panic("unexpected code")
 	}
 	return time.Time{}
 }
 
-// Reset reinitializes with a message bus.
-func (a *Alarm) Reset(b msgbus.Bus) error {
-	if a.timer != nil {
-		a.timer.Stop()
-		a.timer = nil
+// isExcepted returns true if the alarm must not trigger on this day.
+func (a *Alarm) isExcepted(d Date) bool {
+	if a.ics[d] {
+		return true
+	}
+	for _, e := range a.Except {
+		if e == d {
+			return true
+		}
+	}
+	return false
+}
+
+// on returns the trigger time for this calendar day.
+func (a *Alarm) on(day time.Time) (time.Time, bool) {
+	y, m, d := day.Date()
+	if len(a.Solar) == 0 {
+		return wallClock(y, m, d, a.Hour, a.Minute, day.Location()), true
 	}
-	now := time.Now()
-	if next := a.Next(now); !next.IsZero() {
-		a.timer = time.AfterFunc(next.Sub(now), func() {
-			if err := b.Publish(a.Cmd.ToMsg(), msgbus.ExactlyOnce); err != nil {
-				log.Printf("failed to publish command %v", a.Cmd)
+	if a.pos == nil {
+		return time.Time{}, false
+	}
+	t, ok := a.Solar.On(a.pos, y, m, d, day.Location())
+	if !ok {
+		return t, false
+	}
+	return t.Add(time.Duration(a.Offset) * time.Minute).Truncate(time.Minute), true
+}
+
+// wallClock returns the first instant of the calendar day where the wall
+// clock reads hour:minute.
+//
+// When the wall clock time happens twice because of a daylight saving time
+// change, the first occurrence is used. When it doesn't happen at all, the
+// moment of the change is used.
+func wallClock(year int, month time.Month, day, hour, minute int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, hour, minute, 0, 0, loc)
+	if t.Hour() == hour && t.Minute() == minute {
+		for _, d := range []time.Duration{time.Hour, 30 * time.Minute} {
+			if e := t.Add(-d); e.Day() == day && e.Hour() == hour && e.Minute() == minute {
+				return e
 			}
-			a.Reset(b)
-		})
+		}
+		return t
 	}
-	return nil
+	// time.Date() normalizes a skipped wall clock time inconsistently, so look
+	// for the first minute past the change.
+	target := hour*60 + minute
+	for e := t.Add(-3 * time.Hour); e.Before(t.Add(3 * time.Hour)); e = e.Add(time.Minute) {
+		if e.Day() == day && e.Hour()*60+e.Minute() >= target {
+			return e
+		}
+	}
+	return t
 }
 
 // Validate confirms the settings are valid.
@@ -111,11 +255,97 @@ func (a *Alarm) Validate() error {
 	if a.Minute < 0 || a.Minute >= 60 {
 		return errors.New("invalid minute")
 	}
+	if len(a.Solar) != 0 {
+		if err := a.Solar.Validate(); err != nil {
+			return err
+		}
+		if a.Offset < -12*60 || a.Offset > 12*60 {
+			return errors.New("invalid offset")
+		}
+	} else if a.Offset != 0 {
+		return errors.New("offset requires a solar event")
+	}
+	if len(a.Location) != 0 {
+		if _, err := time.LoadLocation(a.Location); err != nil {
+			return fmt.Errorf("invalid location: %v", err)
+		}
+	}
+	if len(a.Date) != 0 {
+		if err := a.Date.Validate(); err != nil {
+			return err
+		}
+		if a.Days != 0 || len(a.From) != 0 || len(a.Until) != 0 {
+			return errors.New("can't use Date with Days, From or Until")
+		}
+	}
+	if len(a.From) != 0 {
+		if err := a.From.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(a.Until) != 0 {
+		if err := a.Until.Validate(); err != nil {
+			return err
+		}
+		// The format permits comparing the strings directly.
+		if len(a.From) != 0 && a.Until < a.From {
+			return errors.New("Until must not be before From")
+		}
+	}
+	for _, e := range a.Except {
+		if err := e.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(a.ExceptICS) != 0 {
+		if _, err := loadICS(a.ExceptICS); err != nil {
+			return err
+		}
+	}
+	last := 0
+	for i, st := range a.Steps {
+		if st.Delay < last || st.Delay > 24*60*60 {
+			return fmt.Errorf("step %d: invalid delay", i)
+		}
+		last = st.Delay
+		if err := st.Cmd.Validate(); err != nil {
+			return fmt.Errorf("step %d: %v", i, err)
+		}
+	}
 	return a.Cmd.Validate()
 }
 
 func (a *Alarm) String() string {
-	out := fmt.Sprintf("%02d:%02d (%s)", a.Hour, a.Minute, a.Days)
+	out := fmt.Sprintf("%02d:%02d", a.Hour, a.Minute)
+	if len(a.Solar) != 0 {
+		out = string(a.Solar)
+		if a.Offset != 0 {
+			out += fmt.Sprintf("%+dm", a.Offset)
+		}
+	}
+	if len(a.Date) != 0 {
+		out += " on " + string(a.Date)
+	} else {
+		out += fmt.Sprintf(" (%s)", a.Days)
+		if len(a.From) != 0 {
+			out += " from " + string(a.From)
+		}
+		if len(a.Until) != 0 {
+			out += " until " + string(a.Until)
+		}
+	}
+	if len(a.Except) != 0 {
+		out += fmt.Sprintf(" except %d days", len(a.Except))
+	}
+	if len(a.ExceptICS) != 0 {
+		out += " except " + a.ExceptICS
+	}
+	if len(a.Location) != 0 {
+		out += " " + a.Location
+	}
+	if len(a.Steps) != 0 {
+		out += fmt.Sprintf(" +%d steps", len(a.Steps))
+	}
 	if !a.Enabled {
 		out += " (disabled)"
 	}
@@ -125,19 +355,105 @@ func (a *Alarm) String() string {
 // Config is what should be serialized.
 type Config struct {
 	Alarms map[string]*Alarm
+	// Position is used to calculate the solar events. It is required if any
+	// alarm uses Solar.
+	Position *sun.Position
+
+	b msgbus.Bus
 }
 
 // Init initializes the timers.
+//
+// It also listens to "alarms/<name>/snooze" and "alarms/<name>/skip". See
+// Config.onMsg for details.
 func Init(b msgbus.Bus, config *Config) error {
 	var err error
 	for _, a := range config.Alarms {
+		a.mu.Lock()
+		a.pos = config.Position
+		a.ics = nil
+		if len(a.ExceptICS) != 0 {
+			var err1 error
+			if a.ics, err1 = loadICS(a.ExceptICS); err1 != nil {
+				err = err1
+			}
+		}
+		a.loc = nil
+		if len(a.Location) != 0 {
+			var err1 error
+			if a.loc, err1 = time.LoadLocation(a.Location); err1 != nil {
+				err = err1
+			}
+		}
+		a.mu.Unlock()
 		if err1 := a.Reset(b); err1 != nil {
 			err = err1
 		}
 	}
+	c, err1 := b.Subscribe("alarms/#", msgbus.ExactlyOnce)
+	if err1 != nil {
+		return err1
+	}
+	config.b = b
+	go func() {
+		for msg := range c {
+			config.onMsg(msg)
+		}
+	}()
 	return err
 }
 
+// Stop disarms all the timers.
+func (c *Config) Stop() {
+	if c.b != nil {
+		c.b.Unsubscribe("alarms/#")
+		c.b = nil
+	}
+	for _, a := range c.Alarms {
+		a.Stop()
+	}
+}
+
+// onMsg processes a command for an alarm:
+//   - "alarms/<name>/snooze" snoozes the alarm. The payload is the number of
+//     minutes, or empty for the default.
+//   - "alarms/<name>/skip" skips the next trigger if the payload is "true" or
+//     empty, or cancels the skip if the payload is "false".
+func (c *Config) onMsg(msg msgbus.Message) {
+	parts := strings.Split(msg.Topic, "/")
+	if len(parts) != 3 {
+		return
+	}
+	a := c.Alarms[parts[1]]
+	if a == nil {
+		log.Printf("alarm: unknown alarm %q", parts[1])
+		return
+	}
+	p := string(msg.Payload)
+	switch parts[2] {
+	case "snooze":
+		d := DefaultSnooze
+		if len(p) != 0 {
+			m, err := strconv.Atoi(p)
+			if err != nil || m <= 0 {
+				log.Printf("alarm %s: invalid snooze %q", parts[1], p)
+				return
+			}
+			d = time.Duration(m) * time.Minute
+		}
+		a.Snooze(d)
+	case "skip":
+		switch p {
+		case "", "true":
+			a.Skip(true)
+		case "false":
+			a.Skip(false)
+		default:
+			log.Printf("alarm %s: invalid skip %q", parts[1], p)
+		}
+	}
+}
+
 // ResetDefault initializes the default alarms.
 func (c *Config) ResetDefault() {
 	c.Alarms = map[string]*Alarm{
@@ -167,13 +483,24 @@ func (c *Config) ResetDefault() {
 
 // Validate confirms the settings are valid.
 func (c *Config) Validate() error {
+	if c.Position != nil {
+		if err := c.Position.Validate(); err != nil {
+			return err
+		}
+	}
 	for name, a := range c.Alarms {
 		if len(name) == 0 {
 			return errors.New("alarm without a name")
 		}
+		if strings.ContainsAny(name, "/+#") {
+			return fmt.Errorf("alarm %q: name can't contain '/', '+' or '#'", name)
+		}
 		if err := a.Validate(); err != nil {
 			return fmt.Errorf("can't validate alarm %s: %v", name, err)
 		}
+		if len(a.Solar) != 0 && c.Position == nil {
+			return fmt.Errorf("can't validate alarm %s: Position is required to use %s", name, a.Solar)
+		}
 	}
 	return nil
 }
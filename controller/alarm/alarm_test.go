@@ -0,0 +1,386 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
+)
+
+func TestNext_Solar(t *testing.T) {
+	loc, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Skip(err)
+	}
+	montreal := &sun.Position{Latitude: 45.5017, Longitude: -73.5673}
+	a := Alarm{
+		Enabled: true,
+		Solar:   sun.Sunset,
+		Offset:  -30,
+		Days:    Saturday | Sunday,
+		pos:     montreal,
+	}
+	if err := a.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if s := a.String(); s != "sunset-30m (S•••••S)" {
+		t.Fatal(s)
+	}
+	data := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		// Thursday, the next trigger is Saturday.
+		{time.Date(2018, 11, 1, 12, 0, 0, 0, loc), time.Date(2018, 11, 3, 17, 10, 0, 0, loc)},
+		// Saturday, before sunset-30m. Daylight saving time ends the next day.
+		{time.Date(2018, 11, 3, 12, 0, 0, 0, loc), time.Date(2018, 11, 3, 17, 10, 0, 0, loc)},
+		// Saturday, after sunset-30m. The sun sets an hour earlier on Sunday.
+		{time.Date(2018, 11, 3, 18, 0, 0, 0, loc), time.Date(2018, 11, 4, 16, 9, 0, 0, loc)},
+	}
+	for i, line := range data {
+		actual := a.Next(line.now)
+		if diff := actual.Sub(line.expected); diff < -3*time.Minute || diff > 3*time.Minute {
+			t.Fatalf("%d: expected %s; got %s", i, line.expected, actual)
+		}
+	}
+
+	c := Config{Alarms: map[string]*Alarm{"a": &a}}
+	if err := c.Validate(); err == nil {
+		t.Fatal("expected error")
+	}
+	c.Position = montreal
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNext_DST(t *testing.T) {
+	montreal, err := time.LoadLocation("America/Montreal")
+	if err != nil {
+		t.Skip(err)
+	}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	all := Sunday | Monday | Tuesday | Wednesday | Thursday | Friday | Saturday
+	data := []struct {
+		name     string
+		a        *Alarm
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			"plain",
+			&Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday},
+			time.Date(2018, 3, 7, 12, 0, 0, 0, montreal),
+			time.Date(2018, 3, 12, 6, 35, 0, 0, montreal),
+		},
+		{
+			"disabled",
+			&Alarm{Hour: 6, Minute: 35, Days: all},
+			time.Date(2018, 3, 7, 12, 0, 0, 0, montreal),
+			time.Time{},
+		},
+		{
+			"same minute",
+			&Alarm{Enabled: true, Hour: 6, Minute: 35, Days: all},
+			time.Date(2018, 3, 7, 6, 35, 0, 0, montreal),
+			time.Date(2018, 3, 8, 6, 35, 0, 0, montreal),
+		},
+		{
+			"spring forward keeps the wall clock",
+			&Alarm{Enabled: true, Hour: 12, Days: all},
+			time.Date(2018, 3, 10, 13, 0, 0, 0, montreal),
+			time.Date(2018, 3, 11, 12, 0, 0, 0, montreal),
+		},
+		{
+			"spring forward skipped time",
+			&Alarm{Enabled: true, Hour: 2, Minute: 30, Days: all},
+			time.Date(2018, 3, 10, 12, 0, 0, 0, montreal),
+			time.Date(2018, 3, 11, 3, 0, 0, 0, montreal),
+		},
+		{
+			"spring forward skipped time already triggered",
+			&Alarm{Enabled: true, Hour: 2, Minute: 30, Days: all},
+			time.Date(2018, 3, 11, 3, 0, 0, 0, montreal),
+			time.Date(2018, 3, 12, 2, 30, 0, 0, montreal),
+		},
+		{
+			"spring forward skipped time Europe",
+			&Alarm{Enabled: true, Hour: 2, Minute: 30, Days: all},
+			time.Date(2018, 3, 24, 12, 0, 0, 0, paris),
+			time.Date(2018, 3, 25, 3, 0, 0, 0, paris),
+		},
+		{
+			"fall back keeps the wall clock",
+			&Alarm{Enabled: true, Hour: 12, Days: all},
+			time.Date(2018, 11, 3, 13, 0, 0, 0, montreal),
+			time.Date(2018, 11, 4, 12, 0, 0, 0, montreal),
+		},
+		{
+			"fall back repeated time",
+			&Alarm{Enabled: true, Hour: 1, Minute: 30, Days: all},
+			time.Date(2018, 11, 3, 12, 0, 0, 0, montreal),
+			time.Date(2018, 11, 4, 5, 30, 0, 0, time.UTC),
+		},
+		{
+			"fall back repeated time triggers once",
+			&Alarm{Enabled: true, Hour: 1, Minute: 30, Days: all, Location: "America/Montreal"},
+			time.Date(2018, 11, 4, 5, 30, 0, 0, time.UTC),
+			time.Date(2018, 11, 5, 1, 30, 0, 0, montreal),
+		},
+		{
+			"fall back repeated time Europe",
+			&Alarm{Enabled: true, Hour: 2, Minute: 30, Days: all},
+			time.Date(2018, 10, 27, 12, 0, 0, 0, paris),
+			time.Date(2018, 10, 28, 0, 30, 0, 0, time.UTC),
+		},
+		{
+			"location",
+			&Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday, Location: "America/Montreal"},
+			time.Date(2018, 3, 12, 6, 0, 0, 0, time.UTC),
+			time.Date(2018, 3, 12, 6, 35, 0, 0, montreal),
+		},
+		{
+			"location late",
+			&Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday, Location: "Europe/Paris"},
+			time.Date(2018, 3, 12, 6, 0, 0, 0, time.UTC),
+			time.Date(2018, 3, 19, 6, 35, 0, 0, paris),
+		},
+	}
+	for _, line := range data {
+		t.Run(line.name, func(t *testing.T) {
+			if err := line.a.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if actual := line.a.Next(line.now); !actual.Equal(line.expected) {
+				t.Fatalf("expected %s; got %s", line.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPoll(t *testing.T) {
+	loc := time.FixedZone("X", 0)
+	a := Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday | Tuesday}
+	defer a.Stop()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.armLocked(time.Date(2018, 3, 12, 6, 0, 0, 0, loc))
+	if expected := time.Date(2018, 3, 12, 6, 35, 0, 0, loc); !a.next.Equal(expected) {
+		t.Fatal(a.next)
+	}
+	if a.pollLocked(time.Date(2018, 3, 12, 6, 34, 0, 0, loc)) {
+		t.Fatal("too early")
+	}
+	if !a.pollLocked(time.Date(2018, 3, 12, 6, 35, 0, 0, loc)) {
+		t.Fatal("expected trigger")
+	}
+	// The wall clock went back; it must not trigger twice.
+	a.armLocked(time.Date(2018, 3, 12, 6, 30, 0, 0, loc))
+	if expected := time.Date(2018, 3, 13, 6, 35, 0, 0, loc); !a.next.Equal(expected) {
+		t.Fatal(a.next)
+	}
+	// The wall clock jumped forward way past the trigger; it is skipped.
+	if a.pollLocked(time.Date(2018, 3, 13, 9, 0, 0, 0, loc)) {
+		t.Fatal("expected skip")
+	}
+	if expected := time.Date(2018, 3, 19, 6, 35, 0, 0, loc); !a.next.Equal(expected) {
+		t.Fatal(a.next)
+	}
+}
+
+func TestNext_Dates(t *testing.T) {
+	loc := time.FixedZone("X", 0)
+	weekdays := Monday | Tuesday | Wednesday | Thursday | Friday
+	data := []struct {
+		name     string
+		a        *Alarm
+		s        string
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			"one-shot",
+			&Alarm{Enabled: true, Hour: 7, Date: "2018-12-25"},
+			"07:00 on 2018-12-25",
+			time.Date(2018, 3, 12, 6, 0, 0, 0, loc),
+			time.Date(2018, 12, 25, 7, 0, 0, 0, loc),
+		},
+		{
+			"one-shot passed",
+			&Alarm{Enabled: true, Hour: 7, Date: "2018-12-25"},
+			"07:00 on 2018-12-25",
+			time.Date(2018, 12, 25, 7, 0, 0, 0, loc),
+			time.Time{},
+		},
+		{
+			"range not started",
+			&Alarm{Enabled: true, Hour: 7, Days: weekdays, From: "2018-09-01", Until: "2019-06-30"},
+			"07:00 (•MTWTF•) from 2018-09-01 until 2019-06-30",
+			time.Date(2018, 7, 1, 6, 0, 0, 0, loc),
+			time.Date(2018, 9, 3, 7, 0, 0, 0, loc),
+		},
+		{
+			"range ended",
+			&Alarm{Enabled: true, Hour: 7, Days: weekdays, From: "2018-09-01", Until: "2019-06-30"},
+			"07:00 (•MTWTF•) from 2018-09-01 until 2019-06-30",
+			time.Date(2019, 6, 28, 8, 0, 0, 0, loc),
+			time.Time{},
+		},
+		{
+			"except",
+			&Alarm{Enabled: true, Hour: 7, Days: weekdays, Except: []Date{"2018-12-24", "2018-12-25"}},
+			"07:00 (•MTWTF•) except 2 days",
+			time.Date(2018, 12, 22, 8, 0, 0, 0, loc),
+			time.Date(2018, 12, 26, 7, 0, 0, 0, loc),
+		},
+	}
+	for _, line := range data {
+		t.Run(line.name, func(t *testing.T) {
+			if err := line.a.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if s := line.a.String(); s != line.s {
+				t.Fatal(s)
+			}
+			if actual := line.a.Next(line.now); !actual.Equal(line.expected) {
+				t.Fatalf("expected %s; got %s", line.expected, actual)
+			}
+		})
+	}
+
+	bad := []*Alarm{
+		{Date: "2018-13-01"},
+		{Date: "2018-12-01", Days: Monday},
+		{From: "2018-12-01", Until: "2018-11-01"},
+		{Except: []Date{"tomorrow"}},
+		{ExceptICS: "does/not/exist.ics"},
+	}
+	for i, a := range bad {
+		if err := a.Validate(); err == nil {
+			t.Fatalf("%d: expected error", i)
+		}
+	}
+}
+
+func TestParseICS(t *testing.T) {
+	const ics = "BEGIN:VCALENDAR\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Christmas\r\n" +
+		"DTSTART;VALUE=DATE:20181225\r\n" +
+		"DTEND;VALUE=DATE:20181226\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Ski\r\n" +
+		" trip\r\n" +
+		"DTSTART:20190302T080000Z\r\n" +
+		"DTEND:20190304T180000Z\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;VALUE=DATE:20190101\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	actual, err := parseICS(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[Date]bool{"2018-12-25": true, "2019-03-02": true, "2019-03-03": true, "2019-03-04": true, "2019-01-01": true}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatal(actual)
+	}
+	if _, err := parseICS(strings.NewReader("BEGIN:VEVENT\nDTSTART:foo\nEND:VEVENT\n")); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPoll_SkipSnooze(t *testing.T) {
+	loc := time.FixedZone("X", 0)
+	a := Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday | Tuesday}
+	defer a.Stop()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.SkipNext = time.Date(2018, 3, 12, 6, 35, 0, 0, loc)
+	a.armLocked(time.Date(2018, 3, 12, 6, 0, 0, 0, loc))
+	if a.pollLocked(time.Date(2018, 3, 12, 6, 35, 0, 0, loc)) {
+		t.Fatal("expected skip")
+	}
+	if !a.SkipNext.IsZero() {
+		t.Fatal("SkipNext must be cleared")
+	}
+	if !a.pollLocked(time.Date(2018, 3, 13, 6, 35, 0, 0, loc)) {
+		t.Fatal("expected trigger")
+	}
+
+	// Snoozing triggers the alarm again even if SkipNext is set.
+	a.snooze = time.Date(2018, 3, 13, 6, 44, 0, 0, loc)
+	a.SkipNext = time.Date(2018, 3, 19, 6, 35, 0, 0, loc)
+	a.armLocked(time.Date(2018, 3, 13, 6, 35, 1, 0, loc))
+	if !a.next.Equal(a.snooze) {
+		t.Fatal(a.next)
+	}
+	if !a.pollLocked(time.Date(2018, 3, 13, 6, 44, 0, 0, loc)) {
+		t.Fatal("expected trigger")
+	}
+	if !a.snooze.IsZero() {
+		t.Fatal("snooze must be cleared")
+	}
+	if expected := time.Date(2018, 3, 19, 6, 35, 0, 0, loc); !a.next.Equal(expected) {
+		t.Fatal(a.next)
+	}
+	if a.pollLocked(time.Date(2018, 3, 19, 6, 35, 0, 0, loc)) {
+		t.Fatal("expected skip")
+	}
+}
+
+func TestAlarm_Concurrent(t *testing.T) {
+	// Meant to be run with -race.
+	b := msgbus.New()
+	defer b.Close()
+	a := &Alarm{Enabled: true, Hour: 6, Minute: 35, Days: Monday, Location: "UTC"}
+	c := Config{Alarms: map[string]*Alarm{"a": a}}
+	if err := Init(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			a.Skip(i%2 == 0)
+			if err := a.Reset(b); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if a.Next(time.Now()).IsZero() {
+			t.Fatal("expected a trigger")
+		}
+		if _, err := json.Marshal(&c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	a.Skip(true)
+	raw, err := json.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out Alarm
+	if err := json.Unmarshal(raw, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.SkipNext.IsZero() || !out.SkipNext.Equal(a.Next(time.Now())) {
+		t.Fatalf("SkipNext not persisted: %s", raw)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Date is a calendar day in the form YYYY-MM-DD.
+type Date string
+
+const dateLayout = "2006-01-02"
+
+// dateOf returns the calendar day of t in its location.
+func dateOf(t time.Time) Date {
+	return Date(t.Format(dateLayout))
+}
+
+// Validate confirms the date is valid.
+func (d Date) Validate() error {
+	if _, err := time.Parse(dateLayout, string(d)); err != nil {
+		return fmt.Errorf("invalid date %q, expected YYYY-MM-DD", d)
+	}
+	return nil
+}
+
+// day returns midnight on this calendar day in location loc.
+func (d Date) day(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(dateLayout, string(d), loc)
+}
+
+// loadICS returns all the calendar days covered by the events in an iCalendar
+// file.
+//
+// Only DTSTART and DTEND are looked at; recurrence rules are ignored.
+func loadICS(path string) (map[Date]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	out, err := parseICS(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return out, nil
+}
+
+func parseICS(r io.Reader) (map[Date]bool, error) {
+	// Unfold the content lines first, per RFC 5545 section 3.1.
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		l := strings.TrimRight(s.Text(), "\r")
+		if len(l) != 0 && (l[0] == ' ' || l[0] == '\t') && len(lines) != 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	out := map[Date]bool{}
+	inEvent := false
+	var start, end string
+	for i, l := range lines {
+		name, value := l, ""
+		if j := strings.IndexByte(l, ':'); j != -1 {
+			name, value = l[:j], l[j+1:]
+		}
+		// Strip the parameters, e.g. DTSTART;VALUE=DATE.
+		if j := strings.IndexByte(name, ';'); j != -1 {
+			name = name[:j]
+		}
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if value == "VEVENT" {
+				inEvent = true
+				start, end = "", ""
+			}
+		case "DTSTART":
+			start = value
+		case "DTEND":
+			end = value
+		case "END":
+			if value != "VEVENT" || !inEvent {
+				continue
+			}
+			inEvent = false
+			if err := addICSEvent(out, start, end); err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+1, err)
+			}
+		}
+	}
+	return out, nil
+}
+
+// addICSEvent adds the days covered by the event to out.
+//
+// An all-day event's DTEND is exclusive. A timed event covers the day it ends
+// on, unless it ends at midnight.
+func addICSEvent(out map[Date]bool, start, end string) error {
+	s, _, err := parseICSTime(start)
+	if err != nil {
+		return fmt.Errorf("DTSTART: %v", err)
+	}
+	if len(end) == 0 {
+		out[dateOf(s)] = true
+		return nil
+	}
+	e, allDay, err := parseICSTime(end)
+	if err != nil {
+		return fmt.Errorf("DTEND: %v", err)
+	}
+	if allDay || (e.Hour() == 0 && e.Minute() == 0 && e.Second() == 0) {
+		e = e.AddDate(0, 0, -1)
+	}
+	for d := s; !d.After(e); d = d.AddDate(0, 0, 1) {
+		out[dateOf(d)] = true
+	}
+	// Always include the first day, even if DTEND <= DTSTART.
+	out[dateOf(s)] = true
+	return nil
+}
+
+// parseICSTime parses a DATE or DATE-TIME value. The time zone is ignored.
+func parseICSTime(v string) (time.Time, bool, error) {
+	if len(v) == 8 {
+		t, err := time.Parse("20060102", v)
+		return t, true, err
+	}
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(v, "Z"))
+	return t, false, err
+}
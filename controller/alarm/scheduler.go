@@ -0,0 +1,202 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package alarm
+
+import (
+	"log"
+	"time"
+
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/msgbus"
+)
+
+const (
+	// pollPeriod is the maximum duration the scheduler sleeps before looking at
+	// the wall clock again.
+	//
+	// time.Timer is based on the monotonic clock so it doesn't notice when the
+	// wall clock jumps, for example when NTP syncs after boot on a Raspberry Pi
+	// without a RTC.
+	pollPeriod = time.Minute
+	// maxLate is how late a trigger can be processed. A trigger later than
+	// this means the wall clock jumped forward over it, so it is skipped.
+	maxLate = 2 * pollPeriod
+	// maxJump is the difference between the wall clock and the monotonic clock
+	// that is considered a wall clock jump.
+	maxJump = 5 * time.Second
+)
+
+// DefaultSnooze is the default snooze duration.
+const DefaultSnooze = 9 * time.Minute
+
+// Reset reinitializes with a message bus.
+func (a *Alarm) Reset(b msgbus.Bus) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopLocked()
+	a.b = b
+	a.armLocked(time.Now())
+	return nil
+}
+
+// Stop disarms the alarm.
+//
+// It is guaranteed that the alarm will not trigger after Stop returns.
+func (a *Alarm) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopLocked()
+}
+
+// Snooze cancels the pending steps and triggers the alarm again after d.
+func (a *Alarm) Snooze(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cancelStepsLocked()
+	now := time.Now()
+	a.snooze = now.Add(d)
+	if a.b != nil {
+		a.armLocked(now)
+	}
+}
+
+// Snoozed returns when the snoozed alarm will trigger, or zero if the alarm is
+// not snoozed.
+func (a *Alarm) Snoozed() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snooze
+}
+
+// Skip skips the next trigger, or cancels a previous skip.
+//
+// The skip is kept in SkipNext so it is persisted.
+func (a *Alarm) Skip(skip bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.SkipNext = time.Time{}
+	if skip {
+		a.SkipNext = a.nextLocked(time.Now())
+	}
+}
+
+// IsSkipped returns true if the trigger at next will be skipped.
+func (a *Alarm) IsSkipped(next time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return !a.SkipNext.IsZero() && !next.After(a.SkipNext)
+}
+
+func (a *Alarm) stopLocked() {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	a.cancelStepsLocked()
+	a.b = nil
+	a.next = time.Time{}
+	a.snooze = time.Time{}
+}
+
+func (a *Alarm) cancelStepsLocked() {
+	for _, t := range a.steps {
+		t.Stop()
+	}
+	a.steps = nil
+}
+
+// armLocked calculates the next trigger and arms the timer.
+func (a *Alarm) armLocked(now time.Time) {
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	from := now
+	if a.last.After(from) {
+		// The wall clock went back after the last trigger.
+		from = a.last
+	}
+	a.armed = now
+	a.next = a.nextLocked(from)
+	if !a.snooze.IsZero() && (a.next.IsZero() || a.snooze.Before(a.next)) {
+		a.next = a.snooze
+	}
+	if a.next.IsZero() {
+		return
+	}
+	d := a.next.Sub(now)
+	if d > pollPeriod {
+		d = pollPeriod
+	}
+	a.timer = time.AfterFunc(d, a.wake)
+}
+
+func (a *Alarm) wake() {
+	a.mu.Lock()
+	b := a.b
+	if b == nil {
+		// Stopped while the timer was firing.
+		a.mu.Unlock()
+		return
+	}
+	fire := a.pollLocked(time.Now())
+	cmd := a.Cmd
+	if fire {
+		a.startStepsLocked()
+	}
+	a.mu.Unlock()
+	if fire && len(cmd.Topic) != 0 {
+		publish(b, cmd)
+	}
+}
+
+// startStepsLocked arms a timer for each step.
+func (a *Alarm) startStepsLocked() {
+	a.cancelStepsLocked()
+	for _, st := range a.Steps {
+		cmd := st.Cmd
+		a.steps = append(a.steps, time.AfterFunc(time.Duration(st.Delay)*time.Second, func() {
+			a.mu.Lock()
+			b := a.b
+			a.mu.Unlock()
+			if b != nil {
+				publish(b, cmd)
+			}
+		}))
+	}
+}
+
+func publish(b msgbus.Bus, cmd rules.Command) {
+	if err := b.Publish(cmd.ToMsg(), msgbus.ExactlyOnce); err != nil {
+		log.Printf("failed to publish command %v", cmd)
+	}
+}
+
+// pollLocked rearms the timer and returns true if the alarm shall trigger.
+func (a *Alarm) pollLocked(now time.Time) bool {
+	// Round(0) strips the monotonic clock reading.
+	if j := now.Round(0).Sub(a.armed.Round(0)) - now.Sub(a.armed); j > maxJump || j < -maxJump {
+		log.Printf("alarm %s: wall clock jumped by %s", a, j)
+	}
+	fire := false
+	if !a.next.IsZero() && !now.Before(a.next) {
+		if late := now.Sub(a.next); late > maxLate {
+			log.Printf("alarm %s: skipping trigger %s that is %s late", a, a.next, late)
+		} else if a.next.Equal(a.snooze) {
+			fire = true
+		} else if !a.SkipNext.IsZero() && !a.next.After(a.SkipNext) {
+			log.Printf("alarm %s: skipping trigger %s as requested", a, a.next)
+			a.SkipNext = time.Time{}
+		} else {
+			fire = true
+		}
+		if a.next.Equal(a.snooze) {
+			a.snooze = time.Time{}
+		}
+		a.last = a.next
+	}
+	a.armLocked(now)
+	return fire
+}
@@ -6,6 +6,9 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
@@ -19,20 +22,57 @@ import (
 // config contains all the configuration that the user can specify.
 type config struct {
 	// Not stored in MQTT
-	Alarms alarm.Config
-	Rules  rules.Rules
+	Alarms        alarm.Config
+	Rules         rules.Rules
+	StateMachines stateMachines
+	// IRCodes are the named IR codes available to all the IRTx nodes, usually
+	// learned with an IR node.
+	IRCodes map[string]string
 
 	// Stored in MQTT as nodes.Nodes
 	Devices map[nodes.ID]*nodes.Dev
 }
 
+// Validate validates all the settings.
+func (c *config) Validate() error {
+	if err := c.Alarms.Validate(); err != nil {
+		return err
+	}
+	if err := c.Rules.Validate(c.Alarms.Position); err != nil {
+		return err
+	}
+	if err := c.StateMachines.Validate(c.Alarms.Position); err != nil {
+		return err
+	}
+	if err := nodes.ValidateIRCodes(c.IRCodes); err != nil {
+		return fmt.Errorf("ir: %v", err)
+	}
+	for id, d := range c.Devices {
+		if err := id.Validate(); err != nil {
+			return err
+		}
+		if d == nil {
+			return fmt.Errorf("device %s: missing", id)
+		}
+		if err := d.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // db is all the settings and values that are persisted on disk.
 type db struct {
-	mu     sync.Mutex
-	Config config
+	mu sync.Mutex
+	// Version is the schema version, see migrations.
+	Version int
+	Config  config
 	// AnimLRU is saved outside of Config because these are not meant to be
 	// "updated" by the user, they are a side-effect.
 	AnimLRU animLRU
+
+	// path is where the database is saved. Nothing is saved when empty.
+	path string
 }
 
 func (d *db) load(n string) error {
@@ -42,47 +82,90 @@ func (d *db) load(n string) error {
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Ignore if the file is not present.
+			d.path = n
+			d.Version = schemaVersion
 			return nil
 		}
 		return err
 	}
 	defer f.Close()
-	j := json.NewDecoder(f)
-	j.UseNumber()
-	return j.Decode(d)
+	if err := d.decodeLocked(f); err != nil {
+		return fmt.Errorf("%s: %v", n, err)
+	}
+	// Only save back when the file was successfully loaded, to not overwrite
+	// the user's settings with partial data.
+	d.path = n
+	return nil
+}
+
+// decodeLocked decodes, migrates and validates the settings.
+func (d *db) decodeLocked(r io.Reader) error {
+	if err := decodeMigrated(r, d); err != nil {
+		return err
+	}
+	return d.Config.Validate()
+}
+
+// CheckConfig loads, migrates and validates a settings file without using it.
+func CheckConfig(n string) error {
+	f, err := os.Open(n)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	d := db{}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.decodeLocked(f)
 }
 
-func (d *db) save(n string) error {
+func (d *db) save() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	b, err := json.MarshalIndent(d, "", "  ")
+	return d.saveLocked()
+}
+
+func (d *db) saveLocked() error {
+	if len(d.path) == 0 {
+		return nil
+	}
+	return writeJSONAtomic(d.path, d)
+}
+
+// writeJSONAtomic writes v as JSON to a temporary file then renames it to n,
+// so that n is never left partially written, even upon power loss.
+func writeJSONAtomic(n string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
-	f, err := os.Create(n)
+	f, err := ioutil.TempFile(filepath.Dir(n), filepath.Base(n)+".tmp")
 	if err != nil {
 		return err
 	}
-	if _, err = f.Write(append(b, '\n')); err != nil {
-		f.Close()
-		return err
+	if _, err = f.Write(append(b, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), n)
 	}
-	return f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
 }
 
 type dbMgr struct {
 	db
-	path string
 }
 
 func (d *dbMgr) Load() error {
-	d.path = filepath.Join(shared.Home(), "dlibox.json")
-	return d.db.load(d.path)
+	return d.db.load(filepath.Join(shared.Home(), "dlibox.json"))
 }
 
 func (d *dbMgr) Close() error {
-	if len(d.path) != 0 {
-		return d.db.save(d.path)
-	}
-	return nil
+	return d.db.save()
 }
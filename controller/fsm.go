@@ -0,0 +1,385 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
+)
+
+// transition moves a state machine to state To when Signal fires.
+type transition struct {
+	Signal rules.Signal
+	To     string
+}
+
+// stateCfg is a state in a state machine.
+type stateCfg struct {
+	// Transitions are evaluated in order, the first one that fires wins. A
+	// transition to the current state restarts the idle timer.
+	Transitions []transition
+	// IdleAfter is the number of seconds after which the state machine goes to
+	// IdleState. 0 means never.
+	IdleAfter int
+	// IdleState defaults to the state machine's Initial state.
+	IdleState string
+	Enter     []rules.Command
+	Exit      []rules.Command
+}
+
+// stateMachineCfg is a user defined finite state machine.
+//
+// Its state is published as retained "<name>/state". Publishing a state to
+// "<name>/state/set" forces the state machine into this state.
+type stateMachineCfg struct {
+	Enabled bool
+	Initial string
+	States  map[string]*stateCfg
+}
+
+func (s *stateMachineCfg) Validate(pos *sun.Position) error {
+	if _, ok := s.States[s.Initial]; !ok {
+		return fmt.Errorf("unknown Initial state %q", s.Initial)
+	}
+	for name, st := range s.States {
+		if len(name) == 0 {
+			return errors.New("state without a name")
+		}
+		for i, t := range st.Transitions {
+			if err := t.Signal.Validate(pos); err != nil {
+				return fmt.Errorf("state %s: transition %d: %v", name, i, err)
+			}
+			if _, ok := s.States[t.To]; !ok {
+				return fmt.Errorf("state %s: transition %d: unknown state %q", name, i, t.To)
+			}
+		}
+		if st.IdleAfter < 0 {
+			return fmt.Errorf("state %s: invalid IdleAfter", name)
+		}
+		if len(st.IdleState) != 0 {
+			if _, ok := s.States[st.IdleState]; !ok {
+				return fmt.Errorf("state %s: unknown IdleState %q", name, st.IdleState)
+			}
+		}
+		for i := range st.Enter {
+			if err := st.Enter[i].Validate(); err != nil {
+				return fmt.Errorf("state %s: Enter %d: %v", name, i, err)
+			}
+		}
+		for i := range st.Exit {
+			if err := st.Exit[i].Validate(); err != nil {
+				return fmt.Errorf("state %s: Exit %d: %v", name, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// stateMachines is all the named state machines.
+type stateMachines map[string]*stateMachineCfg
+
+func (s stateMachines) Validate(pos *sun.Position) error {
+	for name, sm := range s {
+		if len(name) == 0 || strings.ContainsAny(name, "/+#$") {
+			return fmt.Errorf("invalid state machine name %q", name)
+		}
+		if err := sm.Validate(pos); err != nil {
+			return fmt.Errorf("state machine %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// halloweenPreset returns the state machine for the little monsters (children)
+// walking in front of the house then coming to the door.
+//
+// The PIR nodes publish the time of each motion, so only the topic is matched.
+func halloweenPreset() *stateMachineCfg {
+	return &stateMachineCfg{
+		Initial: "idle",
+		States: map[string]*stateCfg{
+			// idle is the animation while nothing in happening.
+			"idle": {
+				Transitions: []transition{
+					{"+/street/pir", "incoming"},
+					{"+/porch/pir", "porch"},
+				},
+				Enter: []rules.Command{{Topic: "painter/setautomated", Payload: "{\"C\":\"#ff9000\",\"_type\":\"NightStars\"}"}},
+			},
+			// incoming is when children are walking in front of the house.
+			"incoming": {
+				Transitions: []transition{
+					{"+/street/pir", "incoming"},
+				},
+				IdleAfter: 15,
+				Enter:     []rules.Command{{Topic: "painter/setautomated", Payload: "{\"Child\":\"Lff0000ff0000ee0000dd0000cc0000bb0000aa0000990000880000770000660000550000440000330000220000110000\",\"MovePerHour\":108000,\"_type\":\"PingPong\"}"}},
+			},
+			// porch is when the children are in front of the door.
+			"porch": {
+				Transitions: []transition{
+					{"+/street/pir", "incoming"},
+					{"+/porch/pir", "porch"},
+				},
+				IdleAfter: 15,
+				Enter:     []rules.Command{{Topic: "painter/setautomated", Payload: "\"#ff7f00\""}},
+			},
+		},
+	}
+}
+
+// fsmRunner runs all the state machines.
+type fsmRunner struct {
+	b    msgbus.Bus
+	l    *busListener
+	id   int // Handler ID in l.
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	machines map[string]*stateMachine
+	pending  []msgbus.Message // Published in order by publishLoop.
+}
+
+// stateMachine is a running state machine.
+type stateMachine struct {
+	name        string
+	cfg         *stateMachineCfg
+	transitions map[string][]compiledTransition
+	state       string
+	timerIdle   *time.Timer
+}
+
+type compiledTransition struct {
+	expr rules.Expr
+	to   string
+}
+
+// initFSM starts the enabled state machines.
+//
+// pos is used to calculate the solar events, it can be nil.
+func initFSM(l *busListener, cfg stateMachines, pos *sun.Position) (*fsmRunner, error) {
+	f := &fsmRunner{b: l.b, l: l, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	if err := f.reset(cfg, pos); err != nil {
+		return nil, err
+	}
+	f.wg.Add(1)
+	go f.publishLoop()
+	// Listen to all messages, since we don't know the one that could be used in
+	// the signals.
+	f.id = l.add(f.onMsg)
+	return f, nil
+}
+
+// Close stops all the state machines.
+func (f *fsmRunner) Close() error {
+	f.l.remove(f.id)
+	f.mu.Lock()
+	for _, m := range f.machines {
+		m.stopIdle()
+	}
+	f.machines = nil
+	f.mu.Unlock()
+	close(f.done)
+	f.wg.Wait()
+	return nil
+}
+
+// reset replaces the state machines.
+//
+// On failure, the previous state machines are kept.
+func (f *fsmRunner) reset(cfg stateMachines, pos *sun.Position) error {
+	machines, err := compileFSM(cfg, pos)
+	if err != nil {
+		return err
+	}
+	f.set(machines)
+	return nil
+}
+
+// compileFSM validates the state machines and compiles the enabled ones.
+func compileFSM(cfg stateMachines, pos *sun.Position) (map[string]*stateMachine, error) {
+	if err := cfg.Validate(pos); err != nil {
+		return nil, err
+	}
+	machines := map[string]*stateMachine{}
+	for name, c := range cfg {
+		if !c.Enabled {
+			continue
+		}
+		m := &stateMachine{name: name, cfg: c, transitions: map[string][]compiledTransition{}}
+		for sname, st := range c.States {
+			for i, t := range st.Transitions {
+				e, err := t.Signal.Parse(pos)
+				if err != nil {
+					return nil, fmt.Errorf("state machine %s: state %s: transition %d: %v", name, sname, i, err)
+				}
+				m.transitions[sname] = append(m.transitions[sname], compiledTransition{e, t.To})
+			}
+		}
+		machines[name] = m
+	}
+	return machines, nil
+}
+
+// set replaces the running state machines with the ones returned by
+// compileFSM.
+//
+// A state machine that is still defined keeps its current state if it still
+// exists.
+func (f *fsmRunner) set(machines map[string]*stateMachine) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old := f.machines
+	f.machines = machines
+	for name, m := range machines {
+		if o := old[name]; o != nil {
+			if _, ok := m.cfg.States[o.state]; ok {
+				m.state = o.state
+			}
+		}
+	}
+	for _, m := range old {
+		m.stopIdle()
+	}
+	for _, m := range f.machines {
+		if len(m.state) == 0 {
+			f.enterLocked(m, m.cfg.Initial)
+		} else {
+			f.armIdleLocked(m)
+		}
+	}
+}
+
+// getStates returns the current state of each running state machine.
+func (f *fsmRunner) getStates() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]string, len(f.machines))
+	for name, m := range f.machines {
+		out[name] = m.state
+	}
+	return out
+}
+
+func (f *fsmRunner) onMsg(msg msgbus.Message) {
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, m := range f.machines {
+		if msg.Topic == name+"/state/set" {
+			// Forced state, e.g.
+			// "mosquitto_pub -t dlibox/halloween/state/set -m idle".
+			s := string(msg.Payload)
+			if _, ok := m.cfg.States[s]; !ok {
+				log.Printf("fsm %s: state is invalid: %q", name, s)
+				continue
+			}
+			if s != m.state {
+				f.enterLocked(m, s)
+			}
+			continue
+		}
+		for _, t := range m.transitions[m.state] {
+			if t.expr.Eval(msg, now) {
+				if t.to == m.state {
+					// Didn't change state, only restart the idle timer.
+					f.armIdleLocked(m)
+				} else {
+					f.enterLocked(m, t.to)
+				}
+				break
+			}
+		}
+	}
+}
+
+// enterLocked runs the Exit commands of the current state, then the Enter
+// commands of the new one and publishes the new state.
+func (f *fsmRunner) enterLocked(m *stateMachine, s string) {
+	if old := m.cfg.States[m.state]; old != nil {
+		for _, cmd := range old.Exit {
+			f.pending = append(f.pending, cmd.ToMsg())
+		}
+	}
+	log.Printf("fsm %s: %q -> %q", m.name, m.state, s)
+	m.state = s
+	for _, cmd := range m.cfg.States[s].Enter {
+		f.pending = append(f.pending, cmd.ToMsg())
+	}
+	f.pending = append(f.pending, msgbus.Message{Topic: m.name + "/state", Payload: []byte(s), Retained: true})
+	f.armIdleLocked(m)
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publishLoop publishes the pending messages in order.
+//
+// Publishing is done outside of onMsg since the messages are delivered back
+// to the subscription and Publish() could block until it is read.
+func (f *fsmRunner) publishLoop() {
+	defer f.wg.Done()
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.wake:
+		}
+		f.mu.Lock()
+		msgs := f.pending
+		f.pending = nil
+		f.mu.Unlock()
+		for _, msg := range msgs {
+			if err := f.b.Publish(msg, msgbus.ExactlyOnce); err != nil {
+				log.Printf("fsm: failed to publish %s: %v", msg.Topic, err)
+			}
+		}
+	}
+}
+
+func (f *fsmRunner) armIdleLocked(m *stateMachine) {
+	m.stopIdle()
+	st := m.cfg.States[m.state]
+	if st.IdleAfter == 0 {
+		return
+	}
+	idle := st.IdleState
+	if len(idle) == 0 {
+		idle = m.cfg.Initial
+	}
+	if idle == m.state {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(time.Duration(st.IdleAfter)*time.Second, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if m.timerIdle != t {
+			// Canceled while the timer was firing.
+			return
+		}
+		m.timerIdle = nil
+		log.Printf("fsm %s: going back %s", m.name, idle)
+		f.enterLocked(m, idle)
+	})
+	m.timerIdle = t
+}
+
+func (m *stateMachine) stopIdle() {
+	if m.timerIdle != nil {
+		m.timerIdle.Stop()
+		m.timerIdle = nil
+	}
+}
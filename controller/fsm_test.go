@@ -0,0 +1,269 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/msgbus"
+)
+
+func TestFSM(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("leds/#", msgbus.BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	door := &stateMachineCfg{
+		Enabled: true,
+		Initial: "closed",
+		States: map[string]*stateCfg{
+			"closed": {
+				Transitions: []transition{{"+/door/open == \"true\"", "open"}},
+				Enter:       []rules.Command{{Topic: "leds/intensity", Payload: "0"}},
+			},
+			"open": {
+				IdleAfter: 1,
+				Enter:     []rules.Command{{Topic: "leds/intensity", Payload: "255"}},
+				Exit:      []rules.Command{{Topic: "leds/temperature", Payload: "3000"}},
+			},
+		},
+	}
+	cfg := stateMachines{"door": door, "halloween": halloweenPreset()}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := initFSM(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The local bus doesn't guarantee ordering nor uniqueness, so skip the
+	// messages that do not match.
+	expect := func(topic, payload string) {
+		for {
+			select {
+			case msg := <-c:
+				if msg.Topic == topic && string(msg.Payload) == payload {
+					return
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("didn't get %s=%q", topic, payload)
+			}
+		}
+	}
+	expect("leds/intensity", "0")
+	if s := f.getStates(); len(s) != 1 || s["door"] != "closed" {
+		t.Fatalf("unexpected states %v", s)
+	}
+
+	f.onMsg(msgbus.Message{Topic: "dev/door/open", Payload: []byte("false")})
+	f.onMsg(msgbus.Message{Topic: "dev/door/open", Payload: []byte("true")})
+	expect("leds/intensity", "255")
+	if s := f.getStates(); s["door"] != "open" {
+		t.Fatalf("unexpected states %v", s)
+	}
+	// Goes back to Initial after IdleAfter.
+	expect("leds/temperature", "3000")
+	expect("leds/intensity", "0")
+	if s := f.getStates(); s["door"] != "closed" {
+		t.Fatalf("unexpected states %v", s)
+	}
+
+	// Forced state.
+	door.States["open"].IdleAfter = 0
+	if err := f.reset(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.onMsg(msgbus.Message{Topic: "door/state/set", Payload: []byte("open")})
+	expect("leds/intensity", "255")
+	f.onMsg(msgbus.Message{Topic: "door/state/set", Payload: []byte("invalid")})
+	if s := f.getStates(); s["door"] != "open" {
+		t.Fatalf("unexpected states %v", s)
+	}
+
+	// Reloading keeps the current state.
+	if err := f.reset(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := f.getStates(); s["door"] != "open" {
+		t.Fatalf("unexpected states %v", s)
+	}
+	delete(door.States, "open")
+	door.States["closed"].Transitions = nil
+	if err := f.reset(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	expect("leds/intensity", "0")
+	if s := f.getStates(); s["door"] != "closed" {
+		t.Fatalf("unexpected states %v", s)
+	}
+}
+
+func TestFSM_Ordered(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	cfg := stateMachines{
+		"toggle": {
+			Enabled: true,
+			Initial: "off",
+			States: map[string]*stateCfg{
+				"off": {Transitions: []transition{{"button", "on"}}},
+				"on":  {Transitions: []transition{{"button", "off"}}},
+			},
+		},
+	}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := initFSM(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 51; i++ {
+		f.onMsg(msgbus.Message{Topic: "button", Payload: []byte("1")})
+	}
+	if s := f.getStates(); s["toggle"] != "on" {
+		t.Fatalf("unexpected states %v", s)
+	}
+	// Wait for the queue to be drained; Close() waits for the messages being
+	// published.
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		f.mu.Lock()
+		n := len(f.pending)
+		f.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("%d messages not published", n)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := b.Subscribe("toggle/state", msgbus.BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-c:
+		if string(msg.Payload) != "on" {
+			t.Fatalf("stale retained state %q", msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no retained state")
+	}
+}
+
+func TestFSM_Halloween(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	h := halloweenPreset()
+	h.Enabled = true
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := initFSM(l, stateMachines{"halloween": h}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// As published by a PIR node.
+	motion := []byte("1540944000 2018-10-31 00:00:00 +0000 UTC")
+	data := []struct {
+		topic    string
+		expected string
+	}{
+		{"dev1/street/pir", "incoming"},
+		{"dev1/porch/pir", "incoming"},
+		{"dev1/street/motion", "incoming"},
+		{"halloween/state/set", "idle"},
+		{"dev2/porch/pir", "porch"},
+		{"dev1/street/pir", "incoming"},
+	}
+	for i, line := range data {
+		payload := motion
+		if line.topic == "halloween/state/set" {
+			payload = []byte(line.expected)
+		}
+		f.onMsg(msgbus.Message{Topic: line.topic, Payload: payload})
+		if s := f.getStates()["halloween"]; s != line.expected {
+			t.Fatalf("#%d: %s != %s", i, line.expected, s)
+		}
+	}
+}
+
+func TestAPIFSMSet(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	h := halloweenPreset()
+	h.Enabled = true
+	cfg := stateMachines{"halloween": h, "door": h}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := initFSM(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := &db{}
+	d.Config.StateMachines = cfg
+	j := &jsonAPI{b: b, db: d, fsm: f}
+
+	data := []struct {
+		in   fsmSet
+		code int
+	}{
+		{fsmSet{Name: "door", State: "porch"}, 200},
+		{fsmSet{Name: "halloween", State: "incoming"}, 200},
+		{fsmSet{Name: "garage", State: "idle"}, 404},
+		{fsmSet{Name: "door", State: "open"}, 400},
+	}
+	for i, line := range data {
+		if _, code := j.apiFSMSet(line.in); code != line.code {
+			t.Fatalf("#%d: unexpected code %d", i, code)
+		}
+	}
+	// Only the named state machine is forced.
+	expected := map[string]string{"door": "porch", "halloween": "incoming"}
+	for start := time.Now(); !reflect.DeepEqual(f.getStates(), expected); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("unexpected states %v", f.getStates())
+		}
+	}
+}
+
+func TestStateMachines_Validate(t *testing.T) {
+	if err := (stateMachines{"halloween": halloweenPreset()}).Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	data := []stateMachines{
+		{"a/b": halloweenPreset()},
+		{"a": {Initial: "foo"}},
+		{"a": {Initial: "a", States: map[string]*stateCfg{"a": {Transitions: []transition{{"x", "b"}}}}}},
+		{"a": {Initial: "a", States: map[string]*stateCfg{"a": {Transitions: []transition{{"x ==", "a"}}}}}},
+		{"a": {Initial: "a", States: map[string]*stateCfg{"a": {IdleState: "b"}}}},
+		{"a": {Initial: "a", States: map[string]*stateCfg{"a": {IdleAfter: -1}}}},
+	}
+	for i, line := range data {
+		if err := line.Validate(nil); err == nil {
+			t.Fatalf("%d: expected error", i)
+		}
+	}
+}
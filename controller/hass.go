@@ -0,0 +1,322 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+)
+
+// hassPrefix is the Home Assistant MQTT discovery prefix.
+//
+// https://www.home-assistant.io/docs/mqtt/discovery/
+const hassPrefix = "homeassistant"
+
+// hassDevice is the device an entity belongs to, so Home Assistant groups the
+// nodes of a device together.
+type hassDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+// hassConfig is a Home Assistant MQTT discovery payload.
+//
+// The topics are absolute.
+type hassConfig struct {
+	Name                string     `json:"name"`
+	UniqueID            string     `json:"unique_id"`
+	Device              hassDevice `json:"device"`
+	AvailabilityTopic   string     `json:"availability_topic"`
+	PayloadAvailable    string     `json:"payload_available"`
+	PayloadNotAvailable string     `json:"payload_not_available"`
+
+	StateTopic    string `json:"state_topic,omitempty"`
+	ValueTemplate string `json:"value_template,omitempty"`
+	CommandTopic  string `json:"command_topic,omitempty"`
+	DeviceClass   string `json:"device_class,omitempty"`
+	OffDelay      int    `json:"off_delay,omitempty"`
+	Unit          string `json:"unit_of_measurement,omitempty"`
+	PayloadOn     string `json:"payload_on,omitempty"`
+	PayloadOff    string `json:"payload_off,omitempty"`
+	// Min is a pointer so 0 is sent; Home Assistant defaults it to 1.
+	Min *int `json:"min,omitempty"`
+	Max int  `json:"max,omitempty"`
+
+	// Event.
+	EventTypes []string `json:"event_types,omitempty"`
+
+	// Light.
+	OnCommandType            string   `json:"on_command_type,omitempty"`
+	BrightnessCommandTopic   string   `json:"brightness_command_topic,omitempty"`
+	BrightnessStateTopic     string   `json:"brightness_state_topic,omitempty"`
+	BrightnessScale          int      `json:"brightness_scale,omitempty"`
+	ColorTempCommandTopic    string   `json:"color_temp_command_topic,omitempty"`
+	ColorTempCommandTemplate string   `json:"color_temp_command_template,omitempty"`
+	ColorTempStateTopic      string   `json:"color_temp_state_topic,omitempty"`
+	ColorTempValueTemplate   string   `json:"color_temp_value_template,omitempty"`
+	MinMireds                int      `json:"min_mireds,omitempty"`
+	MaxMireds                int      `json:"max_mireds,omitempty"`
+	EffectCommandTopic       string   `json:"effect_command_topic,omitempty"`
+	EffectList               []string `json:"effect_list,omitempty"`
+}
+
+// hassEntities returns the Home Assistant discovery payloads for the nodes of
+// a device, keyed by their topic relative to hassPrefix.
+//
+// effects is the list of effects offered by the lights.
+func hassEntities(devID nodes.ID, dev *nodes.Dev, effects []string) map[string]*hassConfig {
+	out := map[string]*hassConfig{}
+	s := dev.ToSerialized()
+	for nodeID, n := range s.Nodes {
+		root := "dlibox/" + string(devID) + "/" + string(nodeID) + "/"
+		entity := func(component string, prop nodes.ID) *hassConfig {
+			id := "dlibox_" + string(devID) + "_" + string(nodeID)
+			name := n.Name
+			if len(prop) != 0 {
+				id += "_" + string(prop)
+				name += " " + string(prop)
+			}
+			c := &hassConfig{
+				Name:     name,
+				UniqueID: id,
+				Device: hassDevice{
+					Identifiers:  []string{"dlibox_" + string(devID)},
+					Name:         dev.Name,
+					Manufacturer: "dlibox",
+				},
+				AvailabilityTopic:   "dlibox/" + string(devID) + "/$online",
+				PayloadAvailable:    "true",
+				PayloadNotAvailable: "false",
+			}
+			out[component+"/"+id+"/config"] = c
+			return c
+		}
+		switch dev.Nodes[nodeID].Config.(type) {
+		case *nodes.Anim1D:
+			c := entity("light", "")
+			// Home Assistant sends OFF and effect names, which are translated by
+			// hassBridge.
+			c.CommandTopic = root + "hass/switch"
+			c.OnCommandType = "brightness"
+			c.BrightnessCommandTopic = root + "intensity"
+			c.BrightnessStateTopic = root + "intensity"
+			c.BrightnessScale = 255
+			// The temperature is in Kelvin, Home Assistant uses mireds.
+			c.ColorTempCommandTopic = root + "temperature"
+			c.ColorTempCommandTemplate = "{{ (1000000 / value) | round(0) | int }}"
+			c.ColorTempStateTopic = root + "temperature"
+			c.ColorTempValueTemplate = "{{ (1000000 / (value | int)) | round(0) | int }}"
+			c.MinMireds = 1000000 / 35000
+			c.MaxMireds = 1000000 / 1000
+			if len(effects) != 0 {
+				c.EffectCommandTopic = root + "hass/effect"
+				c.EffectList = effects
+			}
+		case *nodes.Button:
+			for prop := range n.Properties {
+				if prop == "button" {
+					c := entity("binary_sensor", "")
+					c.StateTopic = root + "button"
+					c.PayloadOn = "true"
+					c.PayloadOff = "false"
+					continue
+				}
+				// Each gesture is an event entity with a single event type; the
+				// payload, "true" or the number of repeats, is not used.
+				c := entity("event", prop)
+				c.StateTopic = root + string(prop)
+				c.DeviceClass = "button"
+				c.EventTypes = []string{string(prop)}
+				c.ValueTemplate = `{"event_type": "` + string(prop) + `"}`
+			}
+		case *nodes.PIR:
+			for prop := range n.Properties {
+				c := entity("binary_sensor", "")
+				// The node publishes the time of the event.
+				c.StateTopic = root + string(prop)
+				c.ValueTemplate = "ON"
+				c.DeviceClass = "motion"
+				c.OffDelay = 30
+			}
+		case *nodes.Display:
+			for prop, p := range n.Properties {
+				if p.Settable {
+					entity("text", prop).CommandTopic = root + nodes.CommandTopic(n.Type, prop)
+				}
+			}
+		case *nodes.IR:
+			for prop, p := range n.Properties {
+				if p.Settable {
+					entity("text", prop).CommandTopic = root + nodes.CommandTopic(n.Type, prop)
+					continue
+				}
+				// The lirc keys are the entity of the node itself.
+				id := prop
+				if prop == "ir" {
+					id = ""
+				}
+				entity("sensor", id).StateTopic = root + string(prop)
+			}
+		case *nodes.IRTx:
+			for prop := range n.Properties {
+				entity("text", "").CommandTopic = root + nodes.CommandTopic(n.Type, prop)
+			}
+		case *nodes.PWM:
+			for prop, p := range n.Properties {
+				c := entity("number", "")
+				c.StateTopic = root + string(prop)
+				c.CommandTopic = root + nodes.CommandTopic(n.Type, prop)
+				c.Unit = p.Unit
+				min := 0
+				c.Min = &min
+				c.Max = 100
+			}
+		case *nodes.Relay:
+			for prop := range n.Properties {
+				c := entity("switch", "")
+				c.StateTopic = root + string(prop)
+				c.CommandTopic = root + nodes.CommandTopic(n.Type, prop)
+				// The state is a Homie boolean.
+				c.PayloadOn = "true"
+				c.PayloadOff = "false"
+			}
+		case *nodes.Sensor:
+			for prop, p := range n.Properties {
+				c := entity("sensor", prop)
+				c.StateTopic = root + string(prop)
+				// The property names match Home Assistant's device classes.
+				c.DeviceClass = string(prop)
+				c.Unit = p.Unit
+			}
+		case *nodes.Sound:
+			for prop, p := range n.Properties {
+				if p.Settable {
+					entity("notify", "").CommandTopic = root + string(prop)
+				}
+			}
+		default:
+			log.Printf("hass: no entity for node %s/%s of type %s", devID, nodeID, n.Type)
+		}
+	}
+	return out
+}
+
+// hassBridge publishes the Home Assistant discovery payloads for the
+// configured devices and translates the commands that the nodes don't
+// understand natively.
+type hassBridge struct {
+	b msgbus.Bus // Rebased on dlibox.
+
+	mu      sync.Mutex
+	effects map[string]pattern
+	topics  map[string]bool // Discovery topics published.
+}
+
+// initHass starts the Home Assistant bridge.
+//
+// b must be rebased on dlibox. effects are the named patterns offered as light
+// effects.
+func initHass(b msgbus.Bus, effects map[string]pattern) (*hassBridge, error) {
+	h := &hassBridge{b: b, effects: map[string]pattern{}, topics: map[string]bool{}}
+	for k, v := range effects {
+		if len(k) != 0 {
+			h.effects[k] = v
+		}
+	}
+	c, err := b.Subscribe("+/+/hass/+", msgbus.ExactlyOnce)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for msg := range c {
+			h.onMsg(msg)
+		}
+	}()
+	return h, nil
+}
+
+// Close stops translating the commands.
+func (h *hassBridge) Close() error {
+	h.b.Unsubscribe("+/+/hass/+")
+	return nil
+}
+
+// publish publishes the discovery payloads of devs and deletes the ones of
+// the nodes removed since the last call.
+func (h *hassBridge) publish(devs map[nodes.ID]*nodes.Dev) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	effects := make([]string, 0, len(h.effects))
+	for k := range h.effects {
+		effects = append(effects, k)
+	}
+	sort.Strings(effects)
+	topics := map[string]bool{}
+	for devID, dev := range devs {
+		for t, c := range hassEntities(devID, dev, effects) {
+			b, err := json.Marshal(c)
+			if err != nil {
+				log.Printf("hass: %v", err)
+				continue
+			}
+			t = "//" + hassPrefix + "/" + t
+			shared.Retained(h.b, t, b)
+			topics[t] = true
+		}
+	}
+	for t := range h.topics {
+		if !topics[t] {
+			// An empty retained message deletes the entity.
+			shared.Retained(h.b, t, nil)
+		}
+	}
+	h.topics = topics
+}
+
+// onMsg translates a "<dev>/<node>/hass/<command>" message.
+func (h *hassBridge) onMsg(msg msgbus.Message) {
+	i := strings.LastIndex(msg.Topic, "/hass/")
+	if i == -1 {
+		return
+	}
+	root := msg.Topic[:i+1]
+	payload := string(msg.Payload)
+	var m msgbus.Message
+	switch msg.Topic[i+len("/hass/"):] {
+	case "switch":
+		if payload != "OFF" {
+			// Turning on is done via the brightness.
+			return
+		}
+		m = msgbus.Message{Topic: root + "intensity", Payload: []byte("0")}
+	case "effect":
+		h.mu.Lock()
+		p, ok := h.effects[payload]
+		h.mu.Unlock()
+		if !ok {
+			log.Printf("hass: unknown effect %q", payload)
+			return
+		}
+		m = msgbus.Message{Topic: root + "anim1d", Payload: []byte(p)}
+	default:
+		log.Printf("hass: unknown command %s", msg.Topic)
+		return
+	}
+	// Publish asynchronously so the subscription is not blocked by the
+	// subscribers of the translated command.
+	go func() {
+		if err := h.b.Publish(m, msgbus.ExactlyOnce); err != nil {
+			log.Printf("hass: failed to publish %s: %v", m.Topic, err)
+		}
+	}()
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/physic"
+)
+
+func TestHassEntities(t *testing.T) {
+	dev := &nodes.Dev{
+		Name: "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"leds":   {Name: "Lights", Config: &nodes.Anim1D{APA102: true, SPI: nodes.SPIRef{ID: "SPI0.0", Hz: physic.MegaHertz}, NumberLights: 100, FPS: 60}},
+			"motion": {Name: "Motion", Config: &nodes.PIR{Pin: "GPIO4"}},
+			"oled":   {Name: "Screen", Config: &nodes.Display{SSD1306: true, W: 128, H: 64}},
+			"env":    {Name: "Weather", Config: &nodes.Sensor{BMP180: true, I2C: nodes.I2CRef{ID: "1"}, Addr: 0x77, PeriodMS: 1000}},
+			"fan":    {Name: "Fan", Config: &nodes.Relay{Pin: "GPIO17"}},
+			"tape":   {Name: "Tape", Config: &nodes.PWM{Pin: "GPIO18", Hz: physic.KiloHertz, MaxDuty: 100}},
+			"sound":  {Name: "Speaker", Config: &nodes.Sound{}},
+			"remote": {Name: "Remote", Config: &nodes.IR{Pin: "GPIO27"}},
+			"tx":     {Name: "Blaster", Config: &nodes.IRTx{Pin: "GPIO22"}},
+			"door":   {Name: "Doorbell", Config: &nodes.Button{Pin: "GPIO5"}},
+		},
+	}
+	out := hassEntities("dev1", dev, []string{"Rainbow"})
+	var topics []string
+	for t := range out {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	expected := []string{
+		"binary_sensor/dlibox_dev1_door/config",
+		"binary_sensor/dlibox_dev1_motion/config",
+		"event/dlibox_dev1_door_click/config",
+		"event/dlibox_dev1_door_double/config",
+		"event/dlibox_dev1_door_hold/config",
+		"event/dlibox_dev1_door_long/config",
+		"light/dlibox_dev1_leds/config",
+		"notify/dlibox_dev1_sound/config",
+		"number/dlibox_dev1_tape/config",
+		"sensor/dlibox_dev1_env_pressure/config",
+		"sensor/dlibox_dev1_env_temperature/config",
+		"sensor/dlibox_dev1_remote_code/config",
+		"sensor/dlibox_dev1_remote_learned/config",
+		"switch/dlibox_dev1_fan/config",
+		"text/dlibox_dev1_oled_content/config",
+		"text/dlibox_dev1_oled_markee/config",
+		"text/dlibox_dev1_remote_learn/config",
+		"text/dlibox_dev1_tx/config",
+	}
+	if !reflect.DeepEqual(expected, topics) {
+		t.Fatalf("%q != %q", expected, topics)
+	}
+	l := out["light/dlibox_dev1_leds/config"]
+	if l.BrightnessCommandTopic != "dlibox/dev1/leds/intensity" || l.EffectCommandTopic != "dlibox/dev1/leds/hass/effect" || l.AvailabilityTopic != "dlibox/dev1/$online" || l.Device.Name != "Living room" {
+		t.Fatalf("unexpected light %#v", l)
+	}
+	if p := out["binary_sensor/dlibox_dev1_motion/config"]; p.StateTopic != "dlibox/dev1/motion/pir" || p.DeviceClass != "motion" {
+		t.Fatalf("unexpected binary_sensor %#v", p)
+	}
+	if s := out["sensor/dlibox_dev1_env_temperature/config"]; s.StateTopic != "dlibox/dev1/env/temperature" || s.DeviceClass != "temperature" || s.Unit != "°C" {
+		t.Fatalf("unexpected sensor %#v", s)
+	}
+	if s := out["switch/dlibox_dev1_fan/config"]; s.StateTopic != "dlibox/dev1/fan/on" || s.CommandTopic != "dlibox/dev1/fan/on/set" || s.PayloadOn != "true" {
+		t.Fatalf("unexpected switch %#v", s)
+	}
+	if s := out["number/dlibox_dev1_tape/config"]; s.CommandTopic != "dlibox/dev1/tape/level/set" || s.Min == nil || *s.Min != 0 || s.Max != 100 || s.Unit != "%" {
+		t.Fatalf("unexpected number %#v", s)
+	}
+	// The minimum must be sent, since Home Assistant defaults it to 1.
+	if b, err := json.Marshal(out["number/dlibox_dev1_tape/config"]); err != nil || !strings.Contains(string(b), `"min":0,`) {
+		t.Fatalf("unexpected number %s: %v", b, err)
+	}
+	if s := out["notify/dlibox_dev1_sound/config"]; s.CommandTopic != "dlibox/dev1/sound/speakers" {
+		t.Fatalf("unexpected notify %#v", s)
+	}
+	if e := out["event/dlibox_dev1_door_long/config"]; e.StateTopic != "dlibox/dev1/door/long" || !reflect.DeepEqual(e.EventTypes, []string{"long"}) || e.ValueTemplate != `{"event_type": "long"}` {
+		t.Fatalf("unexpected event %#v", e)
+	}
+}
+
+func TestHassBridge(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebasePub(msgbus.RebaseSub(b, "dlibox"), "dlibox")
+	h, err := initHass(d, map[string]pattern{"Rainbow": "\"Rainbow\""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	devs := map[nodes.ID]*nodes.Dev{
+		"dev1": {Name: "Porch", Nodes: map[nodes.ID]*nodes.Node{"motion": {Name: "Motion", Config: &nodes.PIR{Pin: "GPIO4"}}}},
+		"dev2": {Name: "Room", Nodes: map[nodes.ID]*nodes.Node{"leds": {Name: "Lights", Config: &nodes.Anim1D{}}}},
+	}
+	h.publish(devs)
+	const light = "homeassistant/light/dlibox_dev2_leds/config"
+	msgs, err := msgbus.Retained(b, time.Second, light)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := hassConfig{}
+	if err := json.Unmarshal(msgs[light], &c); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.EffectList, []string{"Rainbow"}) {
+		t.Fatalf("unexpected light %#v", c)
+	}
+
+	// The commands from Home Assistant are translated.
+	data := []struct {
+		topic, payload            string
+		expected, expectedPayload string
+	}{
+		{"dev2/leds/hass/effect", "Rainbow", "dev2/leds/anim1d", "\"Rainbow\""},
+		{"dev2/leds/hass/switch", "OFF", "dev2/leds/intensity", "0"},
+	}
+	for i, line := range data {
+		ch, err := d.Subscribe(line.expected, msgbus.ExactlyOnce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Publish(msgbus.Message{Topic: line.topic, Payload: []byte(line.payload)}, msgbus.ExactlyOnce); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-ch:
+			if string(msg.Payload) != line.expectedPayload {
+				t.Fatalf("%d: unexpected message %#v", i, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: command not translated", i)
+		}
+	}
+
+	// Removing a device deletes its entities.
+	delete(devs, "dev2")
+	h.publish(devs)
+	msgs, err = msgbus.Retained(b, 100*time.Millisecond, light, "homeassistant/binary_sensor/dlibox_dev1_motion/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || len(msgs[light]) != 0 {
+		t.Fatalf("unexpected retained messages %v", msgs)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
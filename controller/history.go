@@ -0,0 +1,171 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRevisions is the number of settings revisions kept on disk.
+const maxRevisions = 20
+
+// revision is a version of the settings as it was applied.
+type revision struct {
+	ID      int
+	Time    time.Time
+	Version int // Schema version, so old revisions can be migrated.
+	Config  config
+}
+
+// historyDir is the directory containing one file per revision.
+func (d *db) historyDir() string {
+	return d.path + ".history"
+}
+
+// commitLocked saves the database and records the current settings as a new
+// revision, discarding the oldest revisions.
+func (d *db) commitLocked() error {
+	if len(d.path) == 0 {
+		return nil
+	}
+	if err := d.saveLocked(); err != nil {
+		return err
+	}
+	ids, err := d.revisionIDsLocked()
+	if err != nil {
+		return err
+	}
+	id := 1
+	if len(ids) != 0 {
+		id = ids[len(ids)-1] + 1
+	}
+	if err := os.MkdirAll(d.historyDir(), 0700); err != nil {
+		return err
+	}
+	r := revision{ID: id, Time: time.Now().UTC(), Version: schemaVersion, Config: d.Config}
+	if err := writeJSONAtomic(d.revisionPath(id), &r); err != nil {
+		return err
+	}
+	for ids = append(ids, id); len(ids) > maxRevisions; ids = ids[1:] {
+		if err := os.Remove(d.revisionPath(ids[0])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureRevision records the current settings as the first revision if there is
+// none yet, so the settings used before the first change can be rolled back to.
+func (d *db) ensureRevision() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.path) == 0 {
+		return nil
+	}
+	ids, err := d.revisionIDsLocked()
+	if err != nil || len(ids) != 0 {
+		return err
+	}
+	return d.commitLocked()
+}
+
+func (d *db) revisionPath(id int) string {
+	return filepath.Join(d.historyDir(), strconv.Itoa(id)+".json")
+}
+
+// revisionIDsLocked returns the revisions available, oldest first.
+func (d *db) revisionIDsLocked() ([]int, error) {
+	entries, err := ioutil.ReadDir(d.historyDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []int
+	for _, e := range entries {
+		n := e.Name()
+		if !strings.HasSuffix(n, ".json") {
+			continue
+		}
+		if id, err := strconv.Atoi(strings.TrimSuffix(n, ".json")); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// loadRevisionLocked loads a revision from disk.
+func (d *db) loadRevisionLocked(id int) (*revision, error) {
+	f, err := os.Open(d.revisionPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("unknown revision %d", id)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	r := &revision{}
+	if err := decodeMigrated(f, r); err != nil {
+		return nil, fmt.Errorf("revision %d: %v", id, err)
+	}
+	return r, nil
+}
+
+// diffLines returns the line based difference between a and b.
+//
+// Each line is prefixed with "-" if it is only in a, "+" if it is only in b
+// and " " if it is in both.
+func diffLines(a, b string) []string {
+	x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	// l[i][j] is the length of the longest common subsequence of x[i:] and y[j:].
+	l := make([][]int, len(x)+1)
+	for i := range l {
+		l[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				l[i][j] = l[i+1][j+1] + 1
+			} else if l[i+1][j] >= l[i][j+1] {
+				l[i][j] = l[i+1][j]
+			} else {
+				l[i][j] = l[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, " "+x[i])
+			i++
+			j++
+		case l[i+1][j] >= l[i][j+1]:
+			out = append(out, "-"+x[i])
+			i++
+		default:
+			out = append(out, "+"+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "-"+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+"+y[j])
+	}
+	return out
+}
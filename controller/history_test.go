@@ -0,0 +1,167 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/maruel/dlibox/controller/alarm"
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/msgbus"
+)
+
+func TestDiffLines(t *testing.T) {
+	data := []struct {
+		a, b     string
+		expected []string
+	}{
+		{"a\nb\nc\n", "a\nb\nc\n", []string{" a", " b", " c"}},
+		{"a\nb\nc", "a\nc\nd", []string{" a", "-b", " c", "+d"}},
+		{"a", "b", []string{"-a", "+b"}},
+	}
+	for i, line := range data {
+		if actual := diffLines(line.a, line.b); !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("%d: expected %q; got %q", i, line.expected, actual)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlibox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := msgbus.New()
+	defer b.Close()
+	d := &db{}
+	if err := d.load(filepath.Join(dir, "dlibox.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ensureRevision(); err != nil {
+		t.Fatal(err)
+	}
+	j := &jsonAPI{b: b, db: d}
+	defer d.Config.Alarms.Stop()
+
+	for i := 0; i < maxRevisions+1; i++ {
+		c := config{Rules: rules.Rules{"r": {Signal: "a/b", Cmd: rules.Command{Topic: "leds/intensity", Payload: "1"}}}}
+		if i == maxRevisions {
+			c.Rules["r"] = rules.Rule{Signal: "a/c", Cmd: rules.Command{Topic: "leds/intensity", Payload: "2"}}
+		}
+		if _, code := j.apiSettingSet(c); code != 200 {
+			t.Fatalf("%d: unexpected code %d", i, code)
+		}
+	}
+	if _, code := j.apiSettingSet(config{Rules: rules.Rules{"r": {Signal: "a =="}}}); code != 400 {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	// Only the latest revisions are kept.
+	out, code := j.apiSettingHistory()
+	if code != 200 {
+		t.Fatal(out)
+	}
+	revs := out.([]settingRevision)
+	if len(revs) != maxRevisions || revs[0].ID != 3 || revs[len(revs)-1].ID != maxRevisions+2 {
+		t.Fatalf("unexpected revisions %v", revs)
+	}
+	// No temporary file is left behind.
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tmp*")); len(files) != 0 {
+		t.Fatalf("unexpected files %v", files)
+	}
+
+	out, code = j.apiSettingDiff(settingDiffIn{From: maxRevisions + 1})
+	if code != 200 {
+		t.Fatal(out)
+	}
+	n := 0
+	for _, l := range out.(*settingDiffOut).Lines {
+		if l[0] != ' ' {
+			n++
+		}
+	}
+	if n != 4 {
+		t.Fatalf("unexpected diff %q", out.(*settingDiffOut).Lines)
+	}
+
+	// Rolling back creates a new revision.
+	if out, code := j.apiSettingRollback(settingRollback{ID: maxRevisions + 1}); code != 200 {
+		t.Fatal(out)
+	}
+	if s := d.Config.Rules["r"].Signal; s != "a/b" {
+		t.Fatalf("unexpected signal %q", s)
+	}
+	if out, code := j.apiSettingRollback(settingRollback{ID: 1}); code != 404 {
+		t.Fatal(out)
+	}
+
+	// The settings were saved.
+	d2 := &db{}
+	if err := d2.load(d.path); err != nil {
+		t.Fatal(err)
+	}
+	if s := d2.Config.Rules["r"].Signal; s != "a/b" {
+		t.Fatalf("unexpected signal %q", s)
+	}
+}
+
+func TestSetSettings_Atomic(t *testing.T) {
+	b := &noAlarmsBus{Bus: msgbus.New()}
+	defer b.Close()
+	d := &db{}
+	old := config{Rules: rules.Rules{"r": {Signal: "a/b", Cmd: rules.Command{Topic: "leds/intensity", Payload: "1"}}}}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r, err := initRules(l, old.Rules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	j := &jsonAPI{b: b, db: d, rules: r}
+	if _, code := j.apiSettingSet(old); code != 200 {
+		t.Fatalf("unexpected code %d", code)
+	}
+
+	// Arming the alarms fails, nothing is applied.
+	b.fail = true
+	c := config{
+		Alarms: alarm.Config{Alarms: map[string]*alarm.Alarm{"a": {Enabled: true, Hour: 6, Days: alarm.Monday}}},
+		Rules:  rules.Rules{"r": {Signal: "a/c", Cmd: rules.Command{Topic: "leds/intensity", Payload: "2"}}},
+	}
+	if _, code := j.apiSettingSet(c); code != 500 {
+		t.Fatalf("unexpected code %d", code)
+	}
+	if s := d.Config.Rules["r"].Signal; s != "a/b" {
+		t.Fatalf("unexpected signal %q", s)
+	}
+	if e := r.rules["r"].expr.String(); e != "a/b" {
+		t.Fatalf("unexpected rule %q", e)
+	}
+	if n := len(d.Config.Alarms.Alarms); n != 0 {
+		t.Fatalf("unexpected alarms %d", n)
+	}
+}
+
+// noAlarmsBus fails the subscription of the alarms when fail is set.
+type noAlarmsBus struct {
+	msgbus.Bus
+	fail bool
+}
+
+func (n *noAlarmsBus) Subscribe(topic string, qos msgbus.QOS) (<-chan msgbus.Message, error) {
+	if n.fail && topic == "alarms/#" {
+		return nil, errors.New("failed")
+	}
+	return n.Bus.Subscribe(topic, qos)
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+)
+
+// homieDevice is a device following the Homie convention found on the MQTT
+// server.
+type homieDevice struct {
+	State  string
+	Dev    *nodes.Dev        // nil until the description is complete.
+	Err    string            `json:",omitempty"` // Set if the description is invalid.
+	Values map[string]string // "<node>/<property>" -> value.
+}
+
+// homieDiscovery keeps track of the devices published under homie/, including
+// the dlibox devices exposing themselves as Homie devices.
+//
+// The devices are discovered from their $homie attribute when the server
+// supports matching it with a wildcard, and from their property values
+// otherwise. Their attributes are then subscribed to individually.
+type homieDiscovery struct {
+	b msgbus.Bus // Rebased on homie.
+
+	mu      sync.Mutex
+	attrs   map[nodes.ID]map[string][]byte
+	values  map[nodes.ID]map[string]string
+	subbed  map[string]bool // Topics subscribed to.
+	devices map[nodes.ID]*homieDevice
+}
+
+// initHomie starts discovering the Homie devices.
+//
+// bus must not be rebased.
+func initHomie(bus msgbus.Bus) (*homieDiscovery, error) {
+	h := &homieDiscovery{
+		b:       msgbus.RebasePub(msgbus.RebaseSub(bus, nodes.HomieRoot), nodes.HomieRoot),
+		attrs:   map[nodes.ID]map[string][]byte{},
+		values:  map[nodes.ID]map[string]string{},
+		subbed:  map[string]bool{},
+		devices: map[nodes.ID]*homieDevice{},
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range []string{"+/$homie", "#"} {
+		if err := h.subscribeLocked(t); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Close stops discovering new devices.
+//
+// TODO(maruel): The attribute topics are not unsubscribed from, since msgbus
+// panics when unsubscribing while the retained messages are still being
+// delivered.
+func (h *homieDiscovery) Close() error {
+	h.b.Unsubscribe("#")
+	h.b.Unsubscribe("+/$homie")
+	return nil
+}
+
+// getDevices returns a copy of the devices found so far.
+func (h *homieDiscovery) getDevices() map[nodes.ID]*homieDevice {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make(map[nodes.ID]*homieDevice, len(h.devices))
+	for id, d := range h.devices {
+		c := *d
+		c.Values = make(map[string]string, len(h.values[id]))
+		for k, v := range h.values[id] {
+			c.Values[k] = v
+		}
+		out[id] = &c
+	}
+	return out
+}
+
+// set sends a command to a property of a Homie device.
+func (h *homieDiscovery) set(dev, node, prop nodes.ID, value string) error {
+	for _, id := range []nodes.ID{dev, node, prop} {
+		if err := id.Validate(); err != nil {
+			return err
+		}
+	}
+	t := string(dev) + "/" + string(node) + "/" + string(prop) + "/set"
+	return h.b.Publish(msgbus.Message{Topic: t, Payload: []byte(value)}, msgbus.ExactlyOnce)
+}
+
+func (h *homieDiscovery) subscribeLocked(topic string) error {
+	c, err := h.b.Subscribe(topic, msgbus.ExactlyOnce)
+	if err != nil {
+		return err
+	}
+	h.subbed[topic] = true
+	go func() {
+		for msg := range c {
+			h.onMsg(msg)
+		}
+	}()
+	return nil
+}
+
+func (h *homieDiscovery) onMsg(msg msgbus.Message) {
+	i := strings.IndexByte(msg.Topic, '/')
+	if i == -1 {
+		return
+	}
+	id := nodes.ID(msg.Topic[:i])
+	rest := msg.Topic[i+1:]
+	if id.Validate() != nil || strings.HasSuffix(rest, "/set") {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	attrs := h.attrs[id]
+	if attrs == nil {
+		log.Printf("homie: found device %s", id)
+		attrs = map[string][]byte{}
+		h.attrs[id] = attrs
+		h.values[id] = map[string]string{}
+		h.devices[id] = &homieDevice{}
+	}
+	if strings.HasPrefix(rest, "$") || strings.Contains(rest, "/$") {
+		attrs[rest] = msg.Payload
+	} else {
+		h.values[id][rest] = string(msg.Payload)
+	}
+	// Subscribe to the attributes now known to exist.
+	for _, a := range nodes.HomieAttrs(attrs) {
+		if t := string(id) + "/" + a; !h.subbed[t] {
+			if err := h.subscribeLocked(t); err != nil {
+				log.Printf("homie: %v", err)
+			}
+		}
+	}
+	d := h.devices[id]
+	d.State = string(attrs["$state"])
+	d.Err = ""
+	dev, err := nodes.ParseHomie(attrs)
+	if err == nil {
+		d.Dev = dev
+	} else if err != nodes.ErrHomieIncomplete {
+		d.Err = err.Error()
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+)
+
+func TestHomieDiscovery(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	h, err := initHomie(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &jsonAPI{b: b, homie: h}
+
+	// A third party device, e.g. an ESP8266 running the Homie firmware.
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "homie/sensor-1"), "homie/sensor-1")
+	shared.RetainedStr(d, "$homie", "3.0.1")
+	shared.RetainedStr(d, "$name", "Garden")
+	shared.RetainedStr(d, "$state", "ready")
+	shared.RetainedStr(d, "$nodes", "relay")
+	shared.RetainedStr(d, "relay/$name", "Sprinkler")
+	shared.RetainedStr(d, "relay/$type", "relay")
+	shared.RetainedStr(d, "relay/$properties", "on")
+	shared.RetainedStr(d, "relay/on/$datatype", "boolean")
+	shared.RetainedStr(d, "relay/on/$settable", "true")
+	// The local bus doesn't match the $homie attribute with a wildcard, so the
+	// device is found from its property value.
+	shared.RetainedStr(d, "relay/on", "false")
+
+	// Optional attributes may be received after the description is complete, so
+	// wait for all of them.
+	ready := func(dev *homieDevice) bool {
+		if dev == nil || dev.Dev == nil || dev.Dev.Nodes["relay"] == nil {
+			return false
+		}
+		c, ok := dev.Dev.Nodes["relay"].Config.(*nodes.Homie)
+		return ok && c.Properties["on"].Settable && dev.Values["relay/on"] == "false" && dev.State == "ready"
+	}
+	var dev *homieDevice
+	for start := time.Now(); !ready(dev); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("device not discovered: %#v", dev)
+		}
+		out, code := j.apiHomieDevices()
+		if code != 200 {
+			t.Fatal(out)
+		}
+		dev = out["sensor-1"]
+	}
+	if dev.Dev.Name != "Garden" || dev.Dev.Nodes["relay"].Name != "Sprinkler" {
+		t.Fatalf("unexpected device %#v", dev)
+	}
+	if c := dev.Dev.Nodes["relay"].Config.(*nodes.Homie); c.Type != "relay" || c.Properties["on"].DataType != "boolean" {
+		t.Fatalf("unexpected node %#v", c)
+	}
+
+	c, err := d.Subscribe("relay/on/set", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, code := j.apiHomieSet(homieSet{Device: "sensor-1", Node: "relay", Property: "on", Value: "true"}); code != 200 {
+		t.Fatal(out)
+	}
+	select {
+	case msg := <-c:
+		if string(msg.Payload) != "true" {
+			t.Fatalf("unexpected message %#v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command not received")
+	}
+	if _, code := j.apiHomieSet(homieSet{Device: "sensor-1", Node: "relay", Property: "$name"}); code != 400 {
+		t.Fatalf("unexpected code %d", code)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/maruel/dlibox/ircode"
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+)
+
+// irLearner stores the codes learned by the IR receivers in the IRCodes of the
+// settings, so the rules can send them by name with any IR transmitter.
+type irLearner struct {
+	b  msgbus.Bus
+	db *db
+}
+
+// initIRLearner starts listening to the codes learned on b.
+func initIRLearner(b msgbus.Bus, d *db) (*irLearner, error) {
+	l := &irLearner{b: b, db: d}
+	c, err := b.Subscribe("+/+/learned", msgbus.ExactlyOnce)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for msg := range c {
+			if err := l.onLearned(string(msg.Payload)); err != nil {
+				pubErr(b, "ir: %s: %v", msg.Topic, err)
+			}
+		}
+	}()
+	return l, nil
+}
+
+func (l *irLearner) Close() error {
+	l.b.Unsubscribe("+/+/learned")
+	return nil
+}
+
+// onLearned stores a "<name>=<code>" learned code.
+func (l *irLearner) onLearned(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i == -1 {
+		return fmt.Errorf("invalid learned code %q", s)
+	}
+	name, code := s[:i], s[i+1:]
+	if err := nodes.ID(name).Validate(); err != nil {
+		return err
+	}
+	if _, err := ircode.Parse(code); err != nil {
+		return err
+	}
+	l.db.mu.Lock()
+	defer l.db.mu.Unlock()
+	if l.db.Config.IRCodes == nil {
+		l.db.Config.IRCodes = map[string]string{}
+	}
+	l.db.Config.IRCodes[name] = code
+	log.Printf("ir: learned %s as %s", code, name)
+	// Only the devices with a transmitter use the codes.
+	changed := map[nodes.ID]*nodes.Dev{}
+	for devID, dev := range l.db.Config.Devices {
+		for _, n := range dev.Nodes {
+			if _, ok := n.Config.(*nodes.IRTx); ok {
+				changed[devID] = dev
+			}
+		}
+	}
+	publishDevices(l.b, changed, l.db.Config.IRCodes)
+	return l.db.commitLocked()
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+)
+
+func TestIRLearner(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	tx := &nodes.IRTx{Pin: "GPIO22"}
+	d := &db{}
+	d.Config.Devices = map[nodes.ID]*nodes.Dev{
+		"dev1": {Name: "TV", Nodes: map[nodes.ID]*nodes.Node{"tx": {Name: "Blaster", Config: tx}}},
+		"dev2": {Name: "Porch", Nodes: map[nodes.ID]*nodes.Node{"motion": {Name: "Motion", Config: &nodes.PIR{Pin: "GPIO4"}}}},
+	}
+	l, err := initIRLearner(b, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	data := []struct {
+		in    string
+		valid bool
+	}{
+		{"tv-power=nec:0xEF10DF20", true},
+		{"amp=rc5:0x300C", true},
+		{"tv-power", false},
+		{"TV=nec:0xEF10DF20", false},
+		{"tv=sony:0x10", false},
+	}
+	for i, line := range data {
+		if err := l.onLearned(line.in); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+	if len(d.Config.IRCodes) != 2 || d.Config.IRCodes["tv-power"] != "nec:0xEF10DF20" || d.Config.IRCodes["amp"] != "rc5:0x300C" {
+		t.Fatalf("unexpected codes %v", d.Config.IRCodes)
+	}
+	if len(tx.Codes) != 0 {
+		t.Fatalf("unexpected node codes %v", tx.Codes)
+	}
+	if err := d.Config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The codes are stored even without a transmitter.
+	delete(d.Config.Devices, "dev1")
+	if err := l.onLearned("tv-mute=nec:0xEF10F00F"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Config.IRCodes) != 3 {
+		t.Fatalf("unexpected codes %v", d.Config.IRCodes)
+	}
+}
+
+func TestWithIRCodes(t *testing.T) {
+	tx := &nodes.IRTx{Pin: "GPIO22", Codes: map[string]string{"amp": "rc5:0x300D"}}
+	pir := &nodes.Node{Name: "Motion", Config: &nodes.PIR{Pin: "GPIO4"}}
+	dev := &nodes.Dev{Name: "TV", Nodes: map[nodes.ID]*nodes.Node{"tx": {Name: "Blaster", Config: tx}, "motion": pir}}
+	codes := map[string]string{"tv-power": "nec:0xEF10DF20", "amp": "rc5:0x300C"}
+	out := withIRCodes(dev, codes)
+	// The code of the node takes precedence.
+	expected := map[string]string{"tv-power": "nec:0xEF10DF20", "amp": "rc5:0x300D"}
+	if actual := out.Nodes["tx"].Config.(*nodes.IRTx).Codes; !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%v != %v", expected, actual)
+	}
+	if out.Nodes["motion"] != pir || out.Name != "TV" {
+		t.Fatalf("unexpected device %v", out)
+	}
+	// The settings are not modified.
+	if len(tx.Codes) != 1 {
+		t.Fatalf("unexpected node codes %v", tx.Codes)
+	}
+	if withIRCodes(dev, nil) != dev {
+		t.Fatal("expected the same device")
+	}
+}
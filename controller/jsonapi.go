@@ -9,8 +9,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"time"
 
 	"github.com/maruel/anim1d"
+	"github.com/maruel/dlibox/controller/alarm"
+	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/msgbus"
 )
 
@@ -20,28 +23,181 @@ type jsonAPI struct {
 	b        msgbus.Bus
 	l        io.WriterTo
 	db       *db
+	rules    *rulesRunner
+	fsm      *fsmRunner
+	homie    *homieDiscovery
+	hass     *hassBridge
 }
 
-func (j *jsonAPI) init(hostname string, b msgbus.Bus, d *db, l io.WriterTo) {
+func (j *jsonAPI) init(hostname string, b msgbus.Bus, d *db, r *rulesRunner, f *fsmRunner, h *homieDiscovery, hass *hassBridge, l io.WriterTo) {
 	j.hostname = hostname
 	j.b = b
 	j.l = l
 	j.db = d
+	j.rules = r
+	j.fsm = f
+	j.homie = h
+	j.hass = hass
 }
 
 // getAPIs returns the JSON API handlers.
 func (j *jsonAPI) getAPIs() []apiHandler {
 	return []apiHandler{
+		{"/api/dlibox/v1/alarms/next", j.apiAlarmsNext},
+		{"/api/dlibox/v1/alarms/skip", j.apiAlarmsSkip},
+		{"/api/dlibox/v1/alarms/snooze", j.apiAlarmsSnooze},
+		{"/api/dlibox/v1/fsm/set", j.apiFSMSet},
+		{"/api/dlibox/v1/fsm/states", j.apiFSMStates},
+		{"/api/dlibox/v1/homie/devices", j.apiHomieDevices},
+		{"/api/dlibox/v1/homie/set", j.apiHomieSet},
 		{"/api/dlibox/v1/pattern/list", j.apiPatternList},
 		{"/api/dlibox/v1/pattern/get", j.apiPatternGet},
 		{"/api/dlibox/v1/pattern/set", j.apiPatternSet},
-		{"/api/dlibox/v1/publish", j.apiPublish},
+		{"/api/dlibox/v1/rules/stats", j.apiRulesStats},
 		{"/api/dlibox/v1/server/state", j.apiServerState},
+		{"/api/dlibox/v1/settings/diff", j.apiSettingDiff},
 		{"/api/dlibox/v1/settings/get", j.apiSettingGet},
+		{"/api/dlibox/v1/settings/history", j.apiSettingHistory},
+		{"/api/dlibox/v1/settings/rollback", j.apiSettingRollback},
 		{"/api/dlibox/v1/settings/set", j.apiSettingSet},
 	}
 }
 
+// /api/dlibox/v1/alarms/next
+
+type alarmNext struct {
+	Next      time.Time // Zero if the alarm is disabled.
+	InSeconds int64     // Number of seconds until Next.
+	Skipped   bool      // Next will be skipped.
+	Snoozed   time.Time // Zero if the alarm is not snoozed.
+}
+
+func (j *jsonAPI) apiAlarmsNext() (map[string]alarmNext, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	now := time.Now()
+	out := make(map[string]alarmNext, len(j.db.Config.Alarms.Alarms))
+	for name, a := range j.db.Config.Alarms.Alarms {
+		n := alarmNext{Next: a.Next(now), Snoozed: a.Snoozed()}
+		if !n.Next.IsZero() {
+			n.InSeconds = int64(n.Next.Sub(now) / time.Second)
+			n.Skipped = a.IsSkipped(n.Next)
+		}
+		out[name] = n
+	}
+	return out, 200
+}
+
+// /api/dlibox/v1/alarms/skip
+
+type alarmSkip struct {
+	Name string
+	Skip bool // false cancels a previous skip.
+}
+
+func (j *jsonAPI) apiAlarmsSkip(in alarmSkip) (map[string]string, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	a := j.db.Config.Alarms.Alarms[in.Name]
+	if a == nil {
+		return map[string]string{"error": "unknown alarm"}, 404
+	}
+	a.Skip(in.Skip)
+	// SkipNext is persisted.
+	if err := j.db.saveLocked(); err != nil {
+		log.Printf("web: failed to save: %v", err)
+		return map[string]string{"error": fmt.Sprintf("failed to save: %v", err)}, 500
+	}
+	return map[string]string{"ok": "1"}, 200
+}
+
+// /api/dlibox/v1/alarms/snooze
+
+type alarmSnooze struct {
+	Name    string
+	Minutes int // 0 uses the default.
+}
+
+func (j *jsonAPI) apiAlarmsSnooze(in alarmSnooze) (map[string]string, int) {
+	if in.Minutes < 0 {
+		return map[string]string{"error": "invalid Minutes"}, 400
+	}
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	a := j.db.Config.Alarms.Alarms[in.Name]
+	if a == nil {
+		return map[string]string{"error": "unknown alarm"}, 404
+	}
+	d := alarm.DefaultSnooze
+	if in.Minutes != 0 {
+		d = time.Duration(in.Minutes) * time.Minute
+	}
+	a.Snooze(d)
+	return map[string]string{"ok": "1"}, 200
+}
+
+// /api/dlibox/v1/fsm/set
+
+type fsmSet struct {
+	Name  string
+	State string
+}
+
+func (j *jsonAPI) apiFSMSet(in fsmSet) (map[string]string, int) {
+	j.db.mu.Lock()
+	m := j.db.Config.StateMachines[in.Name]
+	ok := m != nil && m.States[in.State] != nil
+	j.db.mu.Unlock()
+	if m == nil {
+		return map[string]string{"error": "unknown state machine"}, 404
+	}
+	if !ok {
+		return map[string]string{"error": "unknown state"}, 400
+	}
+	if err := j.b.Publish(msgbus.Message{Topic: in.Name + "/state/set", Payload: []byte(in.State)}, msgbus.ExactlyOnce); err != nil {
+		log.Printf("web: failed to publish: %v", err)
+		return map[string]string{"error": fmt.Sprintf("failed to publish: %v", err)}, 500
+	}
+	return map[string]string{"ok": "1"}, 200
+}
+
+// /api/dlibox/v1/fsm/states
+
+func (j *jsonAPI) apiFSMStates() (map[string]string, int) {
+	if j.fsm == nil {
+		return map[string]string{}, 200
+	}
+	return j.fsm.getStates(), 200
+}
+
+// /api/dlibox/v1/homie/devices
+
+func (j *jsonAPI) apiHomieDevices() (map[nodes.ID]*homieDevice, int) {
+	if j.homie == nil {
+		return map[nodes.ID]*homieDevice{}, 200
+	}
+	return j.homie.getDevices(), 200
+}
+
+// /api/dlibox/v1/homie/set
+
+type homieSet struct {
+	Device   nodes.ID
+	Node     nodes.ID
+	Property nodes.ID
+	Value    string
+}
+
+func (j *jsonAPI) apiHomieSet(in homieSet) (map[string]string, int) {
+	if j.homie == nil {
+		return map[string]string{"error": "homie is not enabled"}, 404
+	}
+	if err := j.homie.set(in.Device, in.Node, in.Property, in.Value); err != nil {
+		return map[string]string{"error": err.Error()}, 400
+	}
+	return map[string]string{"ok": "1"}, 200
+}
+
 // /api/dlibox/v1/pattern/list
 
 func (j *jsonAPI) apiPatternList() ([]pattern, int) {
@@ -89,35 +245,152 @@ func (j *jsonAPI) apiPatternSet(raw []byte) (interface{}, int) {
 	return raw, 200
 }
 
-// /api/dlibox/v1/publish
+// /api/dlibox/v1/rules/stats
 
-func (j *jsonAPI) apiPublish(state string) (map[string]string, int) {
-	/*
-		if !State(state).Valid() {
-			return map[string]string{"error": "state is invalid"}, 400
+func (j *jsonAPI) apiRulesStats() (map[string]ruleStats, int) {
+	if j.rules == nil {
+		return map[string]ruleStats{}, 200
+	}
+	return j.rules.getStats(), 200
+}
+
+// /api/dlibox/v1/settings/diff
+
+type settingDiffIn struct {
+	From int // 0 means the current settings.
+	To   int // 0 means the current settings.
+}
+
+type settingDiffOut struct {
+	Lines []string
+}
+
+func (j *jsonAPI) apiSettingDiff(in settingDiffIn) (interface{}, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	var raw [2][]byte
+	for i, id := range []int{in.From, in.To} {
+		c := &j.db.Config
+		if id != 0 {
+			r, err := j.db.loadRevisionLocked(id)
+			if err != nil {
+				return map[string]string{"error": err.Error()}, 404
+			}
+			c = &r.Config
+		}
+		var err error
+		if raw[i], err = json.MarshalIndent(c, "", "  "); err != nil {
+			return map[string]string{"error": err.Error()}, 500
 		}
-	*/
-	if err := j.b.Publish(msgbus.Message{Topic: "//dlibox/halloween/state", Payload: []byte(state)}, msgbus.ExactlyOnce); err != nil {
-		log.Printf("web: failed to publish: %v", err)
-		return map[string]string{"error": fmt.Sprintf("failed to publish: %v", err)}, 500
 	}
-	return map[string]string{"ok": "1"}, 200
+	return &settingDiffOut{Lines: diffLines(string(raw[0]), string(raw[1]))}, 200
 }
 
 // /api/dlibox/v1/settings/get
 
 func (j *jsonAPI) apiSettingGet() (interface{}, int) {
-	// TODO(maruel): Lock.
-	//j.db.Config.Lock()
-	//defer j.db.Config.Unlock()
-	return j.db.Config, 200
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	// Serialize while holding the lock.
+	raw, err := json.Marshal(&j.db.Config)
+	if err != nil {
+		return map[string]string{"error": err.Error()}, 500
+	}
+	return json.RawMessage(raw), 200
+}
+
+// /api/dlibox/v1/settings/history
+
+type settingRevision struct {
+	ID   int
+	Time time.Time
+}
+
+func (j *jsonAPI) apiSettingHistory() (interface{}, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	ids, err := j.db.revisionIDsLocked()
+	if err != nil {
+		return map[string]string{"error": err.Error()}, 500
+	}
+	out := make([]settingRevision, 0, len(ids))
+	for _, id := range ids {
+		r, err := j.db.loadRevisionLocked(id)
+		if err != nil {
+			return map[string]string{"error": err.Error()}, 500
+		}
+		out = append(out, settingRevision{ID: r.ID, Time: r.Time})
+	}
+	return out, 200
+}
+
+// /api/dlibox/v1/settings/rollback
+
+type settingRollback struct {
+	ID int
+}
+
+func (j *jsonAPI) apiSettingRollback(in settingRollback) (interface{}, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	r, err := j.db.loadRevisionLocked(in.ID)
+	if err != nil {
+		return map[string]string{"error": err.Error()}, 404
+	}
+	return j.setSettingsLocked(r.Config)
 }
 
 // /api/dlibox/v1/settings/set
 
-func (j *jsonAPI) apiSettingSet(settings config) (config, int) {
-	// TODO(maruel): Lock.
+func (j *jsonAPI) apiSettingSet(settings config) (interface{}, int) {
+	j.db.mu.Lock()
+	defer j.db.mu.Unlock()
+	return j.setSettingsLocked(settings)
+}
+
+// setSettingsLocked validates and applies new settings, then saves them as a
+// new revision.
+//
+// The settings are applied all at once; on failure the previous settings are
+// kept.
+func (j *jsonAPI) setSettingsLocked(settings config) (interface{}, int) {
+	if err := settings.Validate(); err != nil {
+		return map[string]string{"error": err.Error()}, 400
+	}
+	pos := settings.Alarms.Position
+	r, err := compileRules(settings.Rules, pos)
+	if err != nil {
+		return map[string]string{"error": err.Error()}, 400
+	}
+	f, err := compileFSM(settings.StateMachines, pos)
+	if err != nil {
+		return map[string]string{"error": err.Error()}, 400
+	}
+	// Disarm all the previous alarms before arming the new ones, so an alarm
+	// cannot fire twice.
+	j.db.Config.Alarms.Stop()
+	if err := alarm.Init(j.b, &settings.Alarms); err != nil {
+		settings.Alarms.Stop()
+		if err2 := alarm.Init(j.b, &j.db.Config.Alarms); err2 != nil {
+			log.Printf("web: failed to restore alarms: %v", err2)
+		}
+		return map[string]string{"error": fmt.Sprintf("failed to initialize alarms: %v", err)}, 500
+	}
+	if j.rules != nil {
+		j.rules.set(r)
+	}
+	if j.fsm != nil {
+		j.fsm.set(f)
+	}
 	j.db.Config = settings
+	publishDevices(j.b, j.db.Config.Devices, j.db.Config.IRCodes)
+	if j.hass != nil {
+		j.hass.publish(j.db.Config.Devices)
+	}
+	if err := j.db.commitLocked(); err != nil {
+		log.Printf("web: failed to save settings: %v", err)
+		return map[string]string{"error": fmt.Sprintf("failed to save settings: %v", err)}, 500
+	}
 	// Serialize it again to return the canonical form.
 	return settings, 200
 }
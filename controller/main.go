@@ -12,6 +12,8 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/maruel/dlibox/controller/alarm"
+	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/dlibox/shared"
 	"github.com/maruel/interrupt"
 	"github.com/maruel/msgbus"
@@ -28,44 +30,127 @@ func Main(bus msgbus.Bus, port int) error {
 
 	// Everything is under the namespace "dlibox/"
 	dbus := msgbus.RebasePub(msgbus.RebaseSub(bus, "dlibox"), "dlibox")
-	// TODO(maruel): Also listen to homie/ for simple nodes.
 
 	// Note: <devID>/$online will not function properly for the controller, use
 	// $online.
 	shared.InitState(msgbus.RebasePub(dbus, shared.Hostname()), nil)
 
-	w, err := newWebServer(fmt.Sprintf("0.0.0.0:%d", port), true, dbus, &d.db, nil)
+	if d.db.Config.Rules == nil {
+		d.db.Config.Rules.ResetDefault()
+	}
+	if d.db.Config.StateMachines == nil {
+		d.db.Config.StateMachines = stateMachines{"halloween": halloweenPreset()}
+	}
+	if err := d.db.ensureRevision(); err != nil {
+		log.Printf("Saving the settings failed: %v", err)
+	}
+
+	// The rules and the state machines listen to all the messages.
+	l, err := listenAll(dbus)
 	if err != nil {
 		return err
 	}
-	defer w.Close()
+	defer l.Close()
 
-	// Publish all the devices.
-	for devID, dev := range d.db.Config.Devices {
-		b := msgbus.RebasePub(dbus, string(devID))
-		shared.RetainedStr(b, "$name", dev.Name)
-
-		// Publish all the device's nodes.
-		for nodeID, def := range dev.ToSerialized().Nodes {
-			bn := msgbus.RebasePub(b, string(nodeID))
-			shared.RetainedStr(bn, "$name", def.Name)
-			shared.RetainedStr(bn, "$type", string(def.Type))
-			for pID, p := range def.Properties {
-				bp := msgbus.RebasePub(bn, string(pID))
-				shared.RetainedStr(bp, "$unit", p.Unit)
-				shared.RetainedStr(bp, "$datatype", p.DataType)
-				shared.RetainedStr(bp, "$format", p.Format)
-				shared.RetainedStr(bp, "$settable", fmt.Sprintf("%t", p.Settable))
-			}
-			shared.Retained(bn, "$config", def.Config)
-		}
+	r, err := initRules(l, d.db.Config.Rules, d.db.Config.Alarms.Position)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := initFSM(l, d.db.Config.StateMachines, d.db.Config.Alarms.Position)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ir, err := initIRLearner(dbus, &d.db)
+	if err != nil {
+		return err
+	}
+	defer ir.Close()
+
+	if err := alarm.Init(dbus, &d.db.Config.Alarms); err != nil {
+		log.Printf("Initializing alarms failed: %v", err)
+	}
+	defer d.db.Config.Alarms.Stop()
+
+	// Third party devices following the Homie convention are under "homie/".
+	h, err := initHomie(bus)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	// Home Assistant discovers the nodes under "homeassistant/". The named
+	// patterns are offered as light effects.
+	var p painterCfg
+	p.ResetDefault()
+	hass, err := initHass(dbus, p.Named)
+	if err != nil {
+		return err
+	}
+	defer hass.Close()
+
+	w, err := newWebServer(fmt.Sprintf("0.0.0.0:%d", port), true, dbus, &d.db, r, f, h, hass, nil)
+	if err != nil {
+		return err
 	}
+	defer w.Close()
+
+	publishDevices(dbus, d.db.Config.Devices, d.db.Config.IRCodes)
+	hass.publish(d.db.Config.Devices)
 	if !interrupt.IsSet() {
 		shared.RetainedStr(dbus, "$online", "true")
 	}
 	return shared.WatchFile()
 }
 
+// publishDevices publishes the configuration of all the devices.
+//
+// The IRTx nodes are published with the named IR codes in codes. The devices
+// reconfigure themselves when it changes.
+func publishDevices(b msgbus.Bus, devs map[nodes.ID]*nodes.Dev, codes map[string]string) {
+	for devID, dev := range devs {
+		withIRCodes(dev, codes).ToSerialized().Publish(msgbus.RebasePub(b, string(devID)))
+	}
+}
+
+// withIRCodes returns dev with the codes added to the Codes of its IRTx nodes.
+//
+// The codes of a node take precedence. dev is not modified.
+func withIRCodes(dev *nodes.Dev, codes map[string]string) *nodes.Dev {
+	if len(codes) == 0 {
+		return dev
+	}
+	var out *nodes.Dev
+	for id, n := range dev.Nodes {
+		tx, ok := n.Config.(*nodes.IRTx)
+		if !ok {
+			continue
+		}
+		if out == nil {
+			out = &nodes.Dev{Name: dev.Name, Nodes: make(map[nodes.ID]*nodes.Node, len(dev.Nodes))}
+			for id, n := range dev.Nodes {
+				out.Nodes[id] = n
+			}
+		}
+		c := *tx
+		c.Codes = make(map[string]string, len(codes)+len(tx.Codes))
+		for name, code := range codes {
+			c.Codes[name] = code
+		}
+		for name, code := range tx.Codes {
+			c.Codes[name] = code
+		}
+		out.Nodes[id] = &nodes.Node{Name: n.Name, Config: &c}
+	}
+	if out == nil {
+		return dev
+	}
+	return out
+}
+
 func pubErr(b msgbus.Bus, f string, arg ...interface{}) {
 	msg := fmt.Sprintf(f, arg...)
 	log.Print(msg)
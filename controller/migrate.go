@@ -0,0 +1,103 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// schemaVersion is the current version of the dlibox.json format.
+//
+// Increment it when adding a migration.
+const schemaVersion = 1
+
+// migrations[i] upgrades the decoded dlibox.json from version i to i+1.
+//
+// A migration must never be modified once released, only appended to.
+var migrations = []func(m map[string]interface{}) error{
+	migrateIRSignals,
+}
+
+// decodeMigrated decodes the JSON object in r into v after upgrading it to
+// schemaVersion.
+//
+// The object must have the Version and Config members of db.
+func decodeMigrated(r io.Reader, v interface{}) error {
+	var m map[string]interface{}
+	j := json.NewDecoder(r)
+	j.UseNumber()
+	if err := j.Decode(&m); err != nil {
+		return err
+	}
+	if err := migrate(m); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// Decode again, now refusing unknown fields so no data is silently dropped.
+	j = json.NewDecoder(bytes.NewReader(b))
+	j.UseNumber()
+	callDisallowUnknownFields(j)
+	return j.Decode(v)
+}
+
+// migrate upgrades the dlibox.json content to schemaVersion.
+func migrate(m map[string]interface{}) error {
+	v := 0
+	if raw, ok := m["Version"]; ok {
+		n, ok := raw.(json.Number)
+		if !ok {
+			return fmt.Errorf("invalid Version %v", raw)
+		}
+		i, err := n.Int64()
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid Version %v", raw)
+		}
+		v = int(i)
+	}
+	if v > schemaVersion {
+		return fmt.Errorf("version %d is newer than the supported version %d", v, schemaVersion)
+	}
+	for ; v < schemaVersion; v++ {
+		log.Printf("migrating settings from version %d to %d", v, v+1)
+		if err := migrations[v](m); err != nil {
+			return fmt.Errorf("migration from version %d to %d: %v", v, v+1, err)
+		}
+	}
+	m["Version"] = schemaVersion
+	return nil
+}
+
+// migrateIRSignals converts the IR signals from the topic form "ir/KEY" to
+// the expression form `+/+/ir == "KEY"`.
+func migrateIRSignals(m map[string]interface{}) error {
+	c, ok := m["Config"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	r, ok := c["Rules"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for name, v := range r {
+		rule, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("rule %s: invalid", name)
+		}
+		s, ok := rule["Signal"].(string)
+		if !ok || !strings.HasPrefix(s, "ir/") || strings.ContainsAny(s[3:], "/+# \"") {
+			continue
+		}
+		rule["Signal"] = fmt.Sprintf("+/+/ir == %q", s[3:])
+	}
+	return nil
+}
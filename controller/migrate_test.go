@@ -0,0 +1,66 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maruel/dlibox/nodes"
+)
+
+func TestMigrations(t *testing.T) {
+	if schemaVersion != len(migrations) {
+		t.Fatalf("schemaVersion %d doesn't match the %d migrations", schemaVersion, len(migrations))
+	}
+}
+
+func TestDecodeLocked(t *testing.T) {
+	const v0 = `{
+  "Config": {
+    "Rules": {
+      "play": {"Signal": "ir/KEY_PLAY", "Cmd": {"Topic": "leds/intensity", "Payload": "255"}},
+      "pir": {"Signal": "dev/porch/pir", "Cmd": {"Topic": "leds/intensity", "Payload": "0"}}
+    },
+    "Devices": {
+      "dev1": {"Name": "Porch", "Nodes": {"pir": {"Name": "Motion", "Type": "pir", "Config": {"Pin": "GPIO4"}}}}
+    }
+  }
+}`
+	d := db{}
+	if err := d.decodeLocked(strings.NewReader(v0)); err != nil {
+		t.Fatal(err)
+	}
+	if d.Version != schemaVersion {
+		t.Fatalf("unexpected version %d", d.Version)
+	}
+	if s := d.Config.Rules["play"].Signal; s != "+/+/ir == \"KEY_PLAY\"" {
+		t.Fatalf("unexpected signal %q", s)
+	}
+	if s := d.Config.Rules["pir"].Signal; s != "dev/porch/pir" {
+		t.Fatalf("unexpected signal %q", s)
+	}
+	if c, ok := d.Config.Devices["dev1"].Nodes["pir"].Config.(*nodes.PIR); !ok || c.Pin != "GPIO4" {
+		t.Fatalf("unexpected node %#v", d.Config.Devices["dev1"].Nodes["pir"])
+	}
+
+	data := []string{
+		// Newer version.
+		`{"Version": 1000}`,
+		`{"Version": "1"}`,
+		// Unknown field.
+		`{"Config": {"Foo": 1}}`,
+		// Unknown node type.
+		`{"Config": {"Devices": {"dev1": {"Name": "a", "Nodes": {"n": {"Name": "b", "Type": "foo"}}}}}}`,
+		// Invalid settings.
+		`{"Config": {"Rules": {"a": {"Signal": "a =="}}}}`,
+	}
+	for i, line := range data {
+		d := db{}
+		if err := d.decodeLocked(strings.NewReader(line)); err == nil {
+			t.Fatalf("%d: expected error", i)
+		}
+	}
+}
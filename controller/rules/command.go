@@ -7,7 +7,9 @@ package rules
 import (
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/msgbus"
 )
 
@@ -15,6 +17,16 @@ import (
 //
 // Most commands shall respect the Homie convention.
 // https://github.com/marvinroger/homie
+//
+// A dlibox node is controlled with the topic "<device>/<node>/<property>", or
+// "<device>/<node>/<property>/set" for the output nodes, see
+// nodes.CommandTopic. For example an IR code learned as "tv-power" is
+// transmitted with the topic "<device>/<irtx node>/send" and the payload
+// "tv-power".
+//
+// A third party Homie device is controlled with the topic
+// "//homie/<device>/<node>/<property>/set"; the leading "//" bypasses the
+// "dlibox/" namespace.
 type Command struct {
 	Topic   string
 	Payload string
@@ -41,6 +53,43 @@ func (c *Command) Validate() error {
 		}
 		return nil
 	default:
-		return fmt.Errorf("unsupported command %v", c.Topic)
+		if strings.HasPrefix(c.Topic, "//"+nodes.HomieRoot+"/") {
+			return validateHomieSet(c.Topic[len(nodes.HomieRoot)+3:])
+		}
+		if err := validateNodeCmd(c.Topic); err != nil {
+			return fmt.Errorf("unsupported command %v", c.Topic)
+		}
+		return nil
+	}
+}
+
+// validateNodeCmd validates a "<device>/<node>/<property>[/set]" topic.
+func validateNodeCmd(t string) error {
+	p := strings.Split(t, "/")
+	if len(p) == 4 && p[3] == "set" {
+		p = p[:3]
+	}
+	if len(p) != 3 {
+		return fmt.Errorf("unsupported node command %q", t)
+	}
+	for _, i := range p {
+		if err := nodes.ID(i).Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHomieSet validates a "<device>/<node>/<property>/set" topic.
+func validateHomieSet(t string) error {
+	p := strings.Split(t, "/")
+	if len(p) != 4 || p[3] != "set" {
+		return fmt.Errorf("unsupported homie command %q", t)
+	}
+	for _, i := range p[:3] {
+		if err := nodes.ID(i).Validate(); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,40 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package rules
+
+import "testing"
+
+func TestCommand_Validate(t *testing.T) {
+	valid := []Command{
+		{},
+		{"leds/intensity", "255"},
+		{"//homie/sensor-1/relay/on/set", "true"},
+		{"living/tx/send", "tv-power"},
+		{"living/fan/on/set", "true"},
+		{"living/tape/level/set", "50"},
+	}
+	for i, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+	invalid := []Command{
+		{"", "1"},
+		{"foo/bar", "1"},
+		{"homie/sensor-1/relay/on/set", "true"},
+		{"//homie/sensor-1/relay/on", "true"},
+		{"//homie/sensor-1/relay/$name/set", "true"},
+		{"//homie/sensor-1/relay/on/set/set", "true"},
+		{"living/tx/send/now", "tv-power"},
+		{"living/tx/$send", "tv-power"},
+		{"living/fan/on/set/set", "true"},
+		{"living//send", "tv-power"},
+	}
+	for i, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("%d: expected error", i)
+		}
+	}
+}
@@ -6,17 +6,40 @@
 package rules
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
 	"periph.io/x/periph/conn/ir"
 )
 
 // Signal defines what signal triggers this rule.
 //
-// TODO(maruel): Define the language.
+// See Expr for the language.
 type Signal string
 
-// Eval evaluates if a signal is a trigger for this rule.
-func (s Signal) Eval(input string) bool {
-	return string(s) == input
+// Parse returns the parsed expression.
+//
+// pos is used to calculate the solar events, it can be nil.
+func (s Signal) Parse(pos *sun.Position) (Expr, error) {
+	return Parse(string(s), pos)
+}
+
+// Eval evaluates if a message is a trigger for this rule.
+//
+// Returns false if the signal is invalid. Use Parse() once to not parse the
+// signal on each message.
+func (s Signal) Eval(msg msgbus.Message, now time.Time, pos *sun.Position) bool {
+	e, err := s.Parse(pos)
+	return err == nil && e.Eval(msg, now)
+}
+
+// Validate ensures the signal is valid.
+func (s Signal) Validate(pos *sun.Position) error {
+	_, err := s.Parse(pos)
+	return err
 }
 
 // Rule defines a signal that triggers a command.
@@ -25,32 +48,81 @@ type Rule struct {
 	Cmd    Command
 }
 
+// Validate ensures the rule is valid.
+func (r *Rule) Validate(pos *sun.Position) error {
+	if err := r.Signal.Validate(pos); err != nil {
+		return err
+	}
+	return r.Cmd.Validate()
+}
+
 // Rules is named rules.
 type Rules map[string]Rule
 
+// Validate ensures all the rules are valid.
+//
+// pos is used to calculate the solar events, it can be nil.
+func (r Rules) Validate(pos *sun.Position) error {
+	for name, rule := range r {
+		if len(name) == 0 {
+			return errors.New("rule without a name")
+		}
+		if err := rule.Validate(pos); err != nil {
+			return fmt.Errorf("can't validate rule %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ResetDefault initializes the default rules, each named after its signal.
+func (r *Rules) ResetDefault() {
+	*r = Rules{}
+	for _, rule := range Default() {
+		(*r)[string(rule.Signal)] = rule
+	}
+}
+
+// irKey returns the signal for an IR key press on any device.
+func irKey(k ir.Key) Signal {
+	return Signal("+/+/ir == \"" + string(k) + "\"")
+}
+
+// irGesture returns the signal for a gesture, "repeat", "hold" or "double", on
+// an IR key on any device.
+func irGesture(k ir.Key, gesture string) Signal {
+	return Signal("+/+/ir/" + string(k) + "/" + gesture)
+}
+
 // Default returns default rules that can be set on a fresh instance.
 func Default() []Rule {
 	return []Rule{
-		{Signal("ir/" + string(ir.KEY_CHANNELDOWN)), Command{"leds/temperature", "-500"}},
-		{Signal("ir/" + string(ir.KEY_CHANNEL)), Command{"leds/temperature", "5000"}},
-		{Signal("ir/" + string(ir.KEY_CHANNELUP)), Command{"leds/temperature", "+500"}},
-		{Signal("ir/" + string(ir.KEY_PREVIOUS)), Command{"leds/temperature", "3000"}},
-		{Signal("ir/" + string(ir.KEY_NEXT)), Command{"leds/temperature", "5000"}},
-		{Signal("ir/" + string(ir.KEY_PLAYPAUSE)), Command{"leds/temperature", "6500"}},
-		{Signal("ir/" + string(ir.KEY_VOLUMEDOWN)), Command{"leds/intensity", "-15"}},
-		{Signal("ir/" + string(ir.KEY_VOLUMEUP)), Command{"leds/intensity", "+15"}},
-		{Signal("ir/" + string(ir.KEY_EQ)), Command{"leds/intensity", "128"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_0)), Command{"leds/intensity", "0"}},
-		{Signal("ir/" + string(ir.KEY_100PLUS)), Command{"painter/setuser", "\"#ffffff\""}},
-		{Signal("ir/" + string(ir.KEY_200PLUS)), Command{"leds/intensity", "255"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_1)), Command{"painter/setuser", "\"Rainbow\""}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_2)), Command{"painter/setuser", "{\"Child\":\"Rainbow\",\"MovePerHour\":108000,\"_type\":\"Rotate\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_3)), Command{"painter/setuser", "{\"Child\":{\"Frame\":\"Lff0000ff0000ff0000ff0000ff0000ffffffffffffffffffffffffffffff\",\"_type\":\"Repeated\"},\"MovePerHour\":21600,\"_type\":\"Rotate\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_4)), Command{"painter/setuser", "{\"Child\":\"L0100010f0000000f0000000f\",\"_type\":\"Chronometer\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_5)), Command{"painter/setuser", "{\"Child\":\"Lff0000ff0000ee0000dd0000cc0000bb0000aa0000990000880000770000660000550000440000330000220000110000\",\"MovePerHour\":108000,\"_type\":\"PingPong\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_6)), Command{"painter/setuser", "{\"C\":\"#ff9000\",\"_type\":\"NightStars\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_7)), Command{"painter/setuser", "{\"Curve\":\"ease-out\",\"Patterns\":[{\"Patterns\":[{\"Child\":\"Lff0000ff0000ee0000dd0000cc0000bb0000aa0000990000880000770000660000550000440000330000220000110000\",\"MovePerHour\":108000,\"_type\":\"Rotate\"},{\"_type\":\"Aurore\"}],\"_type\":\"Add\"},{\"Patterns\":[{\"_type\":\"Aurore\"},{\"C\":\"#ffffff\",\"_type\":\"NightStars\"}],\"_type\":\"Add\"}],\"ShowMS\":10000,\"TransitionMS\":5000,\"_type\":\"Loop\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_8)), Command{"painter/setuser", "{\"Left\":{\"Curve\":\"ease-out\",\"Patterns\":[\"#000f00\",\"#00ff00\",\"#1f0f00\",\"#ffa900\"],\"ShowMS\":100,\"TransitionMS\":700,\"_type\":\"Loop\"},\"Offset\":\"50%\",\"Right\":{\"Curve\":\"ease-out\",\"Patterns\":[\"#1f0f00\",\"#ffa900\",\"#000f00\",\"#00ff00\"],\"ShowMS\":100,\"TransitionMS\":700,\"_type\":\"Loop\"},\"_type\":\"Split\"}"}},
-		{Signal("ir/" + string(ir.KEY_NUMERIC_9)), Command{"painter/setuser", "{\"Child\":\"Lffffff\",\"MovePerHour\":108000,\"_type\":\"PingPong\"}"}},
+		{irKey(ir.KEY_CHANNELDOWN), Command{"leds/temperature", "-500"}},
+		{irKey(ir.KEY_CHANNEL), Command{"leds/temperature", "5000"}},
+		{irKey(ir.KEY_CHANNELUP), Command{"leds/temperature", "+500"}},
+		{irKey(ir.KEY_PREVIOUS), Command{"leds/temperature", "3000"}},
+		{irKey(ir.KEY_NEXT), Command{"leds/temperature", "5000"}},
+		{irKey(ir.KEY_PLAYPAUSE), Command{"leds/temperature", "6500"}},
+		{irKey(ir.KEY_VOLUMEDOWN), Command{"leds/intensity", "-15"}},
+		{irKey(ir.KEY_VOLUMEUP), Command{"leds/intensity", "+15"}},
+		// Ramp continuously while the key is held.
+		{irGesture(ir.KEY_CHANNELDOWN, "repeat"), Command{"leds/temperature", "-100"}},
+		{irGesture(ir.KEY_CHANNELUP, "repeat"), Command{"leds/temperature", "+100"}},
+		{irGesture(ir.KEY_VOLUMEDOWN, "repeat"), Command{"leds/intensity", "-5"}},
+		{irGesture(ir.KEY_VOLUMEUP, "repeat"), Command{"leds/intensity", "+5"}},
+		// Bound to a key without a press rule, which would apply first.
+		{irGesture(ir.KEY_POWER, "hold"), Command{"leds/intensity", "255"}},
+		{irKey(ir.KEY_EQ), Command{"leds/intensity", "128"}},
+		{irKey(ir.KEY_NUMERIC_0), Command{"leds/intensity", "0"}},
+		{irKey(ir.KEY_100PLUS), Command{"painter/setuser", "\"#ffffff\""}},
+		{irKey(ir.KEY_200PLUS), Command{"leds/intensity", "255"}},
+		{irKey(ir.KEY_NUMERIC_1), Command{"painter/setuser", "\"Rainbow\""}},
+		{irKey(ir.KEY_NUMERIC_2), Command{"painter/setuser", "{\"Child\":\"Rainbow\",\"MovePerHour\":108000,\"_type\":\"Rotate\"}"}},
+		{irKey(ir.KEY_NUMERIC_3), Command{"painter/setuser", "{\"Child\":{\"Frame\":\"Lff0000ff0000ff0000ff0000ff0000ffffffffffffffffffffffffffffff\",\"_type\":\"Repeated\"},\"MovePerHour\":21600,\"_type\":\"Rotate\"}"}},
+		{irKey(ir.KEY_NUMERIC_4), Command{"painter/setuser", "{\"Child\":\"L0100010f0000000f0000000f\",\"_type\":\"Chronometer\"}"}},
+		{irKey(ir.KEY_NUMERIC_5), Command{"painter/setuser", "{\"Child\":\"Lff0000ff0000ee0000dd0000cc0000bb0000aa0000990000880000770000660000550000440000330000220000110000\",\"MovePerHour\":108000,\"_type\":\"PingPong\"}"}},
+		{irKey(ir.KEY_NUMERIC_6), Command{"painter/setuser", "{\"C\":\"#ff9000\",\"_type\":\"NightStars\"}"}},
+		{irKey(ir.KEY_NUMERIC_7), Command{"painter/setuser", "{\"Curve\":\"ease-out\",\"Patterns\":[{\"Patterns\":[{\"Child\":\"Lff0000ff0000ee0000dd0000cc0000bb0000aa0000990000880000770000660000550000440000330000220000110000\",\"MovePerHour\":108000,\"_type\":\"Rotate\"},{\"_type\":\"Aurore\"}],\"_type\":\"Add\"},{\"Patterns\":[{\"_type\":\"Aurore\"},{\"C\":\"#ffffff\",\"_type\":\"NightStars\"}],\"_type\":\"Add\"}],\"ShowMS\":10000,\"TransitionMS\":5000,\"_type\":\"Loop\"}"}},
+		{irKey(ir.KEY_NUMERIC_8), Command{"painter/setuser", "{\"Left\":{\"Curve\":\"ease-out\",\"Patterns\":[\"#000f00\",\"#00ff00\",\"#1f0f00\",\"#ffa900\"],\"ShowMS\":100,\"TransitionMS\":700,\"_type\":\"Loop\"},\"Offset\":\"50%\",\"Right\":{\"Curve\":\"ease-out\",\"Patterns\":[\"#1f0f00\",\"#ffa900\",\"#000f00\",\"#00ff00\"],\"ShowMS\":100,\"TransitionMS\":700,\"_type\":\"Loop\"},\"_type\":\"Split\"}"}},
+		{irKey(ir.KEY_NUMERIC_9), Command{"painter/setuser", "{\"Child\":\"Lffffff\",\"MovePerHour\":108000,\"_type\":\"PingPong\"}"}},
 	}
 }
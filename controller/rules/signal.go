@@ -0,0 +1,563 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
+)
+
+// Expr is a parsed Signal.
+//
+// The grammar is:
+//
+//	expr    := or
+//	or      := and { ("or" | "||") and }
+//	and     := unary { ("and" | "&&") unary }
+//	unary   := ("not" | "!") unary | primary
+//	primary := "(" expr ")" | guard | match
+//	guard   := ("after" | "before") clock
+//	match   := topic [ op value ]
+//	op      := "==" | "!=" | "<" | "<=" | ">" | ">="
+//	value   := "\"" string "\"" | number
+//	clock   := HH:MM | event [ ("+" | "-") duration ]
+//
+// A topic is a MQTT topic query relative to "dlibox/", it can use the
+// wildcards "+" and "#". A match without an operator is true when the message
+// topic matches the topic query. A match with an operator also requires the
+// payload to compare successfully to the value. Only "==" and "!=" are
+// supported with a string value. A number value requires the payload to be a
+// number, e.g. "dev1/env/temperature > 25" for a sensor node.
+//
+// A guard is evaluated against the local wall clock. "after 18:30" is true
+// from 18:30 until midnight, "before 07:00" is true from midnight until 7:00.
+// Use "after 22:00 or before 6:00" to define a range across midnight.
+//
+// A guard can also refer to a solar event of the day, like "sunrise" or
+// "sunset", with an optional offset, e.g. "after sunset-30m". See sun.Event for
+// the known events. A guard on a solar event is false on the days it doesn't
+// happen.
+type Expr interface {
+	// Eval returns true if the message triggers the expression at time now.
+	Eval(msg msgbus.Message, now time.Time) bool
+	fmt.Stringer
+}
+
+// SyntaxError is returned by Parse when the signal is invalid.
+type SyntaxError struct {
+	Signal string
+	Offset int // Offset in bytes in Signal where the error occurred.
+	Msg    string
+}
+
+func (s *SyntaxError) Error() string {
+	return fmt.Sprintf("signal %q: %s at offset %d", s.Signal, s.Msg, s.Offset)
+}
+
+// Parse parses a signal into an expression.
+//
+// pos is used to calculate the solar events. It can be nil when the signal
+// doesn't use any.
+func Parse(s string, pos *sun.Position) (Expr, error) {
+	p := parser{src: s, pos: pos}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 1 {
+		return nil, p.errorf(p.tokens[0], "empty signal")
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+	return e, nil
+}
+
+// Private details.
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of signal"
+	case tokString:
+		return strconv.Quote(t.val)
+	default:
+		return fmt.Sprintf("%q", t.val)
+	}
+}
+
+type parser struct {
+	src    string
+	pos    *sun.Position
+	tokens []token
+	i      int
+}
+
+func (p *parser) errorf(t token, f string, arg ...interface{}) error {
+	return &SyntaxError{Signal: p.src, Offset: t.pos, Msg: fmt.Sprintf(f, arg...)}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isOpChar(c byte) bool {
+	return c == '=' || c == '!' || c == '<' || c == '>' || c == '&' || c == '|'
+}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")", i})
+			i++
+		case c == '"':
+			start := i
+			var b []byte
+			for i++; ; i++ {
+				if i >= len(s) {
+					return &SyntaxError{Signal: s, Offset: start, Msg: "unterminated string"}
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					b = append(b, s[i])
+					continue
+				}
+				if s[i] == '"' {
+					break
+				}
+				b = append(b, s[i])
+			}
+			i++
+			p.tokens = append(p.tokens, token{tokString, string(b), start})
+		case isOpChar(c):
+			start := i
+			for i < len(s) && isOpChar(s[i]) {
+				i++
+			}
+			op := s[start:i]
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=":
+				p.tokens = append(p.tokens, token{tokOp, op, start})
+			case "&&":
+				p.tokens = append(p.tokens, token{tokAnd, op, start})
+			case "||":
+				p.tokens = append(p.tokens, token{tokOr, op, start})
+			case "!":
+				p.tokens = append(p.tokens, token{tokNot, op, start})
+			default:
+				return &SyntaxError{Signal: s, Offset: start, Msg: fmt.Sprintf("unknown operator %q", op)}
+			}
+		default:
+			start := i
+			for i < len(s) && !isSpace(s[i]) && !isOpChar(s[i]) && s[i] != '(' && s[i] != ')' && s[i] != '"' {
+				i++
+			}
+			w := s[start:i]
+			switch w {
+			case "and":
+				p.tokens = append(p.tokens, token{tokAnd, w, start})
+			case "or":
+				p.tokens = append(p.tokens, token{tokOr, w, start})
+			case "not":
+				p.tokens = append(p.tokens, token{tokNot, w, start})
+			default:
+				p.tokens = append(p.tokens, token{tokWord, w, start})
+			}
+		}
+	}
+	p.tokens = append(p.tokens, token{tokEOF, "", len(s)})
+	return nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &orExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &andExpr{l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, p.errorf(c, "expected \")\", got %s", c)
+		}
+		return e, nil
+	case tokWord:
+		switch t.val {
+		case "after", "before":
+			return p.parseGuard(t)
+		}
+		return p.parseMatch(t)
+	default:
+		return nil, p.errorf(t, "expected topic or \"(\", got %s", t)
+	}
+}
+
+func (p *parser) parseGuard(t token) (Expr, error) {
+	c := p.next()
+	if c.kind != tokWord {
+		return nil, p.errorf(c, "expected time of day after %q, got %s", t.val, c)
+	}
+	ref, err := parseTimeRef(c.val, p.pos)
+	if err != nil {
+		return nil, p.errorf(c, "%v", err)
+	}
+	return &guardExpr{after: t.val == "after", ref: ref}, nil
+}
+
+func (p *parser) parseMatch(t token) (Expr, error) {
+	q, err := parseTopicQuery(t.val)
+	if err != nil {
+		return nil, p.errorf(t, "%v", err)
+	}
+	m := &matchExpr{query: q}
+	if p.peek().kind != tokOp {
+		return m, nil
+	}
+	m.op = p.next().val
+	v := p.next()
+	switch v.kind {
+	case tokString:
+		if m.op != "==" && m.op != "!=" {
+			return nil, p.errorf(v, "operator %q requires a number, got %s", m.op, v)
+		}
+		m.str = v.val
+	case tokWord:
+		if m.num, err = strconv.ParseFloat(v.val, 64); err != nil {
+			return nil, p.errorf(v, "expected number or string, got %s", v)
+		}
+		m.isNum = true
+	default:
+		return nil, p.errorf(v, "expected number or string, got %s", v)
+	}
+	return m, nil
+}
+
+// topicQuery is a parsed MQTT topic query.
+type topicQuery []string
+
+func parseTopicQuery(s string) (topicQuery, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("empty topic")
+	}
+	q := topicQuery(strings.Split(s, "/"))
+	for i, e := range q {
+		if e == "#" {
+			if i != len(q)-1 {
+				return nil, fmt.Errorf("wildcard # can only appear at the end of topic %q", s)
+			}
+		} else if e != "+" && strings.ContainsAny(e, "+#") {
+			return nil, fmt.Errorf("wildcard must occupy a whole level in topic %q", s)
+		}
+	}
+	return q, nil
+}
+
+func (q topicQuery) match(topic string) bool {
+	t := strings.Split(topic, "/")
+	for i, e := range q {
+		if e == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if e != "+" && e != t[i] {
+			return false
+		}
+	}
+	return len(q) == len(t)
+}
+
+func (q topicQuery) String() string {
+	return strings.Join(q, "/")
+}
+
+// timeRef is a reference time within a day.
+type timeRef interface {
+	// at returns the time on the day of t. Returns false if there is none on
+	// this day.
+	at(t time.Time) (time.Time, bool)
+	fmt.Stringer
+}
+
+// clock is a fixed wall clock time.
+type clock struct {
+	hour, minute int
+}
+
+func (c clock) at(t time.Time) (time.Time, bool) {
+	return time.Date(t.Year(), t.Month(), t.Day(), c.hour, c.minute, 0, 0, t.Location()), true
+}
+
+func (c clock) String() string {
+	return fmt.Sprintf("%02d:%02d", c.hour, c.minute)
+}
+
+// solar is a solar event with an offset.
+type solar struct {
+	event  sun.Event
+	offset time.Duration
+	pos    *sun.Position
+	src    string
+}
+
+func (s *solar) at(t time.Time) (time.Time, bool) {
+	e, ok := s.event.On(s.pos, t.Year(), t.Month(), t.Day(), t.Location())
+	return e.Add(s.offset), ok
+}
+
+func (s *solar) String() string {
+	return s.src
+}
+
+func parseTimeRef(s string, pos *sun.Position) (timeRef, error) {
+	i := strings.IndexByte(s, ':')
+	if i == -1 {
+		return parseSolar(s, pos)
+	}
+	h, err := strconv.Atoi(s[:i])
+	if err != nil || !isDigits(s[:i]) || h >= 24 {
+		return nil, fmt.Errorf("invalid hour in %q", s)
+	}
+	m, err := strconv.Atoi(s[i+1:])
+	if err != nil || !isDigits(s[i+1:]) || m >= 60 || len(s[i+1:]) != 2 {
+		return nil, fmt.Errorf("invalid minute in %q", s)
+	}
+	return clock{h, m}, nil
+}
+
+func parseSolar(s string, pos *sun.Position) (timeRef, error) {
+	out := &solar{event: sun.Event(s), pos: pos, src: s}
+	// Event names can contain "-", e.g. "civil-dusk".
+	if i := strings.LastIndexAny(s, "+-"); i > 0 {
+		if d, err := time.ParseDuration(s[i:]); err == nil {
+			out.event = sun.Event(s[:i])
+			out.offset = d
+		}
+	}
+	if err := out.event.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid time of day %q, expected HH:MM or a solar event", s)
+	}
+	if pos == nil {
+		return nil, fmt.Errorf("%s requires the alarms Position", out.event)
+	}
+	return out, nil
+}
+
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+type orExpr struct {
+	l, r Expr
+}
+
+func (o *orExpr) Eval(msg msgbus.Message, now time.Time) bool {
+	return o.l.Eval(msg, now) || o.r.Eval(msg, now)
+}
+
+func (o *orExpr) String() string {
+	return fmt.Sprintf("(%s or %s)", o.l, o.r)
+}
+
+type andExpr struct {
+	l, r Expr
+}
+
+func (a *andExpr) Eval(msg msgbus.Message, now time.Time) bool {
+	return a.l.Eval(msg, now) && a.r.Eval(msg, now)
+}
+
+func (a *andExpr) String() string {
+	return fmt.Sprintf("(%s and %s)", a.l, a.r)
+}
+
+type notExpr struct {
+	e Expr
+}
+
+func (n *notExpr) Eval(msg msgbus.Message, now time.Time) bool {
+	return !n.e.Eval(msg, now)
+}
+
+func (n *notExpr) String() string {
+	return fmt.Sprintf("not %s", n.e)
+}
+
+type guardExpr struct {
+	after bool
+	ref   timeRef
+}
+
+func (g *guardExpr) Eval(msg msgbus.Message, now time.Time) bool {
+	t, ok := g.ref.at(now)
+	if !ok {
+		return false
+	}
+	if g.after {
+		return !now.Before(t)
+	}
+	return now.Before(t)
+}
+
+func (g *guardExpr) String() string {
+	if g.after {
+		return "after " + g.ref.String()
+	}
+	return "before " + g.ref.String()
+}
+
+type matchExpr struct {
+	query topicQuery
+	op    string
+	str   string
+	num   float64
+	isNum bool
+}
+
+func (m *matchExpr) Eval(msg msgbus.Message, now time.Time) bool {
+	if !m.query.match(msg.Topic) {
+		return false
+	}
+	if m.op == "" {
+		return true
+	}
+	p := string(msg.Payload)
+	if !m.isNum {
+		if m.op == "==" {
+			return p == m.str
+		}
+		return p != m.str
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+	if err != nil {
+		return false
+	}
+	switch m.op {
+	case "==":
+		return v == m.num
+	case "!=":
+		return v != m.num
+	case "<":
+		return v < m.num
+	case "<=":
+		return v <= m.num
+	case ">":
+		return v > m.num
+	case ">=":
+		return v >= m.num
+	}
+	return false
+}
+
+func (m *matchExpr) String() string {
+	if m.op == "" {
+		return m.query.String()
+	}
+	if m.isNum {
+		return fmt.Sprintf("%s %s %s", m.query, m.op, strconv.FormatFloat(m.num, 'g', -1, 64))
+	}
+	return fmt.Sprintf("%s %s %q", m.query, m.op, m.str)
+}
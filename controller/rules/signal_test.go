@@ -0,0 +1,186 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/ir"
+)
+
+var montreal = &sun.Position{Latitude: 45.5017, Longitude: -73.5673}
+
+func TestParse(t *testing.T) {
+	data := []struct {
+		in       string
+		expected string
+	}{
+		{"a/b", "a/b"},
+		{"+/porch/pir", "+/porch/pir"},
+		{"a/#", "a/#"},
+		{"a == \"true\"", "a == \"true\""},
+		{"a>25.5", "a > 25.5"},
+		{"a and b or c", "((a and b) or c)"},
+		{"a && (b || c)", "(a and (b or c))"},
+		{"not a", "not a"},
+		{"!a && !b", "(not a and not b)"},
+		{"+/porch/pir and after 18:30", "(+/porch/pir and after 18:30)"},
+		{"a and (after 22:00 or before 06:00)", "(a and (after 22:00 or before 06:00))"},
+		{"a and after sunset", "(a and after sunset)"},
+		{"a and before sunrise+30m", "(a and before sunrise+30m)"},
+		{"a and after civil-dusk-1h", "(a and after civil-dusk-1h)"},
+	}
+	for i, line := range data {
+		e, err := Parse(line.in, montreal)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if s := e.String(); s != line.expected {
+			t.Fatalf("%d: %q != %q", i, line.expected, s)
+		}
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	data := []struct {
+		in     string
+		offset int
+	}{
+		{"", 0},
+		{"a ==", 4},
+		{"a == b", 5},
+		{"a > \"x\"", 4},
+		{"a and", 5},
+		{"(a or b", 7},
+		{"a b", 2},
+		{"a/#/b", 0},
+		{"a/b+", 0},
+		{"a = 1", 2},
+		{"a == \"1", 5},
+		{"after 25:00", 6},
+		{"a and before", 12},
+		{"after +5:00", 6},
+		{"after 5:+5", 6},
+		{"after moonrise", 6},
+		{"after sunset+soon", 6},
+		// The position is required.
+		{"after sunset", 6},
+	}
+	for i, line := range data {
+		_, err := Parse(line.in, nil)
+		if err == nil {
+			t.Fatalf("%d: expected error for %q", i, line.in)
+		}
+		s, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("%d: unexpected error type %T", i, err)
+		}
+		if s.Offset != line.offset {
+			t.Fatalf("%d: %q: expected offset %d; got %v", i, line.in, line.offset, err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	evening := time.Date(2018, 10, 31, 19, 0, 0, 0, time.UTC)
+	morning := time.Date(2018, 10, 31, 9, 0, 0, 0, time.UTC)
+	data := []struct {
+		signal   Signal
+		topic    string
+		payload  string
+		now      time.Time
+		expected bool
+	}{
+		{"a/b", "a/b", "", evening, true},
+		{"a/b", "a/c", "", evening, false},
+		{"a/+", "a/c", "", evening, true},
+		{"a/+", "a/c/d", "", evening, false},
+		{"a/#", "a/c/d", "", evening, true},
+		{"a/#", "a", "", evening, true},
+		{"a == \"true\"", "a", "true", evening, true},
+		{"a == \"true\"", "a", "false", evening, false},
+		{"a != \"true\"", "a", "false", evening, true},
+		{"a > 25.5", "a", "26", evening, true},
+		{"a > 25.5", "a", "25.5", evening, false},
+		{"a >= 25.5", "a", "25.5", evening, true},
+		{"a < 25.5", "a", "hot", evening, false},
+		{"a == 1", "a", "1.0", evening, true},
+		{"not a", "b", "", evening, true},
+		{"a or b", "b", "", evening, true},
+		{"+/porch/pir and after 18:30", "dev/porch/pir", "1", evening, true},
+		{"+/porch/pir and after 18:30", "dev/porch/pir", "1", morning, false},
+		{"a and before 10:00", "a", "", morning, true},
+		{"a and (after 22:00 or before 06:00)", "a", "", morning, false},
+		{"a ==", "a", "", evening, false},
+		{"+/+/ir/KEY_VOLUMEUP/repeat", "dev/remote/ir/KEY_VOLUMEUP/repeat", "3", evening, true},
+		{"+/+/ir/KEY_VOLUMEUP/repeat > 5", "dev/remote/ir/KEY_VOLUMEUP/repeat", "3", evening, false},
+		{"+/+/ir/KEY_VOLUMEUP/repeat", "dev/remote/ir/KEY_VOLUMEUP/hold", "KEY_VOLUMEUP", evening, false},
+	}
+	for i, line := range data {
+		msg := msgbus.Message{Topic: line.topic, Payload: []byte(line.payload)}
+		if actual := line.signal.Eval(msg, line.now, nil); actual != line.expected {
+			t.Fatalf("%d: %q.Eval(%q, %q) = %t", i, line.signal, line.topic, line.payload, actual)
+		}
+	}
+}
+
+func TestEvalSolar(t *testing.T) {
+	// The sunrise is at 7:32 and the sunset at 17:43.
+	edt := time.FixedZone("EDT", -4*3600)
+	at := func(h, m int) time.Time {
+		return time.Date(2018, 10, 31, h, m, 0, 0, edt)
+	}
+	data := []struct {
+		signal   Signal
+		now      time.Time
+		expected bool
+	}{
+		{"a and after sunset", at(17, 30), false},
+		{"a and after sunset", at(18, 0), true},
+		{"a and after sunset-30m", at(17, 30), true},
+		{"a and before sunrise", at(7, 0), true},
+		{"a and before sunrise", at(7, 45), false},
+		{"a and before sunrise+30m", at(7, 45), true},
+		{"a and (after sunset or before sunrise)", at(23, 0), true},
+		{"a and (after sunset or before sunrise)", at(12, 0), false},
+	}
+	msg := msgbus.Message{Topic: "a"}
+	for i, line := range data {
+		if actual := line.signal.Eval(msg, line.now, montreal); actual != line.expected {
+			t.Fatalf("%d: %q at %s = %t", i, line.signal, line.now, actual)
+		}
+	}
+	// The sun doesn't set during the polar night.
+	svalbard := &sun.Position{Latitude: 78.2232, Longitude: 15.6267}
+	if Signal("a and after sunset").Eval(msg, time.Date(2018, 12, 21, 23, 0, 0, 0, time.UTC), svalbard) {
+		t.Fatal("sunset during polar night")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	var r Rules
+	r.ResetDefault()
+	if len(r) != len(Default()) {
+		t.Fatalf("%d != %d", len(r), len(Default()))
+	}
+	if err := r.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	// The press rule of a key would apply before its hold or double press rule.
+	for _, rule := range Default() {
+		s := string(rule.Signal)
+		if !strings.HasPrefix(s, "+/+/ir/") || strings.HasSuffix(s, "/repeat") {
+			continue
+		}
+		k := strings.Split(s, "/")[3]
+		if _, ok := r[string(irKey(ir.Key(k)))]; ok {
+			t.Fatalf("%s has a press rule", k)
+		}
+	}
+}
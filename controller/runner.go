@@ -0,0 +1,206 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/dlibox/controller/sun"
+	"github.com/maruel/msgbus"
+)
+
+// busListener shares a single subscription to all the messages on a bus
+// between multiple handlers.
+//
+// msgbus unsubscribes all the subscriptions with the same topic query at once,
+// so the rules and the state machines can't each subscribe to "#".
+type busListener struct {
+	b msgbus.Bus
+
+	mu       sync.Mutex
+	next     int
+	handlers map[int]func(msg msgbus.Message)
+}
+
+// listenAll subscribes to all the messages on b.
+func listenAll(b msgbus.Bus) (*busListener, error) {
+	l := &busListener{b: b, handlers: map[int]func(msg msgbus.Message){}}
+	c, err := b.Subscribe("#", msgbus.ExactlyOnce)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for msg := range c {
+			l.dispatch(msg)
+		}
+	}()
+	return l, nil
+}
+
+// Close unsubscribes; the handlers are not called anymore.
+func (l *busListener) Close() error {
+	l.b.Unsubscribe("#")
+	return nil
+}
+
+// add registers f to be called for every message and returns its ID for
+// remove.
+func (l *busListener) add(f func(msg msgbus.Message)) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	l.handlers[l.next] = f
+	return l.next
+}
+
+// remove unregisters the handler id returned by add.
+func (l *busListener) remove(id int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.handlers, id)
+}
+
+func (l *busListener) dispatch(msg msgbus.Message) {
+	l.mu.Lock()
+	handlers := make([]func(msg msgbus.Message), 0, len(l.handlers))
+	for _, f := range l.handlers {
+		handlers = append(handlers, f)
+	}
+	l.mu.Unlock()
+	for _, f := range handlers {
+		f(msg)
+	}
+}
+
+// ruleStats is the firing statistics of a rule.
+type ruleStats struct {
+	Count int
+	Last  time.Time
+}
+
+type compiledRule struct {
+	expr rules.Expr
+	cmd  rules.Command
+}
+
+// rulesRunner evaluates every rule against every message received on the
+// bus, and publishes the rule's command when the rule fires.
+type rulesRunner struct {
+	b  msgbus.Bus
+	l  *busListener
+	id int // Handler ID in l.
+
+	mu    sync.Mutex
+	rules map[string]compiledRule
+	stats map[string]*ruleStats
+}
+
+// initRules starts a rulesRunner that listens to all the messages of l.
+//
+// pos is used to calculate the solar events, it can be nil.
+func initRules(l *busListener, r rules.Rules, pos *sun.Position) (*rulesRunner, error) {
+	rr := &rulesRunner{b: l.b, l: l}
+	if err := rr.reset(r, pos); err != nil {
+		return nil, err
+	}
+	rr.id = l.add(rr.onMsg)
+	return rr, nil
+}
+
+func (r *rulesRunner) Close() error {
+	r.l.remove(r.id)
+	return nil
+}
+
+// reset replaces the rules being evaluated.
+//
+// The statistics of the rules that are kept are preserved. On failure, the
+// previous rules are kept.
+func (r *rulesRunner) reset(src rules.Rules, pos *sun.Position) error {
+	compiled, err := compileRules(src, pos)
+	if err != nil {
+		return err
+	}
+	r.set(compiled)
+	return nil
+}
+
+// compileRules parses the signal of each rule.
+func compileRules(src rules.Rules, pos *sun.Position) (map[string]compiledRule, error) {
+	compiled := make(map[string]compiledRule, len(src))
+	for name, rule := range src {
+		e, err := rule.Signal.Parse(pos)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", name, err)
+		}
+		compiled[name] = compiledRule{e, rule.Cmd}
+	}
+	return compiled, nil
+}
+
+// set replaces the rules being evaluated with the ones returned by
+// compileRules.
+//
+// The statistics of the rules that are kept are preserved.
+func (r *rulesRunner) set(compiled map[string]compiledRule) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]*ruleStats, len(compiled))
+	for name := range compiled {
+		if s := r.stats[name]; s != nil {
+			stats[name] = s
+		} else {
+			stats[name] = &ruleStats{}
+		}
+	}
+	r.rules = compiled
+	r.stats = stats
+}
+
+// getStats returns a copy of the statistics of all the rules.
+func (r *rulesRunner) getStats() map[string]ruleStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make(map[string]ruleStats, len(r.stats))
+	for name, s := range r.stats {
+		out[name] = *s
+	}
+	return out
+}
+
+func (r *rulesRunner) onMsg(msg msgbus.Message) {
+	now := time.Now()
+	var cmds []rules.Command
+	func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for name, rule := range r.rules {
+			if rule.expr.Eval(msg, now) {
+				s := r.stats[name]
+				s.Count++
+				s.Last = now
+				if len(rule.cmd.Topic) != 0 {
+					cmds = append(cmds, rule.cmd)
+				}
+			}
+		}
+	}()
+	if len(cmds) == 0 {
+		return
+	}
+	// Publish asynchronously; the commands may be delivered back to this
+	// subscription and Publish() could block until it is read.
+	go func() {
+		for _, cmd := range cmds {
+			if err := r.b.Publish(cmd.ToMsg(), msgbus.ExactlyOnce); err != nil {
+				log.Printf("rules: %s->%v: %v", msg.Topic, cmd, err)
+			}
+		}
+	}()
+}
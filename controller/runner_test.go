@@ -0,0 +1,152 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/controller/rules"
+	"github.com/maruel/msgbus"
+)
+
+func TestRulesRunner(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	cfg := rules.Rules{
+		"porch": {Signal: "+/porch/pir == \"true\"", Cmd: rules.Command{Topic: "leds/intensity", Payload: "255"}},
+	}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r, err := initRules(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	c, err := b.Subscribe("leds/intensity", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Publish(msgbus.Message{Topic: "dev/porch/pir", Payload: []byte("false")}, msgbus.BestEffort); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(msgbus.Message{Topic: "dev/porch/pir", Payload: []byte("true")}, msgbus.BestEffort); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-c:
+		if string(msg.Payload) != "255" {
+			t.Fatalf("unexpected payload %q", msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rule didn't fire")
+	}
+	s := r.getStats()
+	if s["porch"].Count != 1 || s["porch"].Last.IsZero() {
+		t.Fatalf("unexpected stats %#v", s)
+	}
+
+	// Reloading keeps the stats of the rules that are kept.
+	cfg["other"] = rules.Rule{Signal: "a/b"}
+	if err := r.reset(cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	s = r.getStats()
+	if len(s) != 2 || s["porch"].Count != 1 || s["other"].Count != 0 {
+		t.Fatalf("unexpected stats %#v", s)
+	}
+	if err := r.reset(rules.Rules{"bad": {Signal: "a =="}}, nil); err == nil {
+		t.Fatal("expected error")
+	}
+	if s = r.getStats(); len(s) != 2 {
+		t.Fatalf("unexpected stats %#v", s)
+	}
+}
+
+func TestBusListener_Close(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r, err := initRules(l, rules.Rules{"r": {Signal: "button"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := stateMachines{
+		"toggle": {
+			Enabled: true,
+			Initial: "off",
+			States: map[string]*stateCfg{
+				"off": {Transitions: []transition{{"button", "on"}}},
+				"on":  {},
+			},
+		},
+	}
+	f, err := initFSM(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// Closing the rules doesn't stop the state machines.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(msgbus.Message{Topic: "button", Payload: []byte("1")}, msgbus.BestEffort); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); f.getStates()["toggle"] != "on"; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the state machine didn't transition")
+		}
+	}
+	if s := r.getStats(); s["r"].Count != 0 {
+		t.Fatalf("unexpected stats %#v", s)
+	}
+}
+
+func TestRulesRunner_IRTx(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	cfg := rules.Rules{
+		"tv": {Signal: "+/+/ir == \"KEY_POWER\"", Cmd: rules.Command{Topic: "living/tx/send", Payload: "tv-power"}},
+	}
+	if err := cfg.Validate(nil); err != nil {
+		t.Fatal(err)
+	}
+	l, err := listenAll(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	r, err := initRules(l, cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	// As subscribed by the IRTx node "tx" of the device "living", without
+	// Homie.
+	c, err := msgbus.RebaseSub(b, "living/tx").Subscribe("send", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(msgbus.Message{Topic: "living/remote/ir", Payload: []byte("KEY_POWER")}, msgbus.BestEffort); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-c:
+		if string(msg.Payload) != "tv-power" {
+			t.Fatalf("unexpected payload %q", msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rule didn't fire")
+	}
+}
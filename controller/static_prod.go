@@ -14,7 +14,7 @@ func getContent(path string) []byte {
 var staticContent = map[string][]byte{
 	"static/colorpicker.js": []byte("(function(window,document,undefined){var picker,slide;var hueOffset=15;function mousePosition(evt){if(window.event&&window.event.contentOverflow!==undefined){return{x:window.event.offsetX,y:window.event.offsetY};}\nif(evt.offsetX!==undefined&&evt.offsetY!==undefined){return{x:evt.offsetX,y:evt.offsetY};}\nvar wrapper=evt.target.parentNode.parentNode;return{x:evt.layerX-wrapper.offsetLeft,y:evt.layerY-wrapper.offsetTop};}\nfunction $(el,attrs,children){el=document.createElementNS('http://www.w3.org/2000/svg',el);for(var key in attrs){el.setAttribute(key,attrs[key]);}\nif(Object.prototype.toString.call(children)!='[object Array]'){children=[children];}\nvar i=0,len=(children[0]&&children.length)||0;for(;i<len;i++){el.appendChild(children[i]);}\nreturn el;}\nslide=$('svg',{xmlns:'http://www.w3.org/2000/svg',version:'1.1',width:'100%',height:'100%'},[$('defs',{},$('linearGradient',{id:'gradient-hsv',x1:'0%',y1:'100%',x2:'0%',y2:'0%'},[$('stop',{offset:'0%','stop-color':'#FF0000','stop-opacity':'1'}),$('stop',{offset:'13%','stop-color':'#FF00FF','stop-opacity':'1'}),$('stop',{offset:'25%','stop-color':'#8000FF','stop-opacity':'1'}),$('stop',{offset:'38%','stop-color':'#0040FF','stop-opacity':'1'}),$('stop',{offset:'50%','stop-color':'#00FFFF','stop-opacity':'1'}),$('stop',{offset:'63%','stop-color':'#00FF40','stop-opacity':'1'}),$('stop',{offset:'75%','stop-color':'#0BED00','stop-opacity':'1'}),$('stop',{offset:'88%','stop-color':'#FFFF00','stop-opacity':'1'}),$('stop',{offset:'100%','stop-color':'#FF0000','stop-opacity':'1'})])),$('rect',{x:'0',y:'0',width:'100%',height:'100%',fill:'url(#gradient-hsv)'})]);picker=$('svg',{xmlns:'http://www.w3.org/2000/svg',version:'1.1',width:'100%',height:'100%'},[$('defs',{},[$('linearGradient',{id:'gradient-black',x1:'0%',y1:'100%',x2:'0%',y2:'0%'},[$('stop',{offset:'0%','stop-color':'#000000','stop-opacity':'1'}),$('stop',{offset:'100%','stop-color':'#CC9A81','stop-opacity':'0'})]),$('linearGradient',{id:'gradient-white',x1:'0%',y1:'100%',x2:'100%',y2:'100%'},[$('stop',{offset:'0%','stop-color':'#FFFFFF','stop-opacity':'1'}),$('stop',{offset:'100%','stop-color':'#CC9A81','stop-opacity':'0'})])]),$('rect',{x:'0',y:'0',width:'100%',height:'100%',fill:'url(#gradient-white)'}),$('rect',{x:'0',y:'0',width:'100%',height:'100%',fill:'url(#gradient-black)'})]);function hsv2rgb(hsv){var h=(hsv.h%360)/60;var C=hsv.v*hsv.s;var X=C*(1-Math.abs(h%2-1));h=~~h;var R=hsv.v-C;var G=R+[X,C,C,X,0,0][h];var B=R+[0,0,X,C,C,X][h];R+=[C,X,0,0,X,C][h];var r=Math.floor(R*255);var g=Math.floor(G*255);var b=Math.floor(B*255);return{r:r,g:g,b:b,hex:\"#\"+(16777216|b|(g<<8)|(r<<16)).toString(16).slice(1)};}\nfunction rgb2hsv(rgb){var r=rgb.r;var g=rgb.g;var b=rgb.b;if(rgb.r>1||rgb.g>1||rgb.b>1){r/=255;g/=255;b/=255;}\nvar V=Math.max(r,g,b);var C=V-Math.min(r,g,b);var H=(C==0?null:V==r?(g-b)/C+(g<b?6:0):V==g?(b-r)/C+2:(r-g)/C+4);H=(H%6)*60;var S=C==0?0:C/V;return{h:H,s:S,v:V};}\nfunction slideListener(ctx,slideElement,pickerElement){return function(evt){evt=evt||window.event;var mouse=mousePosition(evt);ctx.h=mouse.y/slideElement.offsetHeight*360+hueOffset;var pickerColor=hsv2rgb({h:ctx.h,s:1,v:1});var c=hsv2rgb({h:ctx.h,s:ctx.s,v:ctx.v});pickerElement.style.backgroundColor=pickerColor.hex;ctx.callback&&ctx.callback(c.hex,{h:ctx.h-hueOffset,s:ctx.s,v:ctx.v},{r:c.r,g:c.g,b:c.b},undefined,mouse);}};function pickerListener(ctx,pickerElement){return function(evt){evt=evt||window.event;var mouse=mousePosition(evt),width=pickerElement.offsetWidth,height=pickerElement.offsetHeight;ctx.s=mouse.x/width;ctx.v=(height-mouse.y)/height;var c=hsv2rgb(ctx);ctx.callback&&ctx.callback(c.hex,{h:ctx.h-hueOffset,s:ctx.s,v:ctx.v},{r:c.r,g:c.g,b:c.b},mouse);}};var uniqID=0;function ColorPicker(slideElement,pickerElement,callback){if(!(this instanceof ColorPicker)){return new ColorPicker(slideElement,pickerElement,callback);}\nthis.h=0;this.s=1;this.v=1;this.callback=callback;this.pickerElement=pickerElement;this.slideElement=slideElement;var slideClone=slide.cloneNode(true);var pickerClone=picker.cloneNode(true);var hsvGradient=slideClone.getElementById('gradient-hsv');var hsvRect=slideClone.getElementsByTagName('rect')[0];hsvGradient.id='gradient-hsv-'+uniqID;hsvRect.setAttribute('fill','url(#'+hsvGradient.id+')');var blackAndWhiteGradients=[pickerClone.getElementById('gradient-black'),pickerClone.getElementById('gradient-white')];var whiteAndBlackRects=pickerClone.getElementsByTagName('rect');blackAndWhiteGradients[0].id='gradient-black-'+uniqID;blackAndWhiteGradients[1].id='gradient-white-'+uniqID;whiteAndBlackRects[0].setAttribute('fill','url(#'+blackAndWhiteGradients[1].id+')');whiteAndBlackRects[1].setAttribute('fill','url(#'+blackAndWhiteGradients[0].id+')');this.slideElement.appendChild(slideClone);this.pickerElement.appendChild(pickerClone);uniqID++;addEventListener(this.slideElement,'click',slideListener(this,this.slideElement,this.pickerElement));addEventListener(this.pickerElement,'click',pickerListener(this,this.pickerElement));enableDragging(this,this.slideElement,slideListener(this,this.slideElement,this.pickerElement));enableDragging(this,this.pickerElement,pickerListener(this,this.pickerElement));};function addEventListener(element,event,listener){if(element.attachEvent){element.attachEvent('on'+event,listener);}else if(element.addEventListener){element.addEventListener(event,listener,false);}}\nfunction enableDragging(ctx,element,listener){var mousedown=false;addEventListener(element,'mousedown',function(evt){mousedown=true;});addEventListener(element,'mouseup',function(evt){mousedown=false;});addEventListener(element,'mouseout',function(evt){mousedown=false;});addEventListener(element,'mousemove',function(evt){if(mousedown){listener(evt);}});}\nColorPicker.hsv2rgb=function(hsv){var rgbHex=hsv2rgb(hsv);delete rgbHex.hex;return rgbHex;};ColorPicker.hsv2hex=function(hsv){return hsv2rgb(hsv).hex;};ColorPicker.rgb2hsv=rgb2hsv;ColorPicker.rgb2hex=function(rgb){return hsv2rgb(rgb2hsv(rgb)).hex;};ColorPicker.hex2hsv=function(hex){return rgb2hsv(ColorPicker.hex2rgb(hex));};ColorPicker.hex2rgb=function(hex){return{r:parseInt(hex.substr(1,2),16),g:parseInt(hex.substr(3,2),16),b:parseInt(hex.substr(5,2),16)};};function setColor(ctx,hsv,rgb,hex){ctx.h=hsv.h%360;ctx.s=hsv.s;ctx.v=hsv.v;var c=hsv2rgb(ctx);var mouseSlide={y:(ctx.h*ctx.slideElement.offsetHeight)/360,x:0};var pickerHeight=ctx.pickerElement.offsetHeight;var mousePicker={x:ctx.s*ctx.pickerElement.offsetWidth,y:pickerHeight-ctx.v*pickerHeight};ctx.pickerElement.style.backgroundColor=hsv2rgb({h:ctx.h,s:1,v:1}).hex;ctx.callback&&ctx.callback(hex||c.hex,{h:ctx.h,s:ctx.s,v:ctx.v},rgb||{r:c.r,g:c.g,b:c.b},mousePicker,mouseSlide);return ctx;};ColorPicker.prototype.setHsv=function(hsv){return setColor(this,hsv);};ColorPicker.prototype.setRgb=function(rgb){return setColor(this,rgb2hsv(rgb),rgb);};ColorPicker.prototype.setHex=function(hex){return setColor(this,ColorPicker.hex2hsv(hex),undefined,hex);};ColorPicker.positionIndicators=function(slideIndicator,pickerIndicator,mouseSlide,mousePicker){if(mouseSlide){slideIndicator.style.top=(mouseSlide.y-slideIndicator.offsetHeight/2)+'px';}\nif(mousePicker){pickerIndicator.style.top=(mousePicker.y-pickerIndicator.offsetHeight/2)+'px';pickerIndicator.style.left=(mousePicker.x-pickerIndicator.offsetWidth/2)+'px';}};window.ColorPicker=ColorPicker;})(window,window.document);"),
 	"static/dlibox.css":     []byte("#background{position:absolute;top:0;left:0;bottom:0;right:0;z-index:-1;overflow:hidden;color:#ddd;font-size:128px}.navbar{background:#fff;border-bottom:1px solid #eee;border-top:1px solid #eee;display:block;height:5rem;left:0;position:fixed;text-align:center;top:0;width:100%;z-index:99}.navbar ul{display:inline-block;list-style:none;margin-bottom:0}.navbar ul li{float:left;margin-bottom:0;position:relative}.navbar a{color:#222;font-size:11px;font-weight:600;letter-spacing:.2rem;line-height:5rem;margin-right:35px;text-decoration:none;text-transform:uppercase}.navbar a:hover{color:#33c3f0}.content{position:relative;top:5rem}#boutons button{width:80%}#boutons button img{height:5px;width:100%}#patternBox{font-family:monospace;height:auto;width:100%}.picker-wrapper,.slide-wrapper{float:left;position:relative}.picker-indicator,.slide-indicator{left:0;pointer-events:none;position:absolute;top:0}.picker,.slide{cursor:crosshair;float:left}.colorpicker{background-color:gray;border-radius:15px;box-shadow:0 0 40px #000;float:left;margin-bottom:3rem;margin-right:3rem;padding:12px}.colorpicker .picker{height:200px;width:200px}.colorpicker .slide{height:200px;width:30px}.colorpicker .slide-wrapper{margin-left:10px}.colorpicker .picker-indicator{background-color:#fff;border-radius:4px;border:2px solid #00008b;height:5px;opacity:.5;width:5px}.colorpicker .slide-indicator{background-color:#fff;border-radius:4px;border:4px solid #add8e6;height:10px;left:-4px;opacity:.6;width:100%}.colorRGB input{width:10rem}.colorRGB label,.colorRGB input{display:inline-block}#settingsBox{font-family:monospace;height:200rem;width:100%}button,input[type=submit],input[type=reset],input[type=button]{background-color:#ccc;border-color:#666;color:#fff}button:hover,input[type=submit]:hover,input[type=reset]:hover,input[type=button]:hover,button:focus,input[type=submit]:focus,input[type=reset]:focus,input[type=button]:focus{background-color:#888;border-color:#666;color:#fff}h2{padding-top:5rem;margin-top:-5rem}"),
-	"static/index.html":     []byte("<!doctype html><meta charset=utf-8><meta name=viewport content=\"width=device-width,initial-scale=1\"><meta name=apple-mobile-web-app-capable content=\"yes\"><meta name=apple-mobile-web-app-status-bar-style content=\"blue\"><meta name=apple-mobile-web-app-capable content=\"yes\"><meta name=mobile-web-app-capable content=\"yes\"><meta name=description content=\"dlibox web UI\"><meta name=author content=\"Marc-Antoine Ruel\"><title>dlibox</title><link rel=stylesheet href=/static/normalize.css><link rel=stylesheet href=/static/skeleton.css><link rel=stylesheet href=/static/dlibox.css><script src=/static/colorpicker.js></script><style>*{font-family:sans-serif;font-size:14px}h1{font-size:24px}h2{font-size:20px}h3{font-size:16px}h1,h2,h3{margin-bottom:.2em;margin-top:.2em}.err{background:#f44;border:1px solid #888;border-radius:10px;padding:10px;display:none}@media only screen and (max-width:500px){*{font-size:12px}}</style><script>\"use strict\";function log(v){}\nclass EventSource{constructor(){this._triggers={};}\naddEventListener(type,listener,options){if(!this._triggers[type]){this._triggers[type]=[];}\nlet opt=options||{};let v={capture:opt.capture,listener:listener,once:opt.once,passive:opt.passive,};this._triggers[type].push(v);}\nremoveEventListener(type,listener,options){if(!this._triggers[type]){return;}\nlet l=this._triggers[type].slice();let opt=options||{};for(let i=l.length;i>0;i--){let v=l[i-1];if(v.callback===callback&&v.capture===opt.capture&&v.passive===opt.passive){this._triggers[type].pop(i);}}}\ndispatchEvent(type,params){log(\"dispatchEvent(\"+type+\", \"+params+\")\");let l=this._triggers[type];if(!l){return;}\nlet rm=[];for(let i=0;i<l.length;i++){let opt=l[i];opt.listener.call(params);if(opt.once){rm.push(opt);}}\nfor(let i=0;i<rm.length;i++){for(let j=0;j<l.length;l++){if(l[j]===rm[i]){l.pop(j);break;}}}}}\nfunction postJSON(url,data,callback){function checkStatus(res){if(res.status==401){throw new Error(\"Please refresh the page\");}\nif(res.status>=200&&res.status<300){return res.json();}\nthrow new Error(res.statusText);}\nfunction onError(url,err){console.log(err);alertError(url+\": \"+err.toString());}\nlet hdr={body:JSON.stringify(data),credentials:\"same-origin\",headers:{\"Content-Type\":\"application/json; charset=utf-8\"},method:\"POST\",};fetch(url,hdr).then(checkStatus).then(callback).catch(err=>onError(url,err));}\nfunction alertError(errText){let e=document.getElementById(\"err\");if(e.innerText){e.innerText=e.innerText+\"\\n\";}\ne.innerText=e.innerText+errText+\"\\n\";e.style.display=\"block\";}\nvar Controller=new class{constructor(){this.patterns=[];this.settings=[];document.addEventListener(\"DOMContentLoaded\",()=>{this._fetchPatterns();this._fetchSettings();var text=\"\";for(var i=0;i<50;i++){text+=\"🐉🐢🐇🌴\";}\ndocument.getElementById(\"background\").innerText=text;},{once:true});}\nloadButtons(){var dst=document.getElementById(\"boutons\");dst.innerHTML=\"\";for(var k in this.patterns){var node=document.createElement(\"button\");var v=this.patterns[k];var i=parseInt(k);node.id=\"button-\"+i+1;node.attributes[\"data-mode\"]=v;node.innerHTML='<img src=\"/thumbnail/'+encodeURI(btoa(v))+'\" /> '+(i+1);node.addEventListener(\"click\",function(event){this.updatePattern(this.attributes[\"data-mode\"]);});dst.appendChild(node);dst.appendChild(document.createElement(\"br\"));}}\nupdatePattern(data){document.getElementById(\"patternBox\").value=data;this.setPattern();}\nupdateColor(r,g,b){var hex=\"#\"+componentToHex(r)+componentToHex(g)+componentToHex(b);document.body.style.backgroundColor=hex;document.getElementById(\"rgb_r\").value=r;document.getElementById(\"rgb_g\").value=g;document.getElementById(\"rgb_b\").value=b;document.getElementById(\"rgb\").value=hex;this.updatePattern('\"'+hex+'\"');}\nupdateFromHEX(){var hex=document.getElementById(\"rgb\").value;var result=/^#?([a-f\\d]{2})([a-f\\d]{2})([a-f\\d]{2})$/i.exec(hex);if(result){this.updateColor(parseInt(result[1],16),parseInt(result[2],16),parseInt(result[3],16));}}\nupdateFromRGB(){this.updateColor(parseInt(document.getElementById(\"rgb_r\").value,10),parseInt(document.getElementById(\"rgb_g\").value,10),parseInt(document.getElementById(\"rgb_b\").value,10));}\nsetState(state){postJSON(\"/api/dlibox/v1/publish\",state,res=>{});return false;}\nsetPattern(){try{document.getElementById(\"patternBox\").value=JSON.stringify(JSON.parse(document.getElementById(\"patternBox\").value),null,2);}catch(e){document.getElementById(\"patternError\").innerText=e;return;}\ndocument.getElementById(\"patternError\").innerText=\"\";postJSON(\"/api/dlibox/v1/pattern/get\",document.getElementById(\"patternBox\").value,res=>{fetchPatterns()});return false;}\nsetSettings(){try{document.getElementById(\"settingsBox\").value=JSON.stringify(JSON.parse(document.getElementById(\"settingsBox\").value),null,2);}catch(e){document.getElementById(\"settingsError\").innerText=e;return;}\ndocument.getElementById(\"settingsError\").innerText=\"\";postJSON(\"/api/dlibox/v1/settings/get\",document.getElementById(\"settingsBox\").value,res=>{document.getElementById(\"settingsBox\").value=JSON.stringify(res,null,2);fetchPatterns();});return false;}\n_fetchPatterns(){postJSON(\"/api/dlibox/v1/pattern/list\",{},res=>{this.patterns=res;this.loadButtons();});}\n_fetchSettings(){postJSON(\"/api/dlibox/v1/settings/get\",{},res=>{this.settings=res;document.getElementById(\"settingsBox\").value=JSON.stringify(res,null,2);});}};function componentToHex(c){let hex=c.toString(16);return hex.length==1?\"0\"+hex:hex;}\nfunction patternKeyDown(){if(event.keyCode==13){Controller.setPattern();}\nreturn false;}\nclass HTMLElementTemplate extends HTMLElement{constructor(template_name){super();let tmpl=document.querySelector(\"template#\"+template_name);this.attachShadow({mode:\"open\"}).appendChild(tmpl.content.cloneNode(true));}\nstatic get observedAttributes(){return[];}\nemitEvent(name,detail){this.dispatchEvent(new CustomEvent(name,{detail,bubbles:true}));}}</script><template id=template-data-table-elem><style>th{background-color:#4caf50;color:#fff}th,td{padding:.5rem;border-bottom:1px solid #ddd}tr:hover{background-color:#ccc}tr:nth-child(even):not(:hover){background:#f5f5f5}.inline{display:inline-block;margin-bottom:1rem;margin-right:2rem;vertical-align:top}</style><div class=inline><table><thead><tbody></table></div></template><script>\"use strict\";(function(){window.customElements.define(\"data-table-elem\",class extends HTMLElementTemplate{constructor(){super(\"template-data-table-elem\");}\nsetupTable(hdr){let root=this.shadowRoot.querySelector(\"thead\");for(let i=0;i<hdr.length;i++){root.appendChild(document.createElement(\"th\")).innerText=hdr[i];}}\nappendRow(line){let tr=this.shadowRoot.querySelector(\"tbody\").appendChild(document.createElement(\"tr\"));let items=[];for(let i=0;i<line.length;i++){let cell=tr.appendChild(document.createElement(\"td\"));if(line[i]instanceof Element){cell.appendChild(line[i]);items[i]=line[i];}else{cell.innerText=line[i];items[i]=cell;}}\nreturn items;}});}());</script><template id=template-checkout-elem><style>@keyframes popIn{0%{transform:scale(1,1)}25%{transform:scale(1.2,1)}50%{transform:scale(1.4,1)}100%{transform:scale(1,1)}}@keyframes popOut{0%{transform:scale(1,1)}25%{transform:scale(1.2,1)}50%{transform:scale(1.4,1)}100%{transform:scale(1,1)}}div{display:inline-block;height:20px;position:relative;vertical-align:bottom}input{bottom:0;cursor:pointer;display:block;height:0%;left:0;margin:0;opacity:0;position:absolute;right:0;top:0;width:0%}span{cursor:pointer;margin-left:.25em;padding-left:40px;user-select:none}span:before{background:rgba(100,100,100,.2);border-radius:20px;box-shadow:inset 0 0 5px rgba(0,0,0,.8);content:\"\";display:inline-block;height:20px;left:0;position:absolute;transition:background .2s ease-out;width:40px}span:after{background-clip:padding-box;background:#fff;border-radius:20px;border:solid green 2px;content:\"\";display:block;font-weight:700;height:20px;left:-2px;position:absolute;text-align:center;top:-2px;transition:margin-left .1s ease-in-out;width:20px}input:checked+span:after{margin-left:20px}input:checked+span:before{transition:background .2s ease-in}input:not(:checked)+span:after{animation:popOut ease-in .3s normal}input:checked+span:after{animation:popIn ease-in .3s normal;background-clip:padding-box;margin-left:20px}input:checked+span:before{background:#20c997}input:disabled+span:before{box-shadow:0 0 black}input:disabled+span{color:#adb5bd}input:disabled:checked+span:before{background:#adb5bd}input:indeterminate+span:after{margin-left:10px}input:focus+span:before{outline:solid #cce5ff 2px}</style><div><label><input type=checkbox><span><slot></slot></span></label></div></template><script>\"use strict\";(function(){window.customElements.define(\"checkout-elem\",class extends HTMLElementTemplate{constructor(){super(\"template-checkout-elem\");}\nconnectedCallback(){this.contentElem=this.shadowRoot.querySelector(\"span\");this.checkboxElem=this.shadowRoot.querySelector(\"input\");this.checkboxElem.addEventListener(\"click\",e=>{this.emitEvent(\"change\",{});},{passive:true});}\n_setClearVal(obj,name,v){if(v!==false&&v!==true){alert(\"internal error\");}\nobj[name]=v;}\nget checked(){return this.checkboxElem.checked;}\nset checked(v){this._setClearVal(this.checkboxElem,\"checked\",v);}\nget disabled(){return this.checkboxElem.disabled;}\nset disabled(v){this._setClearVal(this.checkboxElem,\"disabled\",v);}\nget indeterminate(){return this.checkboxElem.indeterminate;}\nset indeterminate(v){this._setClearVal(this.checkboxElem,\"indeterminate\",v);}\nget text(){return this.contentElem.innerText;}\nset text(v){this.contentElem.innerText=v;}});}());</script><template id=template-header-view><data-table-elem></data-table-elem></template><script>\"use strict\";(function(){window.customElements.define(\"header-view\",class extends HTMLElementTemplate{constructor(){super(\"template-header-view\");}\nsetupHeader(name){this.header=Controller.headers[name];let data=this.shadowRoot.querySelector(\"data-table-elem\");let cols=1;if(this.header.pins){cols=this.header.pins[0].length;}\nlet hdr=[this.header.name];for(let i=1;i<cols;i++){hdr[i]=\"\";}\ndata.setupTable(hdr);for(let y=0;y<this.header.pins.length;y++){let row=this.header.pins[y];let items=[];for(let x=0;x<row.length;x++){items[x]=document.createElement(\"gpio-view\");}\nitems=data.appendRow(items);for(let x=0;x<items.length;x++){items[x].setupPin(row[x]);}}}});}());</script><div class=err id=err></div><div id=background></div><div class=navbar><ul><li><a href=#patterns>Patterns</a><li><a href=#color>Color</a><li><a href=#configuration>Configuration</a></ul></div><div class=\"container content\"><div class=row></div><div class=row><button onclick=\"setState('idle')\">Idle</button>\n<button onclick=\"setState('incoming')\">Incoming</button>\n<button onclick=\"setState('porch')\">Porch</button></div><div class=row><h2 id=patterns>Choix d'animations</h2></div><div class=row><div id=boutons></div></div><div class=row><h2>Custom</h2><textarea id=patternBox name=pattern rows=10></textarea><br><button onclick=Controller.setPattern()>Set</button><br><div id=patternError><br></div><div class=row><h2 id=color>Manual Color</h2><div class=\"eight columns\"><div class=colorpicker><div id=picker-wrapper class=picker-wrapper><div id=picker class=picker></div><div id=picker-indicator class=picker-indicator></div></div><div id=slide-wrapper class=slide-wrapper><div id=slide class=slide></div><div id=slide-indicator class=slide-indicator></div></div></div></div><div class=\"three columns\"><div class=colorRGB><div><label>RGB</label>\n<input id=rgb value=#FFFFFF onchange=updateFromHEX()></div><div><label>R:</label>\n<input id=rgb_r type=number value=255 onchange=updateFromRGB()></div><div><label>G:</label>\n<input id=rgb_g type=number value=255 onchange=updateFromRGB()></div><div><label>B:</label>\n<input id=rgb_b type=number value=255 onchange=updateFromRGB()></div></div></div></div><div class=row><h2 id=configuration>Configuration</h2><textarea id=settingsBox name=settings rows=10></textarea><br><button onclick=Controller.setSettings()>Set</button><br><div id=settingsError><br></div></div>"),
+	"static/index.html":     []byte("<!doctype html><meta charset=utf-8><meta name=viewport content=\"width=device-width,initial-scale=1\"><meta name=apple-mobile-web-app-capable content=\"yes\"><meta name=apple-mobile-web-app-status-bar-style content=\"blue\"><meta name=apple-mobile-web-app-capable content=\"yes\"><meta name=mobile-web-app-capable content=\"yes\"><meta name=description content=\"dlibox web UI\"><meta name=author content=\"Marc-Antoine Ruel\"><title>dlibox</title><link rel=stylesheet href=/static/normalize.css><link rel=stylesheet href=/static/skeleton.css><link rel=stylesheet href=/static/dlibox.css><script src=/static/colorpicker.js></script><style>*{font-family:sans-serif;font-size:14px}h1{font-size:24px}h2{font-size:20px}h3{font-size:16px}h1,h2,h3{margin-bottom:.2em;margin-top:.2em}.err{background:#f44;border:1px solid #888;border-radius:10px;padding:10px;display:none}@media only screen and (max-width:500px){*{font-size:12px}}</style><script>\"use strict\";function log(v){}\nclass EventSource{constructor(){this._triggers={};}\naddEventListener(type,listener,options){if(!this._triggers[type]){this._triggers[type]=[];}\nlet opt=options||{};let v={capture:opt.capture,listener:listener,once:opt.once,passive:opt.passive,};this._triggers[type].push(v);}\nremoveEventListener(type,listener,options){if(!this._triggers[type]){return;}\nlet l=this._triggers[type].slice();let opt=options||{};for(let i=l.length;i>0;i--){let v=l[i-1];if(v.callback===callback&&v.capture===opt.capture&&v.passive===opt.passive){this._triggers[type].pop(i);}}}\ndispatchEvent(type,params){log(\"dispatchEvent(\"+type+\", \"+params+\")\");let l=this._triggers[type];if(!l){return;}\nlet rm=[];for(let i=0;i<l.length;i++){let opt=l[i];opt.listener.call(params);if(opt.once){rm.push(opt);}}\nfor(let i=0;i<rm.length;i++){for(let j=0;j<l.length;l++){if(l[j]===rm[i]){l.pop(j);break;}}}}}\nfunction postJSON(url,data,callback){function checkStatus(res){if(res.status==401){throw new Error(\"Please refresh the page\");}\nif(res.status>=200&&res.status<300){return res.json();}\nthrow new Error(res.statusText);}\nfunction onError(url,err){console.log(err);alertError(url+\": \"+err.toString());}\nlet hdr={body:JSON.stringify(data),credentials:\"same-origin\",headers:{\"Content-Type\":\"application/json; charset=utf-8\"},method:\"POST\",};fetch(url,hdr).then(checkStatus).then(callback).catch(err=>onError(url,err));}\nfunction alertError(errText){let e=document.getElementById(\"err\");if(e.innerText){e.innerText=e.innerText+\"\\n\";}\ne.innerText=e.innerText+errText+\"\\n\";e.style.display=\"block\";}\nvar Controller=new class{constructor(){this.patterns=[];this.settings=[];document.addEventListener(\"DOMContentLoaded\",()=>{this._fetchPatterns();this._fetchSettings();var text=\"\";for(var i=0;i<50;i++){text+=\"🐉🐢🐇🌴\";}\ndocument.getElementById(\"background\").innerText=text;},{once:true});}\nloadButtons(){var dst=document.getElementById(\"boutons\");dst.innerHTML=\"\";for(var k in this.patterns){var node=document.createElement(\"button\");var v=this.patterns[k];var i=parseInt(k);node.id=\"button-\"+i+1;node.attributes[\"data-mode\"]=v;node.innerHTML='<img src=\"/thumbnail/'+encodeURI(btoa(v))+'\" /> '+(i+1);node.addEventListener(\"click\",function(event){this.updatePattern(this.attributes[\"data-mode\"]);});dst.appendChild(node);dst.appendChild(document.createElement(\"br\"));}}\nupdatePattern(data){document.getElementById(\"patternBox\").value=data;this.setPattern();}\nupdateColor(r,g,b){var hex=\"#\"+componentToHex(r)+componentToHex(g)+componentToHex(b);document.body.style.backgroundColor=hex;document.getElementById(\"rgb_r\").value=r;document.getElementById(\"rgb_g\").value=g;document.getElementById(\"rgb_b\").value=b;document.getElementById(\"rgb\").value=hex;this.updatePattern('\"'+hex+'\"');}\nupdateFromHEX(){var hex=document.getElementById(\"rgb\").value;var result=/^#?([a-f\\d]{2})([a-f\\d]{2})([a-f\\d]{2})$/i.exec(hex);if(result){this.updateColor(parseInt(result[1],16),parseInt(result[2],16),parseInt(result[3],16));}}\nupdateFromRGB(){this.updateColor(parseInt(document.getElementById(\"rgb_r\").value,10),parseInt(document.getElementById(\"rgb_g\").value,10),parseInt(document.getElementById(\"rgb_b\").value,10));}\nsetState(state){postJSON(\"/api/dlibox/v1/fsm/set\",{Name:\"halloween\",State:state},res=>{});return false;}\nsetPattern(){try{document.getElementById(\"patternBox\").value=JSON.stringify(JSON.parse(document.getElementById(\"patternBox\").value),null,2);}catch(e){document.getElementById(\"patternError\").innerText=e;return;}\ndocument.getElementById(\"patternError\").innerText=\"\";postJSON(\"/api/dlibox/v1/pattern/get\",document.getElementById(\"patternBox\").value,res=>{fetchPatterns()});return false;}\nsetSettings(){try{document.getElementById(\"settingsBox\").value=JSON.stringify(JSON.parse(document.getElementById(\"settingsBox\").value),null,2);}catch(e){document.getElementById(\"settingsError\").innerText=e;return;}\ndocument.getElementById(\"settingsError\").innerText=\"\";postJSON(\"/api/dlibox/v1/settings/get\",document.getElementById(\"settingsBox\").value,res=>{document.getElementById(\"settingsBox\").value=JSON.stringify(res,null,2);fetchPatterns();});return false;}\n_fetchPatterns(){postJSON(\"/api/dlibox/v1/pattern/list\",{},res=>{this.patterns=res;this.loadButtons();});}\n_fetchSettings(){postJSON(\"/api/dlibox/v1/settings/get\",{},res=>{this.settings=res;document.getElementById(\"settingsBox\").value=JSON.stringify(res,null,2);});}};function componentToHex(c){let hex=c.toString(16);return hex.length==1?\"0\"+hex:hex;}\nfunction patternKeyDown(){if(event.keyCode==13){Controller.setPattern();}\nreturn false;}\nclass HTMLElementTemplate extends HTMLElement{constructor(template_name){super();let tmpl=document.querySelector(\"template#\"+template_name);this.attachShadow({mode:\"open\"}).appendChild(tmpl.content.cloneNode(true));}\nstatic get observedAttributes(){return[];}\nemitEvent(name,detail){this.dispatchEvent(new CustomEvent(name,{detail,bubbles:true}));}}</script><template id=template-data-table-elem><style>th{background-color:#4caf50;color:#fff}th,td{padding:.5rem;border-bottom:1px solid #ddd}tr:hover{background-color:#ccc}tr:nth-child(even):not(:hover){background:#f5f5f5}.inline{display:inline-block;margin-bottom:1rem;margin-right:2rem;vertical-align:top}</style><div class=inline><table><thead><tbody></table></div></template><script>\"use strict\";(function(){window.customElements.define(\"data-table-elem\",class extends HTMLElementTemplate{constructor(){super(\"template-data-table-elem\");}\nsetupTable(hdr){let root=this.shadowRoot.querySelector(\"thead\");for(let i=0;i<hdr.length;i++){root.appendChild(document.createElement(\"th\")).innerText=hdr[i];}}\nappendRow(line){let tr=this.shadowRoot.querySelector(\"tbody\").appendChild(document.createElement(\"tr\"));let items=[];for(let i=0;i<line.length;i++){let cell=tr.appendChild(document.createElement(\"td\"));if(line[i]instanceof Element){cell.appendChild(line[i]);items[i]=line[i];}else{cell.innerText=line[i];items[i]=cell;}}\nreturn items;}});}());</script><template id=template-checkout-elem><style>@keyframes popIn{0%{transform:scale(1,1)}25%{transform:scale(1.2,1)}50%{transform:scale(1.4,1)}100%{transform:scale(1,1)}}@keyframes popOut{0%{transform:scale(1,1)}25%{transform:scale(1.2,1)}50%{transform:scale(1.4,1)}100%{transform:scale(1,1)}}div{display:inline-block;height:20px;position:relative;vertical-align:bottom}input{bottom:0;cursor:pointer;display:block;height:0%;left:0;margin:0;opacity:0;position:absolute;right:0;top:0;width:0%}span{cursor:pointer;margin-left:.25em;padding-left:40px;user-select:none}span:before{background:rgba(100,100,100,.2);border-radius:20px;box-shadow:inset 0 0 5px rgba(0,0,0,.8);content:\"\";display:inline-block;height:20px;left:0;position:absolute;transition:background .2s ease-out;width:40px}span:after{background-clip:padding-box;background:#fff;border-radius:20px;border:solid green 2px;content:\"\";display:block;font-weight:700;height:20px;left:-2px;position:absolute;text-align:center;top:-2px;transition:margin-left .1s ease-in-out;width:20px}input:checked+span:after{margin-left:20px}input:checked+span:before{transition:background .2s ease-in}input:not(:checked)+span:after{animation:popOut ease-in .3s normal}input:checked+span:after{animation:popIn ease-in .3s normal;background-clip:padding-box;margin-left:20px}input:checked+span:before{background:#20c997}input:disabled+span:before{box-shadow:0 0 black}input:disabled+span{color:#adb5bd}input:disabled:checked+span:before{background:#adb5bd}input:indeterminate+span:after{margin-left:10px}input:focus+span:before{outline:solid #cce5ff 2px}</style><div><label><input type=checkbox><span><slot></slot></span></label></div></template><script>\"use strict\";(function(){window.customElements.define(\"checkout-elem\",class extends HTMLElementTemplate{constructor(){super(\"template-checkout-elem\");}\nconnectedCallback(){this.contentElem=this.shadowRoot.querySelector(\"span\");this.checkboxElem=this.shadowRoot.querySelector(\"input\");this.checkboxElem.addEventListener(\"click\",e=>{this.emitEvent(\"change\",{});},{passive:true});}\n_setClearVal(obj,name,v){if(v!==false&&v!==true){alert(\"internal error\");}\nobj[name]=v;}\nget checked(){return this.checkboxElem.checked;}\nset checked(v){this._setClearVal(this.checkboxElem,\"checked\",v);}\nget disabled(){return this.checkboxElem.disabled;}\nset disabled(v){this._setClearVal(this.checkboxElem,\"disabled\",v);}\nget indeterminate(){return this.checkboxElem.indeterminate;}\nset indeterminate(v){this._setClearVal(this.checkboxElem,\"indeterminate\",v);}\nget text(){return this.contentElem.innerText;}\nset text(v){this.contentElem.innerText=v;}});}());</script><template id=template-header-view><data-table-elem></data-table-elem></template><script>\"use strict\";(function(){window.customElements.define(\"header-view\",class extends HTMLElementTemplate{constructor(){super(\"template-header-view\");}\nsetupHeader(name){this.header=Controller.headers[name];let data=this.shadowRoot.querySelector(\"data-table-elem\");let cols=1;if(this.header.pins){cols=this.header.pins[0].length;}\nlet hdr=[this.header.name];for(let i=1;i<cols;i++){hdr[i]=\"\";}\ndata.setupTable(hdr);for(let y=0;y<this.header.pins.length;y++){let row=this.header.pins[y];let items=[];for(let x=0;x<row.length;x++){items[x]=document.createElement(\"gpio-view\");}\nitems=data.appendRow(items);for(let x=0;x<items.length;x++){items[x].setupPin(row[x]);}}}});}());</script><div class=err id=err></div><div id=background></div><div class=navbar><ul><li><a href=#patterns>Patterns</a><li><a href=#color>Color</a><li><a href=#configuration>Configuration</a></ul></div><div class=\"container content\"><div class=row></div><div class=row><button onclick=\"setState('idle')\">Idle</button>\n<button onclick=\"setState('incoming')\">Incoming</button>\n<button onclick=\"setState('porch')\">Porch</button></div><div class=row><h2 id=patterns>Choix d'animations</h2></div><div class=row><div id=boutons></div></div><div class=row><h2>Custom</h2><textarea id=patternBox name=pattern rows=10></textarea><br><button onclick=Controller.setPattern()>Set</button><br><div id=patternError><br></div><div class=row><h2 id=color>Manual Color</h2><div class=\"eight columns\"><div class=colorpicker><div id=picker-wrapper class=picker-wrapper><div id=picker class=picker></div><div id=picker-indicator class=picker-indicator></div></div><div id=slide-wrapper class=slide-wrapper><div id=slide class=slide></div><div id=slide-indicator class=slide-indicator></div></div></div></div><div class=\"three columns\"><div class=colorRGB><div><label>RGB</label>\n<input id=rgb value=#FFFFFF onchange=updateFromHEX()></div><div><label>R:</label>\n<input id=rgb_r type=number value=255 onchange=updateFromRGB()></div><div><label>G:</label>\n<input id=rgb_g type=number value=255 onchange=updateFromRGB()></div><div><label>B:</label>\n<input id=rgb_b type=number value=255 onchange=updateFromRGB()></div></div></div></div><div class=row><h2 id=configuration>Configuration</h2><textarea id=settingsBox name=settings rows=10></textarea><br><button onclick=Controller.setSettings()>Set</button><br><div id=settingsError><br></div></div>"),
 	"static/normalize.css":  []byte("/*!normalize.css v3.0.2 | MIT License | git.io/normalize*/html{font-family:sans-serif;-ms-text-size-adjust:100%;-webkit-text-size-adjust:100%}body{margin:0}article,aside,details,figcaption,figure,footer,header,hgroup,main,menu,nav,section,summary{display:block}audio,canvas,progress,video{display:inline-block;vertical-align:baseline}audio:not([controls]){display:none;height:0}[hidden],template{display:none}a{background-color:initial}a:active,a:hover{outline:0}abbr[title]{border-bottom:1px dotted}b,strong{font-weight:700}dfn{font-style:italic}h1{font-size:2em;margin:.67em 0}mark{background:#ff0;color:#000}small{font-size:80%}sub,sup{font-size:75%;line-height:0;position:relative;vertical-align:baseline}sup{top:-.5em}sub{bottom:-.25em}img{border:0}svg:not(:root){overflow:hidden}figure{margin:1em 40px}hr{-moz-box-sizing:content-box;box-sizing:content-box;height:0}pre{overflow:auto}code,kbd,pre,samp{font-family:monospace,monospace;font-size:1em}button,input,optgroup,select,textarea{color:inherit;font:inherit;margin:0}button{overflow:visible}button,select{text-transform:none}button,html input[type=button],input[type=reset],input[type=submit]{-webkit-appearance:button;cursor:pointer}button[disabled],html input[disabled]{cursor:default}button::-moz-focus-inner,input::-moz-focus-inner{border:0;padding:0}input{line-height:normal}input[type=checkbox],input[type=radio]{box-sizing:border-box;padding:0}input[type=number]::-webkit-inner-spin-button,input[type=number]::-webkit-outer-spin-button{height:auto}input[type=search]{-webkit-appearance:textfield;-moz-box-sizing:content-box;-webkit-box-sizing:content-box;box-sizing:content-box}input[type=search]::-webkit-search-cancel-button,input[type=search]::-webkit-search-decoration{-webkit-appearance:none}fieldset{border:1px solid silver;margin:0 2px;padding:.35em .625em .75em}legend{border:0;padding:0}textarea{overflow:auto}optgroup{font-weight:700}table{border-collapse:collapse;border-spacing:0}td,th{padding:0}"),
 	"static/skeleton.css":   []byte("/*!normalize.css v3.0.2 | MIT License | git.io/normalize*/img,legend{border:0}legend,td,th{padding:0}html{font-family:sans-serif;-ms-text-size-adjust:100%;-webkit-text-size-adjust:100%}body{margin:0}article,aside,details,figcaption,figure,footer,header,hgroup,main,menu,nav,section,summary{display:block}audio,canvas,progress,video{display:inline-block;vertical-align:baseline}audio:not([controls]){display:none;height:0}[hidden],template{display:none}a{background-color:initial}a:active,a:hover{outline:0}abbr[title]{border-bottom:1px dotted}b,optgroup,strong{font-weight:700}dfn{font-style:italic}h1{font-size:2em;margin:.67em 0}mark{background:#ff0;color:#000}small{font-size:80%}sub,sup{font-size:75%;line-height:0;position:relative;vertical-align:baseline}sup{top:-.5em}sub{bottom:-.25em}svg:not(:root){overflow:hidden}figure{margin:1em 40px}hr{-moz-box-sizing:content-box;box-sizing:content-box;height:0}pre,textarea{overflow:auto}code,kbd,pre,samp{font-family:monospace,monospace;font-size:1em}button,input,optgroup,select,textarea{color:inherit;font:inherit;margin:0}button{overflow:visible}button,select{text-transform:none}button,html input[type=button],input[type=reset],input[type=submit]{-webkit-appearance:button;cursor:pointer}button[disabled],html input[disabled]{cursor:default}button::-moz-focus-inner,input::-moz-focus-inner{border:0;padding:0}input{line-height:normal}input[type=checkbox],input[type=radio]{box-sizing:border-box;padding:0}input[type=number]::-webkit-inner-spin-button,input[type=number]::-webkit-outer-spin-button{height:auto}input[type=search]{-webkit-appearance:textfield;-moz-box-sizing:content-box;-webkit-box-sizing:content-box;box-sizing:content-box}input[type=search]::-webkit-search-cancel-button,input[type=search]::-webkit-search-decoration{-webkit-appearance:none}fieldset{border:1px solid silver;margin:0 2px;padding:.35em .625em .75em}table{border-collapse:collapse;border-spacing:0}.column,.columns,.container,.u-full-width{width:100%;box-sizing:border-box}h1,h2,h3{letter-spacing:-.1rem}body,h6{line-height:1.6}.container{position:relative;max-width:960px;margin:0 auto;padding:0 20px}ol,p,ul{margin-top:0}.column,.columns{float:left}@media(min-width:400px){.container{width:85%;padding:0}}html{font-size:62.5%}body{font-size:1.5em;font-weight:400;font-family:Raleway,HelveticaNeue,helvetica neue,Helvetica,Arial,sans-serif;color:#222}h1,h2,h3,h4,h5,h6{margin-top:0;margin-bottom:2rem;font-weight:300}h1{font-size:4rem;line-height:1.2}h2{font-size:3.6rem;line-height:1.25}h3{font-size:3rem;line-height:1.3}h4{font-size:2.4rem;line-height:1.35;letter-spacing:-.08rem}h5{font-size:1.8rem;line-height:1.5;letter-spacing:-.05rem}h6{font-size:1.5rem;letter-spacing:0}@media(min-width:550px){.container{width:80%}.column,.columns{margin-left:4%}.column:first-child,.columns:first-child{margin-left:0}.one.column,.one.columns{width:4.66666666667%}.two.columns{width:13.3333333333%}.three.columns{width:22%}.four.columns{width:30.6666666667%}.five.columns{width:39.3333333333%}.six.columns{width:48%}.seven.columns{width:56.6666666667%}.eight.columns{width:65.3333333333%}.nine.columns{width:74%}.ten.columns{width:82.6666666667%}.eleven.columns{width:91.3333333333%}.twelve.columns{width:100%;margin-left:0}.one-third.column{width:30.6666666667%}.two-thirds.column{width:65.3333333333%}.one-half.column{width:48%}.offset-by-one.column,.offset-by-one.columns{margin-left:8.66666666667%}.offset-by-two.column,.offset-by-two.columns{margin-left:17.3333333333%}.offset-by-three.column,.offset-by-three.columns{margin-left:26%}.offset-by-four.column,.offset-by-four.columns{margin-left:34.6666666667%}.offset-by-five.column,.offset-by-five.columns{margin-left:43.3333333333%}.offset-by-six.column,.offset-by-six.columns{margin-left:52%}.offset-by-seven.column,.offset-by-seven.columns{margin-left:60.6666666667%}.offset-by-eight.column,.offset-by-eight.columns{margin-left:69.3333333333%}.offset-by-nine.column,.offset-by-nine.columns{margin-left:78%}.offset-by-ten.column,.offset-by-ten.columns{margin-left:86.6666666667%}.offset-by-eleven.column,.offset-by-eleven.columns{margin-left:95.3333333333%}.offset-by-one-third.column,.offset-by-one-third.columns{margin-left:34.6666666667%}.offset-by-two-thirds.column,.offset-by-two-thirds.columns{margin-left:69.3333333333%}.offset-by-one-half.column,.offset-by-one-half.columns{margin-left:52%}h1{font-size:5rem}h2{font-size:4.2rem}h3{font-size:3.6rem}h4{font-size:3rem}h5{font-size:2.4rem}h6{font-size:1.5rem}}a{color:#1eaedb}a:hover{color:#0fa0ce}.button,button,input[type=submit],input[type=reset],input[type=button]{display:inline-block;height:38px;padding:0 30px;color:#555;text-align:center;font-size:11px;font-weight:600;line-height:38px;letter-spacing:.1rem;text-transform:uppercase;text-decoration:none;white-space:nowrap;background-color:initial;border-radius:4px;border:1px solid #bbb;cursor:pointer;box-sizing:border-box}.button:focus,.button:hover,button:focus,button:hover,input[type=submit]:focus,input[type=submit]:hover,input[type=reset]:focus,input[type=reset]:hover,input[type=button]:focus,input[type=button]:hover{color:#333;border-color:#888;outline:0}.button.button-primary,button.button-primary,input[type=submit].button-primary,input[type=reset].button-primary,input[type=button].button-primary{color:#fff;background-color:#33c3f0;border-color:#33c3f0}.button.button-primary:focus,.button.button-primary:hover,button.button-primary:focus,button.button-primary:hover,input[type=submit].button-primary:focus,input[type=submit].button-primary:hover,input[type=reset].button-primary:focus,input[type=reset].button-primary:hover,input[type=button].button-primary:focus,input[type=button].button-primary:hover{color:#fff;background-color:#1eaedb;border-color:#1eaedb}input[type=tel],input[type=url],input[type=password],input[type=email],input[type=number],input[type=search],input[type=text],select,textarea{height:38px;padding:6px 10px;background-color:#fff;border:1px solid #d1d1d1;border-radius:4px;box-shadow:0 0;box-sizing:border-box}input[type=tel],input[type=url],input[type=password],input[type=email],input[type=number],input[type=search],input[type=text],textarea{-webkit-appearance:none;-moz-appearance:none;appearance:none}textarea{min-height:65px;padding-top:6px;padding-bottom:6px}input[type=tel]:focus,input[type=url]:focus,input[type=password]:focus,input[type=email]:focus,input[type=number]:focus,input[type=search]:focus,input[type=text]:focus,select:focus,textarea:focus{border:1px solid #33c3f0;outline:0}label,legend{display:block;margin-bottom:.5rem;font-weight:600}fieldset{padding:0;border-width:0}input[type=checkbox],input[type=radio]{display:inline}label>.label-body{display:inline-block;margin-left:.5rem;font-weight:400}ul{list-style:circle inside}ol{list-style:decimal inside}ol,ul{padding-left:0}ol ol,ol ul,ul ol,ul ul{margin:1.5rem 0 1.5rem 3rem;font-size:90%}.button,button,li{margin-bottom:1rem}code{padding:.2rem .5rem;margin:0 .2rem;font-size:90%;white-space:nowrap;background:#f1f1f1;border:1px solid #e1e1e1;border-radius:4px}pre>code{display:block;padding:1rem 1.5rem;white-space:pre}td,th{padding:12px 15px;text-align:left;border-bottom:1px solid #e1e1e1}td:first-child,th:first-child{padding-left:0}td:last-child,th:last-child{padding-right:0}fieldset,input,select,textarea{margin-bottom:1.5rem}blockquote,dl,figure,form,ol,p,pre,table,ul{margin-bottom:2.5rem}.u-max-full-width{max-width:100%;box-sizing:border-box}.u-pull-right{float:right}.u-pull-left{float:left}hr{margin-top:3rem;margin-bottom:3.5rem;border-width:0;border-top:1px solid #e1e1e1}.container:after,.row:after,.u-cf{content:\"\";display:table;clear:both}"),
 	"static/themes.css":     []byte(".picker-wrapper,.slide-wrapper{position:relative;float:left}.picker-indicator,.slide-indicator{position:absolute;left:0;top:0;pointer-events:none}.picker,.slide{cursor:crosshair;float:left}.cp-default{background-color:gray;padding:12px;box-shadow:0 0 40px #000;border-radius:15px;float:left}.cp-default .picker{width:200px;height:200px}.cp-default .slide{width:30px;height:200px}.cp-default .slide-wrapper{margin-left:10px}.cp-default .picker-indicator{width:5px;height:5px;border:2px solid #00008b;-moz-border-radius:4px;-o-border-radius:4px;-webkit-border-radius:4px;border-radius:4px;opacity:.5;-ms-filter:\"alpha(opacity=50)\";filter:alpha(opacity=50);filter:alpha(opacity=50);background-color:#fff}.cp-default .slide-indicator{width:100%;height:10px;left:-4px;opacity:.6;-ms-filter:\"alpha(opacity=60)\";filter:alpha(opacity=60);filter:alpha(opacity=60);border:4px solid #add8e6;-moz-border-radius:4px;-o-border-radius:4px;-webkit-border-radius:4px;border-radius:4px;background-color:#fff}.cp-small{padding:5px;background-color:#fff;float:left;border-radius:5px}.cp-small .picker{width:100px;height:100px}.cp-small .slide{width:15px;height:100px}.cp-small .slide-wrapper{margin-left:5px}.cp-small .picker-indicator{width:1px;height:1px;border:1px solid #000;background-color:#fff}.cp-small .slide-indicator{width:100%;height:2px;left:0;background-color:#000}.cp-fancy{padding:10px;background:-webkit-linear-gradient(top,#aaa 0%,#222 100%);float:left;border:1px solid #999;box-shadow:inset 0 0 10px white}.cp-fancy .picker{width:200px;height:200px}.cp-fancy .slide{width:30px;height:200px}.cp-fancy .slide-wrapper{margin-left:10px}.cp-fancy .picker-indicator{width:24px;height:24px;background-image:url(http://cdn1.iconfinder.com/data/icons/fugue/bonus/icons-24/target.png)}.cp-fancy .slide-indicator{width:30px;height:31px;left:30px;background-image:url(http://cdn1.iconfinder.com/data/icons/bluecoral/Left.png)}.cp-normal{padding:10px;background-color:#fff;float:left;border:4px solid #d6d6d6;box-shadow:inset 0 0 10px white}.cp-normal .picker{width:200px;height:200px}.cp-normal .slide{width:30px;height:200px}.cp-normal .slide-wrapper{margin-left:10px}.cp-normal .picker-indicator{width:5px;height:5px;border:1px solid gray;opacity:.5;-ms-filter:\"alpha(opacity=50)\";filter:alpha(opacity=50);filter:alpha(opacity=50);background-color:#fff;pointer-events:none}.cp-normal .slide-indicator{width:100%;height:10px;left:-4px;opacity:.6;-ms-filter:\"alpha(opacity=60)\";filter:alpha(opacity=60);filter:alpha(opacity=60);border:4px solid gray;background-color:#fff;pointer-events:none}"),
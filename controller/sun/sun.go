@@ -0,0 +1,158 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package sun calculates the time of solar events like sunrise and sunset.
+//
+// The calculation is done offline with the sunrise equation, which is accurate
+// to about a minute at latitudes below the polar circles.
+// https://en.wikipedia.org/wiki/Sunrise_equation
+package sun
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Position is a position on Earth.
+type Position struct {
+	Latitude  float64 // Degrees, positive north.
+	Longitude float64 // Degrees, positive east.
+}
+
+// Validate confirms the position is valid.
+func (p *Position) Validate() error {
+	if p.Latitude < -90 || p.Latitude > 90 {
+		return errors.New("invalid latitude")
+	}
+	if p.Longitude < -180 || p.Longitude > 180 {
+		return errors.New("invalid longitude")
+	}
+	return nil
+}
+
+func (p *Position) String() string {
+	return fmt.Sprintf("%.4f,%.4f", p.Latitude, p.Longitude)
+}
+
+// Event is a daily solar event.
+type Event string
+
+// Known solar events.
+const (
+	AstronomicalDawn Event = "astronomical-dawn"
+	NauticalDawn     Event = "nautical-dawn"
+	CivilDawn        Event = "civil-dawn"
+	Sunrise          Event = "sunrise"
+	Noon             Event = "noon"
+	Sunset           Event = "sunset"
+	CivilDusk        Event = "civil-dusk"
+	NauticalDusk     Event = "nautical-dusk"
+	AstronomicalDusk Event = "astronomical-dusk"
+)
+
+// Validate confirms the event is a known value.
+func (e Event) Validate() error {
+	if _, ok := elevations[e]; !ok && e != Noon {
+		return fmt.Errorf("unknown solar event %q", e)
+	}
+	return nil
+}
+
+// On returns the time of the event on the calendar day year/month/day in
+// location loc.
+//
+// Returns false if the event doesn't happen on this day, which happens near
+// the poles.
+func (e Event) On(p *Position, year int, month time.Month, day int, loc *time.Location) (time.Time, bool) {
+	date := time.Date(year, month, day, 12, 0, 0, 0, loc)
+	// The calculation is done on the UTC day, which may be off by one from the
+	// local calendar day for locations far from their time zone meridian.
+	n := math.Floor(date.Sub(j2000).Hours()/24 + 0.5)
+	for _, delta := range []float64{0, -1, 1} {
+		t, ok := e.onJ2000Day(p, n+delta)
+		if !ok {
+			return time.Time{}, false
+		}
+		t = t.In(loc)
+		if y, m, d := t.Date(); y == year && m == month && d == day {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Private details.
+
+// j2000 is the epoch used by the sunrise equation: January 1st 2000, 12:00
+// UTC which is Julian day 2451545.0.
+var j2000 = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// elevations is the elevation in degrees of the sun's center at the event.
+//
+// Sunrise and sunset account for atmospheric refraction and the solar disc's
+// radius.
+var elevations = map[Event]float64{
+	AstronomicalDawn: -18,
+	NauticalDawn:     -12,
+	CivilDawn:        -6,
+	Sunrise:          -0.833,
+	Sunset:           -0.833,
+	CivilDusk:        -6,
+	NauticalDusk:     -12,
+	AstronomicalDusk: -18,
+}
+
+func (e Event) isMorning() bool {
+	switch e {
+	case AstronomicalDawn, NauticalDawn, CivilDawn, Sunrise:
+		return true
+	}
+	return false
+}
+
+// onJ2000Day returns the event on day n since j2000.
+func (e Event) onJ2000Day(p *Position, n float64) (time.Time, bool) {
+	// Mean solar time.
+	j := n - p.Longitude/360
+	// Solar mean anomaly.
+	m := math.Mod(357.5291+0.98560028*j, 360)
+	mr := rad(m)
+	// Equation of the center.
+	c := 1.9148*math.Sin(mr) + 0.02*math.Sin(2*mr) + 0.0003*math.Sin(3*mr)
+	// Ecliptic longitude.
+	l := rad(math.Mod(m+c+180+102.9372, 360))
+	// Solar transit, in days since j2000.
+	transit := j + 0.0053*math.Sin(mr) - 0.0069*math.Sin(2*l)
+	if e == Noon {
+		return fromJ2000(transit), true
+	}
+	// Declination of the sun.
+	sinD := math.Sin(l) * math.Sin(rad(23.4397))
+	cosD := math.Cos(math.Asin(sinD))
+	// Hour angle.
+	lat := rad(p.Latitude)
+	cosW := (math.Sin(rad(elevations[e])) - math.Sin(lat)*sinD) / (math.Cos(lat) * cosD)
+	if cosW < -1 || cosW > 1 {
+		return time.Time{}, false
+	}
+	w := deg(math.Acos(cosW)) / 360
+	if e.isMorning() {
+		return fromJ2000(transit - w), true
+	}
+	return fromJ2000(transit + w), true
+}
+
+func fromJ2000(days float64) time.Time {
+	return j2000.Add(time.Duration(days * 24 * float64(time.Hour))).Round(time.Second)
+}
+
+func rad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+func deg(r float64) float64 {
+	return r * 180 / math.Pi
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package sun
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOn(t *testing.T) {
+	montreal := &Position{Latitude: 45.5017, Longitude: -73.5673}
+	london := &Position{Latitude: 51.5074, Longitude: -0.1278}
+	tromso := &Position{Latitude: 69.6492, Longitude: 18.9553}
+	edt := time.FixedZone("EDT", -4*3600)
+	data := []struct {
+		p        *Position
+		e        Event
+		date     time.Time
+		expected time.Time
+	}{
+		// Reference values from the NOAA solar calculator.
+		{montreal, Sunrise, time.Date(2018, 6, 21, 0, 0, 0, 0, edt), time.Date(2018, 6, 21, 5, 7, 0, 0, edt)},
+		{montreal, Sunset, time.Date(2018, 6, 21, 0, 0, 0, 0, edt), time.Date(2018, 6, 21, 20, 47, 0, 0, edt)},
+		{montreal, Noon, time.Date(2018, 6, 21, 0, 0, 0, 0, edt), time.Date(2018, 6, 21, 12, 56, 0, 0, edt)},
+		{montreal, CivilDawn, time.Date(2018, 6, 21, 0, 0, 0, 0, edt), time.Date(2018, 6, 21, 4, 30, 0, 0, edt)},
+		{london, Sunrise, time.Date(2018, 12, 21, 0, 0, 0, 0, time.UTC), time.Date(2018, 12, 21, 8, 4, 0, 0, time.UTC)},
+		{london, Sunset, time.Date(2018, 12, 21, 0, 0, 0, 0, time.UTC), time.Date(2018, 12, 21, 15, 53, 0, 0, time.UTC)},
+	}
+	for i, line := range data {
+		y, m, d := line.date.Date()
+		actual, ok := line.e.On(line.p, y, m, d, line.date.Location())
+		if !ok {
+			t.Fatalf("%d: %s didn't happen", i, line.e)
+		}
+		if diff := actual.Sub(line.expected); diff < -3*time.Minute || diff > 3*time.Minute {
+			t.Fatalf("%d: %s: expected %s; got %s", i, line.e, line.expected, actual)
+		}
+	}
+
+	// Midnight sun.
+	if actual, ok := Sunset.On(tromso, 2018, 6, 21, time.UTC); ok {
+		t.Fatalf("unexpected sunset %s", actual)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Event("foo").Validate(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := Noon.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Position{Latitude: 91}).Validate(); err == nil {
+		t.Fatal("expected error")
+	}
+}
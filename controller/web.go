@@ -69,7 +69,7 @@ func isLocalhost(host string) bool {
 	return false
 }
 
-func newWebServer(hostport string, verbose bool, bus msgbus.Bus, db *db, l io.WriterTo) (*webServer, error) {
+func newWebServer(hostport string, verbose bool, bus msgbus.Bus, db *db, r *rulesRunner, f *fsmRunner, h *homieDiscovery, hass *hassBridge, l io.WriterTo) (*webServer, error) {
 	s := &webServer{server: http.Server{Handler: http.DefaultServeMux}}
 	if _, err := rand.Read(s.key[:]); err != nil {
 		return nil, err
@@ -90,7 +90,7 @@ func newWebServer(hostport string, verbose bool, bus msgbus.Bus, db *db, l io.Wr
 	}
 
 	// Setup handlers.
-	s.apis.init(hostname, bus, db, l)
+	s.apis.init(hostname, bus, db, r, f, h, hass, l)
 	for _, h := range s.apis.getAPIs() {
 		http.HandleFunc(h.path, s.api(h.fn))
 	}
@@ -17,74 +17,95 @@ import (
 	"github.com/maruel/anim1d"
 	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/dlibox/shared"
-	"github.com/maruel/interrupt"
 	"github.com/maruel/msgbus"
 	"periph.io/x/periph/conn/display"
-	"periph.io/x/periph/conn/spi/spireg"
 	"periph.io/x/periph/devices/apa102"
 )
 
 type anim1DDev struct {
 	NodeBase
 	Cfg *nodes.Anim1D
+
+	b   msgbus.Bus
+	str *strip
+	p   *painterLoop
 }
 
 func (a *anim1DDev) init(b msgbus.Bus) error {
-	s, err := spireg.Open(a.Cfg.SPI.ID)
+	segs, err := openSegments(a.Cfg, os.Stdout)
 	if err != nil {
 		return err
 	}
-	if err = s.LimitSpeed(a.Cfg.SPI.Hz); err != nil {
-		return err
-	}
-	opts := apa102.DefaultOpts
-	opts.NumPixels = a.Cfg.NumberLights
-	opts.Temperature = 6500
-	apa, err := apa102.New(s, &opts)
-	if err != nil {
-		return err
-	}
-	str := &strip{Drawer: apa, b: b, s: s, fps: a.Cfg.FPS}
-	if err != nil {
-		str.Close()
-		return err
+	a.str = &strip{Drawer: segs, fps: a.Cfg.FPS}
+	if !a.Cfg.Fake {
+		// From now on, Close releases the SPI ports.
+		a.str.s = segs
 	}
 	/*
 		if err := b.Publish(msgbus.Message{"$fake", fakeBytes}, msgbus.ExactlyOnce, true); err != nil {
 			log.Printf("anim1d: publish failed: %v", err)
 		}
 	*/
+	// Subscribe before publishing so the retained values are delivered as
+	// regular messages, processed by the goroutine below.
 	c, err := b.Subscribe("#", msgbus.ExactlyOnce)
 	if err != nil {
 		return err
 	}
-	shared.RetainedStr(b, "$fps", strconv.Itoa(str.fps))
+	a.b = b
+	a.p = newPainter(a.str, a.str.fps)
+	if err := a.p.SetPattern(`"#800000"`, 500*time.Millisecond); err != nil {
+		return err
+	}
+	a.start(func() {
+		for msg := range c {
+			a.str.onMsg(a.p, msg)
+		}
+	})
+	shared.RetainedStr(b, "$fps", strconv.Itoa(a.str.fps))
 	shared.RetainedStr(b, "$num", strconv.Itoa(a.Cfg.NumberLights))
 	shared.RetainedStr(b, "intensity", "255")
 	shared.RetainedStr(b, "temperature", "6500")
+	return nil
+}
 
-	p := newPainter(apa, str.fps)
-	if err := p.SetPattern(`"#800000"`, 500*time.Millisecond); err != nil {
-		return err
+// Close stops listening for messages, then stops the painter and releases the
+// SPI port.
+//
+// It is safe to call even if init failed midway.
+func (a *anim1DDev) Close() error {
+	if a.b != nil {
+		a.b.Unsubscribe("#")
 	}
-
-	go func() {
-		for msg := range c {
-			str.onMsg(p, msg)
+	err := a.NodeBase.Close()
+	if a.p != nil {
+		if err2 := a.p.Close(); err == nil {
+			err = err2
 		}
-	}()
-	return nil
+	}
+	if a.str != nil {
+		if err2 := a.str.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
 }
 
 type strip struct {
 	display.Drawer
 	s   io.Closer
 	fps int
-	b   msgbus.Bus
+
+	mu sync.Mutex // Serializes the writes with the changes to the settings.
+}
+
+func (l *strip) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Drawer.(io.Writer).Write(b)
 }
 
 func (l *strip) Close() error {
-	l.b.Unsubscribe("#")
 	if l.s != nil {
 		return l.s.Close()
 	}
@@ -93,9 +114,11 @@ func (l *strip) Close() error {
 }
 
 // Support both relative and absolute values.
+//
+// Returns the value and the operation: 0 to set, 1 to add and 2 to subtract.
 func processRel(topic string, p []byte) (int, int, error) {
 	if len(p) == 0 {
-		return 0, 0, fmt.Errorf("anim1d: %s missing payload", topic)
+		return 0, 0, fmt.Errorf("%s: missing payload", topic)
 	}
 	s := string(p)
 	op := 0
@@ -108,11 +131,29 @@ func processRel(topic string, p []byte) (int, int, error) {
 	}
 	v, err := strconv.Atoi(s)
 	if err != nil {
-		return 0, 0, fmt.Errorf("anim1d: %s: %v", topic, err)
+		return 0, 0, fmt.Errorf("%s: %v", topic, err)
 	}
 	return v, op, nil
 }
 
+// applyRel applies the value v and operation op returned by processRel to
+// cur, and clamps the result to [min, max].
+func applyRel(cur, v, op, min, max int) int {
+	switch op {
+	case 1:
+		v = cur + v
+	case 2:
+		v = cur - v
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
 func (l *strip) onMsg(p *painterLoop, msg msgbus.Message) {
 	switch msg.Topic {
 	case "anim1d":
@@ -125,54 +166,45 @@ func (l *strip) onMsg(p *painterLoop, msg msgbus.Message) {
 	case "fake":
 	case "fps":
 	case "intensity":
-		a, ok := l.Drawer.(*apa102.Dev)
-		if !ok {
-			log.Printf("anim1d: can't set intensity with fake LED")
-			return
-		}
 		v, op, err := processRel(msg.Topic, msg.Payload)
 		if err != nil {
-			log.Print(err.Error())
+			log.Printf("anim1d: %v", err)
 			return
 		}
-		switch op {
-		case 0:
-		case 1:
-			v = int(a.Intensity) + v
-		case 2:
-			v = int(a.Intensity) - v
-		}
-		if v < 0 {
-			v = 0
-		} else if v > 255 {
-			v = 255
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, seg := range l.Drawer.(*segmentedStrip).segs {
+			var intensity *uint8
+			switch d := seg.d.(type) {
+			case *apa102.Dev:
+				intensity = &d.Intensity
+			case *nrzStrip:
+				intensity = &d.Intensity
+			case *termStrip:
+				intensity = &d.Intensity
+			default:
+				log.Printf("anim1d: can't set intensity with %s", seg.d)
+				continue
+			}
+			*intensity = uint8(applyRel(int(*intensity), v, op, 0, 255))
 		}
-		a.Intensity = uint8(v)
 	case "num":
 	case "temperature":
-		a, ok := l.Drawer.(*apa102.Dev)
-		if !ok {
-			log.Printf("anim1d: can't set temperature with fake LED")
-			return
-		}
 		v, op, err := processRel(msg.Topic, msg.Payload)
 		if err != nil {
-			log.Print(err.Error())
+			log.Printf("anim1d: %v", err)
 			return
 		}
-		switch op {
-		case 0:
-		case 1:
-			v = int(a.Temperature) + v
-		case 2:
-			v = int(a.Temperature) - v
-		}
-		if v < 1000 {
-			v = 1000
-		} else if v > 35000 {
-			v = 35000
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, seg := range l.Drawer.(*segmentedStrip).segs {
+			a, ok := seg.d.(*apa102.Dev)
+			if !ok {
+				log.Printf("anim1d: can't set temperature with %s", seg.d)
+				continue
+			}
+			a.Temperature = uint16(applyRel(int(a.Temperature), v, op, 1000, 35000))
 		}
-		a.Temperature = uint16(v)
 	case "$fps":
 	case "$num":
 		break
@@ -301,9 +333,6 @@ func (p *painterLoop) runPattern(cGen <-chan anim1d.Frame, cWrite chan<- anim1d.
 					since -= time.Duration(t.OffsetMS) * time.Millisecond
 				}
 			}
-
-		case <-interrupt.Channel:
-			return
 		}
 	}
 }
@@ -345,10 +374,6 @@ func (p *painterLoop) runWrite(cGen chan<- anim1d.Frame, cWrite <-chan anim1d.Fr
 		}
 		cGen <- pixels
 
-		select {
-		case <-tick.C:
-		case <-interrupt.Channel:
-			return
-		}
+		<-tick.C
 	}
 }
@@ -7,18 +7,28 @@ package device
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/maruel/dlibox/nodes"
-	"github.com/maruel/interrupt"
 	"github.com/maruel/msgbus"
 	"periph.io/x/periph/conn/gpio"
 	"periph.io/x/periph/conn/gpio/gpioreg"
 )
 
+// edgePollPeriod is the maximum duration a goroutine is blocked waiting for a
+// GPIO edge, so it can notice its node is closed.
+const edgePollPeriod = 100 * time.Millisecond
+
+// maxSettle is the maximum number of debounce periods to wait for a pin to
+// settle, so a noisy line doesn't block the node forever.
+const maxSettle = 5
+
 type buttonDev struct {
 	NodeBase
 	Cfg *nodes.Button
+
+	pin gpio.PinIn
 }
 
 func (b *buttonDev) init(bus msgbus.Bus) error {
@@ -26,44 +36,176 @@ func (b *buttonDev) init(bus msgbus.Bus) error {
 	if pin == nil {
 		return fmt.Errorf("%s: failed to find pin %s", b, b.Cfg.Pin)
 	}
-	if err := pin.In(gpio.PullDown, gpio.BothEdges); err != nil {
-		return fmt.Errorf("%s: failed to pull down %s: %v", b, pin, err)
+	pull := gpio.PullDown
+	if b.Cfg.ActiveLow {
+		pull = gpio.PullUp
+	}
+	if err := pin.In(pull, gpio.BothEdges); err != nil {
+		return fmt.Errorf("%s: failed to pull %s: %v", b, pin, err)
 	}
-	go b.run(bus, pin)
+	b.pin = pin
+	d := newButtonDetector(b.Cfg, pin, time.Now)
+	b.start(func() { b.run(bus, d) })
 	return nil
 }
 
-func (b *buttonDev) run(bus msgbus.Bus, pin gpio.PinIn) {
-	//index := 0
-	last := gpio.High
+func (b *buttonDev) Close() error {
+	err := b.NodeBase.Close()
+	if b.pin != nil {
+		if err2 := haltPin(b.pin); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// haltPin disables edge detection and stops the pin.
+func haltPin(pin gpio.PinIn) error {
+	if err := pin.In(gpio.PullNoChange, gpio.NoEdge); err != nil {
+		return err
+	}
+	return pin.Halt()
+}
+
+func (b *buttonDev) run(bus msgbus.Bus, d *buttonDetector) {
+	msgs := []msgbus.Message{d.state()}
 	for {
-		// Types of press:
-		// - Short press (<2s)
-		// - 2s press
-		// - 4s press
-		// - double-click (incompatible with repeated short press)
-		pin.WaitForEdge(-1)
-		if state := pin.Read(); state != last {
-			last = state
-			log.Printf("%s: %s", b, state)
-			// TODO(maruel): sub-second resolution?
-			now := time.Now()
-			nowStr := []byte(fmt.Sprintf("%d %s", now.Unix(), now))
-			// Fix convention.
-			err := bus.Publish(msgbus.Message{Topic: "button", Payload: nowStr}, msgbus.ExactlyOnce)
-			if err != nil {
-				log.Printf("%s: failed to publish: %v", b, err)
-			}
-			if err != nil {
+		for _, msg := range msgs {
+			if err := bus.Publish(msg, msgbus.ExactlyOnce); err != nil {
 				log.Printf("%s: failed to publish: %v", b, err)
 			}
-		} else {
-			log.Printf("%s: %s", b, state)
 		}
-		select {
-		case <-interrupt.Channel:
+		if b.ctx.Err() != nil {
 			return
-		case <-time.After(time.Millisecond):
 		}
+		msgs = d.step(edgePollPeriod)
+	}
+}
+
+// buttonDetector debounces a button pin and detects its gestures.
+type buttonDetector struct {
+	pin      gpio.PinIn
+	now      func() time.Time
+	pressed  gpio.Level
+	debounce time.Duration
+	double   time.Duration
+	long     time.Duration
+	repeat   time.Duration
+
+	down    bool      // Debounced state.
+	holds   int       // Hold events sent during the press, -1 before the long press.
+	next    time.Time // Next long or hold event while down, zero if none.
+	clickAt time.Time // When a pending click is published, zero if none.
+}
+
+func newButtonDetector(cfg *nodes.Button, pin gpio.PinIn, now func() time.Time) *buttonDetector {
+	d := &buttonDetector{
+		pin:      pin,
+		now:      now,
+		pressed:  gpio.High,
+		debounce: 20 * time.Millisecond,
+		double:   300 * time.Millisecond,
+		long:     time.Second,
+		repeat:   250 * time.Millisecond,
+	}
+	if cfg.ActiveLow {
+		d.pressed = gpio.Low
+	}
+	if cfg.DebounceMS != 0 {
+		d.debounce = time.Duration(cfg.DebounceMS) * time.Millisecond
+	}
+	if cfg.DoubleMS != 0 {
+		d.double = time.Duration(cfg.DoubleMS) * time.Millisecond
+	}
+	if cfg.LongMS != 0 {
+		d.long = time.Duration(cfg.LongMS) * time.Millisecond
+	}
+	if cfg.RepeatMS != 0 {
+		d.repeat = time.Duration(cfg.RepeatMS) * time.Millisecond
 	}
+	// A press already in progress at startup doesn't trigger any gesture.
+	d.down = pin.Read() == d.pressed
+	return d
+}
+
+// state returns the retained debounced state.
+func (d *buttonDetector) state() msgbus.Message {
+	return msgbus.Message{Topic: "button", Payload: []byte(strconv.FormatBool(d.down)), Retained: true}
+}
+
+// step waits up to max for the pin to change and returns the resulting
+// messages.
+func (d *buttonDetector) step(max time.Duration) []msgbus.Message {
+	timeout := max
+	now := d.now()
+	deadlines := []time.Time{d.next}
+	if !d.down {
+		deadlines = append(deadlines, d.clickAt)
+	}
+	for _, t := range deadlines {
+		if !t.IsZero() && t.Sub(now) < timeout {
+			timeout = t.Sub(now)
+		}
+	}
+	if timeout < 0 {
+		timeout = 0
+	}
+	var out []msgbus.Message
+	if d.pin.WaitForEdge(timeout) {
+		// Wait for the pin to settle.
+		for i := 0; i < maxSettle && d.pin.WaitForEdge(d.debounce); i++ {
+		}
+		if down := d.pin.Read() == d.pressed; down != d.down {
+			out = d.change(down, d.now())
+		}
+	}
+	return append(out, d.timers(d.now())...)
+}
+
+// change processes a debounced press or release.
+func (d *buttonDetector) change(down bool, now time.Time) []msgbus.Message {
+	d.down = down
+	out := []msgbus.Message{d.state()}
+	if down {
+		d.holds = -1
+		d.next = now.Add(d.long)
+		return out
+	}
+	d.next = time.Time{}
+	if d.holds >= 0 {
+		// The end of a long press or of a press started before.
+		return out
+	}
+	if !d.clickAt.IsZero() {
+		d.clickAt = time.Time{}
+		return append(out, buttonEvent("double", "true"))
+	}
+	// Wait to see if it becomes a double click.
+	d.clickAt = now.Add(d.double)
+	return out
+}
+
+// timers returns the events due at now.
+func (d *buttonDetector) timers(now time.Time) []msgbus.Message {
+	var out []msgbus.Message
+	// While the second press is in progress, the click is held until it is
+	// known if it's a double click.
+	if !d.clickAt.IsZero() && !now.Before(d.clickAt) && (!d.down || !d.next.IsZero() && !now.Before(d.next)) {
+		d.clickAt = time.Time{}
+		out = append(out, buttonEvent("click", "true"))
+	}
+	if !d.next.IsZero() && !now.Before(d.next) {
+		if d.holds == -1 {
+			out = append(out, buttonEvent("long", "true"))
+		} else {
+			out = append(out, buttonEvent("hold", strconv.Itoa(d.holds+1)))
+		}
+		d.holds++
+		d.next = d.next.Add(d.repeat)
+	}
+	return out
+}
+
+func buttonEvent(topic, payload string) msgbus.Message {
+	return msgbus.Message{Topic: topic, Payload: []byte(payload)}
 }
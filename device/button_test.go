@@ -0,0 +1,162 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+)
+
+func TestButtonDetector(t *testing.T) {
+	data := []struct {
+		cfg      nodes.Button
+		initial  gpio.Level
+		edges    []scriptedEdge
+		expected []string
+	}{
+		// A click, with bounces on press.
+		{
+			nodes.Button{},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {105, gpio.Low}, {110, gpio.High}, {200, gpio.Low}},
+			[]string{"button false", "button true", "button false", "click true"},
+		},
+		// A glitch shorter than the debounce is ignored.
+		{
+			nodes.Button{},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {105, gpio.Low}},
+			[]string{"button false"},
+		},
+		{
+			nodes.Button{},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {200, gpio.Low}, {300, gpio.High}, {400, gpio.Low}},
+			[]string{"button false", "button true", "button false", "button true", "button false", "double true"},
+		},
+		// Two clicks too far apart.
+		{
+			nodes.Button{DoubleMS: 200},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {200, gpio.Low}, {500, gpio.High}, {600, gpio.Low}},
+			[]string{"button false", "button true", "button false", "click true", "button true", "button false", "click true"},
+		},
+		// The long press starts after the debounce, at 120ms.
+		{
+			nodes.Button{},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {1700, gpio.Low}},
+			[]string{"button false", "button true", "long true", "hold 1", "hold 2", "button false"},
+		},
+		// A click followed by a long press.
+		{
+			nodes.Button{DoubleMS: 300, LongMS: 500},
+			gpio.Low,
+			[]scriptedEdge{{100, gpio.High}, {200, gpio.Low}, {300, gpio.High}, {900, gpio.Low}},
+			[]string{"button false", "button true", "button false", "button true", "click true", "long true", "button false"},
+		},
+		{
+			nodes.Button{ActiveLow: true},
+			gpio.High,
+			[]scriptedEdge{{100, gpio.Low}, {200, gpio.High}},
+			[]string{"button false", "button true", "button false", "click true"},
+		},
+		// A press in progress at startup is not a click.
+		{
+			nodes.Button{},
+			gpio.High,
+			[]scriptedEdge{{100, gpio.Low}},
+			[]string{"button true", "button false"},
+		},
+	}
+	for i, line := range data {
+		p := &scriptedPin{now: time.Unix(1000, 0), l: line.initial}
+		start := p.now
+		for _, e := range line.edges {
+			p.edges = append(p.edges, edgeAt{start.Add(e.ms * time.Millisecond), e.l})
+		}
+		d := newButtonDetector(&line.cfg, p, func() time.Time { return p.now })
+		s := d.state()
+		actual := []string{s.Topic + " " + string(s.Payload)}
+		for p.now.Before(start.Add(3 * time.Second)) {
+			for _, msg := range d.step(edgePollPeriod) {
+				actual = append(actual, msg.Topic+" "+string(msg.Payload))
+			}
+		}
+		if !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("#%d: %q != %q", i, line.expected, actual)
+		}
+	}
+}
+
+func TestButtonDetector_Noisy(t *testing.T) {
+	p := &noisyPin{scriptedPin: scriptedPin{now: time.Unix(1000, 0)}}
+	start := p.now
+	d := newButtonDetector(&nodes.Button{}, p, func() time.Time { return p.now })
+	// The pin never settles; it is read anyway after a few debounce periods.
+	d.step(edgePollPeriod)
+	if p.waits != maxSettle+1 {
+		t.Fatalf("%d != %d", maxSettle+1, p.waits)
+	}
+	if e := p.now.Sub(start); e != time.Duration(maxSettle+1)*time.Millisecond {
+		t.Fatal(e)
+	}
+}
+
+//
+
+type scriptedEdge struct {
+	ms time.Duration
+	l  gpio.Level
+}
+
+type edgeAt struct {
+	t time.Time
+	l gpio.Level
+}
+
+// scriptedPin is a gpio.PinIn that replays edges on a fake clock.
+//
+// WaitForEdge advances the clock to the next edge or by the timeout.
+type scriptedPin struct {
+	gpiotest.Pin
+	now   time.Time
+	l     gpio.Level
+	edges []edgeAt
+}
+
+func (s *scriptedPin) Read() gpio.Level {
+	return s.l
+}
+
+func (s *scriptedPin) WaitForEdge(timeout time.Duration) bool {
+	if len(s.edges) != 0 && (timeout < 0 || !s.edges[0].t.After(s.now.Add(timeout))) {
+		s.now, s.l = s.edges[0].t, s.edges[0].l
+		s.edges = s.edges[1:]
+		return true
+	}
+	if timeout > 0 {
+		s.now = s.now.Add(timeout)
+	}
+	return false
+}
+
+// noisyPin is a gpio.PinIn that toggles every millisecond.
+type noisyPin struct {
+	scriptedPin
+	waits int
+}
+
+func (n *noisyPin) WaitForEdge(timeout time.Duration) bool {
+	n.waits++
+	n.now = n.now.Add(time.Millisecond)
+	n.l = !n.l
+	return true
+}
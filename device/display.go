@@ -5,80 +5,139 @@
 package device
 
 import (
+	"bytes"
+	"image"
+	"image/draw"
+	"io"
 	"log"
+	"time"
 
 	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/msgbus"
 	"github.com/maruel/psf"
-	"periph.io/x/periph/conn/i2c/i2creg"
-	"periph.io/x/periph/devices/ssd1306"
-	"periph.io/x/periph/devices/ssd1306/image1bit"
+	"periph.io/x/periph/conn/display"
 )
 
 type displayDev struct {
 	NodeBase
 	Cfg *nodes.Display
 
-	d   *ssd1306.Dev
-	img *image1bit.VerticalLSB
-	f12 *psf.Font
-	f20 *psf.Font
+	b      msgbus.Bus
+	subs   []string  // Subscribed topics.
+	c      io.Closer // Bus or port of the display, nil for PNG.
+	d      display.Drawer
+	img    draw.Image
+	last   []byte // Pixels of the last frame written to d.d.
+	s      *screen
+	redraw chan struct{}
 }
 
 func (d *displayDev) init(b msgbus.Bus) error {
-	i2cBus, err := i2creg.Open(d.Cfg.I2C.ID)
-	if err != nil {
+	var err error
+	if d.d, d.c, err = openDisplay(d.Cfg); err != nil {
 		return err
 	}
-	opts := ssd1306.DefaultOpts
-	opts.W = d.Cfg.W
-	opts.H = d.Cfg.H
-	d.d, err = ssd1306.NewI2C(i2cBus, &opts)
-	if err != nil {
+	img, fg, bg := newFrame(d.d, d.Cfg.IsPaper())
+	if d.s, err = newScreen(d.Cfg, psf.Load, fg, bg); err != nil {
 		return err
 	}
-	d.f12, err = psf.Load("Terminus12x6")
-	if err != nil {
+	d.img = img
+	d.redraw = make(chan struct{}, 1)
+	d.b = b
+	if err := d.subscribe("markee", func(msg msgbus.Message) {
+		d.s.setMarkee(string(msg.Payload), time.Now())
+	}); err != nil {
 		return err
 	}
-	d.f20, err = psf.Load("Terminus20x10")
-	if err != nil {
+	if err := d.subscribe("content", func(msg msgbus.Message) {
+		if err := d.s.setContent(string(msg.Payload)); err != nil {
+			log.Printf("display: invalid content template: %v", err)
+		}
+	}); err != nil {
 		return err
 	}
-	bounds := d.d.Bounds()
-	d.img = image1bit.NewVerticalLSB(bounds)
-	d.f20.Draw(d.img, 0, 0, image1bit.On, nil, "dlibox!")
-	d.f12.Draw(d.img, 0, bounds.Dy()-d.f12.H-1, image1bit.On, nil, "is awesome")
-	if _, err = d.d.Write(d.img.Pix); err != nil {
-		return err
+	for _, t := range d.s.topics() {
+		t := t
+		// The topics are relative to the device, not to the node.
+		if err := d.subscribe("//"+t, func(msg msgbus.Message) {
+			d.s.setValue(t, string(msg.Payload))
+		}); err != nil {
+			return err
+		}
+	}
+	d.start(d.run)
+	return nil
+}
+
+// Close stops listening for messages, turns the display off and releases the
+// bus.
+//
+// It is safe to call even if init failed midway.
+func (d *displayDev) Close() error {
+	for _, t := range d.subs {
+		d.b.Unsubscribe(t)
 	}
-	c, err := b.Subscribe("#", msgbus.ExactlyOnce)
+	err := d.NodeBase.Close()
+	if d.d != nil {
+		if err2 := d.d.Halt(); err == nil {
+			err = err2
+		}
+	}
+	if d.c != nil {
+		if err2 := d.c.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// subscribe calls f for each message on topic and redraws the display.
+func (d *displayDev) subscribe(topic string, f func(msg msgbus.Message)) error {
+	c, err := d.b.Subscribe(topic, msgbus.ExactlyOnce)
 	if err != nil {
 		return err
 	}
-	go func() {
+	d.subs = append(d.subs, topic)
+	d.start(func() {
 		for msg := range c {
-			d.onMsg(msg)
+			f(msg)
+			select {
+			case d.redraw <- struct{}{}:
+			default:
+			}
 		}
-	}()
+	})
 	return nil
 }
 
-/*
-func (d *displayDev) Close() error {
-	d.b.Unsubscribe("display/#")
-	return nil
-}
-*/
-
-func (d *displayDev) onMsg(msg msgbus.Message) {
-	switch msg.Topic {
-	case "settext":
-		d.f20.Draw(d.img, 0, 0, image1bit.On, nil, string(msg.Payload))
-		if _, err := d.d.Write(d.img.Pix); err != nil {
-			log.Printf("display write failure: %# v", msg)
+// run redraws the display on change and periodically for the clocks and the
+// markee.
+//
+// A frame identical to the last one written is skipped, as the e-paper panels
+// take seconds to refresh.
+func (d *displayDev) run() {
+	t := time.NewTimer(0)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.redraw:
+			if !t.Stop() {
+				<-t.C
+			}
+		case <-t.C:
+		}
+		d.s.draw(d.img, time.Now())
+		if p := pixels(d.img); p == nil || !bytes.Equal(p, d.last) {
+			if err := d.d.Draw(d.d.Bounds(), d.img, image.Point{}); err != nil {
+				log.Printf("display: write failure: %v", err)
+				// Retry on the next redraw.
+				d.last = nil
+			} else {
+				d.last = append(d.last[:0], p...)
+			}
 		}
-	default:
-		log.Printf("display unknown msg: %# v", msg)
+		t.Reset(d.s.refresh())
 	}
 }
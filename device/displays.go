@@ -0,0 +1,231 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/maruel/dlibox/nodes"
+	"periph.io/x/periph/conn/display"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/devices/ssd1306"
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+	"periph.io/x/periph/experimental/devices/epd"
+	"periph.io/x/periph/experimental/devices/inky"
+)
+
+// openDisplay returns the display described by cfg and the bus or port to
+// close once done, nil for PNG.
+func openDisplay(cfg *nodes.Display) (display.Drawer, io.Closer, error) {
+	if cfg.PNG {
+		return newPNGDisplay(cfg.W, cfg.H, cfg.Path), nil, nil
+	}
+	if cfg.SSD1306 && len(cfg.I2C.ID) != 0 {
+		bus, err := i2creg.Open(cfg.I2C.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts := ssd1306.DefaultOpts
+		opts.W = cfg.W
+		opts.H = cfg.H
+		d, err := ssd1306.NewI2C(bus, &opts)
+		if err != nil {
+			bus.Close()
+			return nil, nil, err
+		}
+		return d, bus, nil
+	}
+	p, err := spireg.Open(cfg.SPI.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := openSPIDisplay(cfg, p)
+	if err != nil {
+		p.Close()
+		return nil, nil, err
+	}
+	return d, p, nil
+}
+
+// openSPIDisplay returns the display described by cfg connected on p.
+func openSPIDisplay(cfg *nodes.Display, p spi.PortCloser) (display.Drawer, error) {
+	if cfg.SPI.Hz != 0 {
+		if err := p.LimitSpeed(cfg.SPI.Hz); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case cfg.SSD1306:
+		dc, err := displayPin(cfg.DC)
+		if err != nil {
+			return nil, err
+		}
+		opts := ssd1306.DefaultOpts
+		opts.W = cfg.W
+		opts.H = cfg.H
+		return ssd1306.NewSPI(p, dc, &opts)
+	case cfg.EPD:
+		opts := epd.EPD1in54
+		if cfg.Model == "2in13" {
+			opts = epd.EPD2in13
+		}
+		d, err := epd.NewSPIHat(p, &opts)
+		if err != nil {
+			return nil, err
+		}
+		return &epdDisplay{d}, nil
+	default:
+		opts := inky.Opts{BorderColor: inky.White}
+		if err := opts.Model.Set(cfg.Model); err != nil {
+			return nil, err
+		}
+		if err := opts.ModelColor.Set(cfg.Color); err != nil {
+			return nil, err
+		}
+		var pins [3]gpio.PinIO
+		for i, n := range []string{cfg.DC, cfg.Reset, cfg.Busy} {
+			var err error
+			if pins[i], err = displayPin(n); err != nil {
+				return nil, err
+			}
+		}
+		return inky.New(p, pins[0], pins[1], pins[2], &opts)
+	}
+}
+
+func displayPin(name string) (gpio.PinIO, error) {
+	p := gpioreg.ByName(name)
+	if p == nil {
+		return nil, fmt.Errorf("display: failed to find pin %s", name)
+	}
+	return p, nil
+}
+
+// newFrame returns a frame buffer of the size of d at its color depth, with
+// the foreground and background colors to draw with.
+//
+// The e-paper panels draw black on white, the other displays white on black.
+func newFrame(d display.Drawer, paper bool) (draw.Image, color.Color, color.Color) {
+	m := d.ColorModel()
+	fg, bg := m.Convert(color.White), m.Convert(color.Black)
+	if paper {
+		fg, bg = bg, fg
+	}
+	r := d.Bounds()
+	switch m.Convert(color.Gray{Y: 0x80}).(type) {
+	case image1bit.Bit:
+		return image1bit.NewVerticalLSB(r), fg, bg
+	case color.Gray:
+		return image.NewGray(r), fg, bg
+	default:
+		return image.NewNRGBA(r), fg, bg
+	}
+}
+
+// pixels returns the raw pixels of an image returned by newFrame.
+func pixels(img draw.Image) []byte {
+	switch i := img.(type) {
+	case *image1bit.VerticalLSB:
+		return i.Pix
+	case *image.Gray:
+		return i.Pix
+	case *image.NRGBA:
+		return i.Pix
+	default:
+		return nil
+	}
+}
+
+// epdDisplay refreshes the e-paper panel after each Draw.
+type epdDisplay struct {
+	*epd.Dev
+}
+
+func (e *epdDisplay) String() string {
+	return "EPD"
+}
+
+// Draw implements display.Drawer.
+func (e *epdDisplay) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	if err := e.Dev.Draw(r, src, sp); err != nil {
+		return err
+	}
+	return e.DisplayFrame()
+}
+
+// pngDisplay is an in-memory display keeping the last frame.
+//
+// Each frame is saved as a PNG to path when set.
+type pngDisplay struct {
+	path string
+
+	mu  sync.Mutex
+	img *image.NRGBA
+}
+
+func newPNGDisplay(w, h int, path string) *pngDisplay {
+	return &pngDisplay{path: path, img: image.NewNRGBA(image.Rect(0, 0, w, h))}
+}
+
+func (p *pngDisplay) String() string {
+	return "PNG"
+}
+
+// Halt implements display.Drawer.
+func (p *pngDisplay) Halt() error {
+	return nil
+}
+
+// ColorModel implements display.Drawer.
+func (p *pngDisplay) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+// Bounds implements display.Drawer.
+func (p *pngDisplay) Bounds() image.Rectangle {
+	return p.img.Rect
+}
+
+// Draw implements display.Drawer.
+func (p *pngDisplay) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	draw.Draw(p.img, r, src, sp, draw.Src)
+	if len(p.path) == 0 {
+		return nil
+	}
+	b, err := p.encode()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.path, b, 0644)
+}
+
+// PNG returns the last frame encoded as a PNG.
+func (p *pngDisplay) PNG() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.encode()
+}
+
+func (p *pngDisplay) encode() ([]byte, error) {
+	var b bytes.Buffer
+	if err := png.Encode(&b, p.img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"github.com/maruel/psf"
+	"periph.io/x/periph/conn/display/displaytest"
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+func TestNewFrame(t *testing.T) {
+	r := image.Rect(0, 0, 8, 4)
+	data := []struct {
+		d      *modelDrawer
+		paper  bool
+		frame  string
+		fg, bg color.Color
+	}{
+		{&modelDrawer{m: image1bit.BitModel}, false, "*image1bit.VerticalLSB", image1bit.On, image1bit.Off},
+		{&modelDrawer{m: image1bit.BitModel}, true, "*image1bit.VerticalLSB", image1bit.Off, image1bit.On},
+		{&modelDrawer{m: color.GrayModel}, false, "*image.Gray", color.Gray{Y: 0xFF}, color.Gray{}},
+		{&modelDrawer{m: color.NRGBAModel}, true, "*image.NRGBA", color.NRGBA{A: 0xFF}, color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+	for i, line := range data {
+		line.d.Drawer.Img = image.NewNRGBA(r)
+		img, fg, bg := newFrame(line.d, line.paper)
+		if s := fmt.Sprintf("%T", img); s != line.frame {
+			t.Fatalf("#%d: %s != %s", i, line.frame, s)
+		}
+		if img.Bounds() != r {
+			t.Fatalf("#%d: %s", i, img.Bounds())
+		}
+		if fg != line.fg || bg != line.bg {
+			t.Fatalf("#%d: %v %v", i, fg, bg)
+		}
+	}
+}
+
+func TestDisplayPNG(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	n := &nodes.Node{
+		Name: "Screen",
+		Config: &nodes.Display{
+			PNG: true, W: 24, H: 8,
+			Widgets: []nodes.Widget{{Kind: nodes.WidgetProgress, Topic: "tape/level", Max: 100}},
+		},
+	}
+	p, err := genNodeDev("screen", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.init(msgbus.RebasePub(msgbus.RebaseSub(b, "screen"), "screen")); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := b.Publish(msgbus.Message{Topic: "tape/level", Payload: []byte("50")}, msgbus.ExactlyOnce); err != nil {
+		t.Fatal(err)
+	}
+	// The outline and half of the inner area of the bar are white.
+	d := p.(*displayDev).d.(*pngDisplay)
+	for start := time.Now(); ; {
+		b, err := d.PNG()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isWhite(img.At(0, 0)) && isWhite(img.At(11, 4)) && !isWhite(img.At(12, 4)) {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the bar wasn't drawn")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDisplaySkipSame(t *testing.T) {
+	cfg := &nodes.Display{PNG: true, W: 16, H: 2, RefreshMS: 60000, Widgets: []nodes.Widget{{Kind: nodes.WidgetContent}}}
+	load := func(name string) (*psf.Font, error) {
+		return &psf.Font{H: 2, W: 8, Letters: map[rune][]byte{'#': {0xFF, 0xFF}}}, nil
+	}
+	s, err := newScreen(cfg, load, image1bit.On, image1bit.Off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &countDrawer{modelDrawer: modelDrawer{m: image1bit.BitModel}}
+	c.Drawer.Img = image.NewNRGBA(image.Rect(0, 0, 16, 2))
+	d := &displayDev{
+		NodeBase: NodeBase{id: "screen", name: "Screen", typ: "display", ctx: ctx, cancel: cancel},
+		Cfg:      cfg,
+		d:        c,
+		s:        s,
+		redraw:   make(chan struct{}, 1),
+	}
+	d.img, _, _ = newFrame(c, false)
+	d.start(d.run)
+	// The first frame is drawn, the same frame is skipped.
+	for i := 0; i < 3; i++ {
+		d.redraw <- struct{}{}
+	}
+	s.setContent("#")
+	d.redraw <- struct{}{}
+	for c.count() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := d.NodeBase.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.count(); n != 2 {
+		t.Fatalf("2 != %d", n)
+	}
+}
+
+func TestPNGDisplayPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dlibox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "frame.png")
+	d := newPNGDisplay(2, 1, path)
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Pix[0], src.Pix[3] = 0xFF, 0xFF
+	if err := d.Draw(d.Bounds(), src, image.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, a := img.At(0, 0).RGBA(); r != 0xFFFF || a != 0xFFFF {
+		t.Fatal(img.At(0, 0))
+	}
+}
+
+func isWhite(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r == 0xFFFF && g == 0xFFFF && b == 0xFFFF
+}
+
+// modelDrawer is a display.Drawer with a custom color model.
+type modelDrawer struct {
+	displaytest.Drawer
+	m color.Model
+}
+
+func (m *modelDrawer) ColorModel() color.Model {
+	return m.m
+}
+
+// countDrawer counts the frames drawn.
+type countDrawer struct {
+	modelDrawer
+
+	mu sync.Mutex
+	n  int
+}
+
+func (c *countDrawer) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+	return nil
+}
+
+func (c *countDrawer) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
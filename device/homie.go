@@ -0,0 +1,147 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+)
+
+// homieBridge exposes the device as a spec compliant Homie device under
+// homie/<device ID>, so it can be used by any Homie controller.
+//
+// The property values published by the nodes are mirrored into the Homie tree
+// and the <node>/<property>/set commands are forwarded to the nodes.
+type homieBridge struct {
+	hb   msgbus.Bus // Rebased on homie/<device ID>.
+	dbus msgbus.Bus // Rebased on dlibox/<device ID>.
+
+	mu   sync.Mutex
+	cfg  *nodes.SerializedDev
+	subs map[string]bool // "<node>/<property>" subscribed to.
+}
+
+// newHomieBridge returns a bridge for the device id.
+//
+// bus must not be rebased, dbus must be rebased on the device ID.
+func newHomieBridge(bus, dbus msgbus.Bus, id string) (*homieBridge, error) {
+	if err := nodes.ID(id).Validate(); err != nil {
+		return nil, fmt.Errorf("homie: device %v", err)
+	}
+	root := nodes.HomieRoot + "/" + id
+	return &homieBridge{
+		hb:   msgbus.RebasePub(msgbus.RebaseSub(bus, root), root),
+		dbus: dbus,
+		subs: map[string]bool{},
+	}, nil
+}
+
+// publish publishes the Homie description of the nodes.
+//
+// It must be called each time the nodes are reconfigured.
+func (h *homieBridge) publish(cfg *nodes.Dev) {
+	s := cfg.ToSerialized()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg = s
+	s.PublishHomie(h.hb)
+	for id, n := range s.Nodes {
+		for p := range n.Properties {
+			key := string(id) + "/" + string(p)
+			if h.subs[key] {
+				continue
+			}
+			// The topics are never unsubscribed from, in case the property is added
+			// back; messages for properties not in the configuration are ignored.
+			if err := h.subscribe(h.dbus, key, h.onValue); err != nil {
+				log.Printf("homie: %v", err)
+				continue
+			}
+			if err := h.subscribe(h.hb, key+"/set", h.onSet); err != nil {
+				log.Printf("homie: %v", err)
+				continue
+			}
+			h.subs[key] = true
+		}
+	}
+}
+
+// Close marks the Homie device as disconnected.
+func (h *homieBridge) Close() error {
+	shared.RetainedStr(h.hb, "$state", nodes.HomieDisconnected)
+	return nil
+}
+
+func (h *homieBridge) subscribe(b msgbus.Bus, topic string, f func(msg msgbus.Message)) error {
+	c, err := b.Subscribe(topic, msgbus.ExactlyOnce)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+	}
+	go func() {
+		for msg := range c {
+			f(msg)
+		}
+	}()
+	return nil
+}
+
+// onValue mirrors a property value published by a node.
+//
+// The events are not retained, so they are not replayed to the controllers
+// connecting later.
+func (h *homieBridge) onValue(msg msgbus.Message) {
+	p, _, ok := h.property(msg.Topic)
+	if !ok {
+		return
+	}
+	if !p.Event {
+		shared.Retained(h.hb, msg.Topic, msg.Payload)
+		return
+	}
+	if err := h.hb.Publish(msgbus.Message{Topic: msg.Topic, Payload: msg.Payload}, msgbus.ExactlyOnce); err != nil {
+		log.Printf("homie: failed to publish %s: %v", msg.Topic, err)
+	}
+}
+
+// onSet forwards a Homie command to the node.
+func (h *homieBridge) onSet(msg msgbus.Message) {
+	key := strings.TrimSuffix(msg.Topic, "/set")
+	p, t, ok := h.property(key)
+	if !ok || !p.Settable {
+		log.Printf("homie: ignoring %s", msg.Topic)
+		return
+	}
+	i := strings.IndexByte(key, '/')
+	topic := key[:i+1] + nodes.CommandTopic(t, nodes.ID(key[i+1:]))
+	if err := h.dbus.Publish(msgbus.Message{Topic: topic, Payload: msg.Payload}, msgbus.ExactlyOnce); err != nil {
+		log.Printf("homie: failed to publish %s: %v", topic, err)
+	}
+}
+
+// property returns the currently configured property for "<node>/<property>"
+// and the type of its node.
+func (h *homieBridge) property(key string) (nodes.Property, nodes.Type, bool) {
+	i := strings.IndexByte(key, '/')
+	if i == -1 {
+		return nodes.Property{}, "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cfg == nil {
+		return nodes.Property{}, "", false
+	}
+	n := h.cfg.Nodes[nodes.ID(key[:i])]
+	if n == nil {
+		return nodes.Property{}, "", false
+	}
+	p, ok := n.Properties[nodes.ID(key[i+1:])]
+	return p, n.Type, ok
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+)
+
+func TestHomieBridge(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "dlibox/dev1"), "dlibox/dev1")
+	h, err := newHomieBridge(b, d, "dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dv := dev{homie: h}
+	cfg := &nodes.Dev{
+		Name:  "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{"sound1": {Name: "Speaker", Config: &nodes.Sound{}}},
+	}
+	if _, err := dv.reconfigure(d, cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer dv.Close()
+	msgs, err := msgbus.Retained(b, time.Second, "homie/dev1/$state", "homie/dev1/sound1/speakers/$settable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(msgs["homie/dev1/$state"]); s != nodes.HomieReady {
+		t.Fatalf("unexpected state %q", s)
+	}
+	if s := string(msgs["homie/dev1/sound1/speakers/$settable"]); s != "true" {
+		t.Fatalf("unexpected $settable %q", s)
+	}
+
+	// A Homie command is forwarded to the node, and the new value is mirrored
+	// back.
+	c, err := d.Subscribe("sound1/speakers", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := b.Subscribe("homie/dev1/sound1/speakers", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(msgbus.Message{Topic: "homie/dev1/sound1/speakers/set", Payload: []byte("http://radio")}, msgbus.ExactlyOnce); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []<-chan msgbus.Message{c, mirror} {
+		select {
+		case msg := <-c:
+			if string(msg.Payload) != "http://radio" {
+				t.Fatalf("unexpected message %#v", msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("command not forwarded")
+		}
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if msgs, _ := msgbus.Retained(b, time.Second, "homie/dev1/$state"); string(msgs["homie/dev1/$state"]) != nodes.HomieDisconnected {
+		t.Fatalf("unexpected state %q", msgs["homie/dev1/$state"])
+	}
+}
+
+func TestHomieBridge_Event(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "dlibox/dev1"), "dlibox/dev1")
+	h, err := newHomieBridge(b, d, "dev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.publish(&nodes.Dev{
+		Name:  "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{"btn": {Name: "Button", Config: &nodes.Button{}}},
+	})
+	msgs, err := msgbus.Retained(b, time.Second, "homie/dev1/btn/button/$retained", "homie/dev1/btn/click/$retained")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(msgs["homie/dev1/btn/button/$retained"]); s != "true" {
+		t.Fatalf("unexpected button $retained %q", s)
+	}
+	if s := string(msgs["homie/dev1/btn/click/$retained"]); s != "false" {
+		t.Fatalf("unexpected click $retained %q", s)
+	}
+
+	// A gesture is mirrored but not retained, so it is not replayed to the
+	// controllers connecting later.
+	c, err := b.Subscribe("homie/dev1/btn/click", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Publish(msgbus.Message{Topic: "btn/click", Payload: []byte("true")}, msgbus.ExactlyOnce); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-c:
+		if string(msg.Payload) != "true" || msg.Retained {
+			t.Fatalf("unexpected message %#v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not mirrored")
+	}
+	if msgs, _ := msgbus.Retained(b, 100*time.Millisecond, "homie/dev1/btn/click"); len(msgs) != 0 {
+		t.Fatalf("unexpected retained event %q", msgs)
+	}
+}
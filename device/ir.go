@@ -5,40 +5,248 @@
 package device
 
 import (
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/maruel/dlibox/ircode"
 	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/ir"
 	"periph.io/x/periph/devices/lirc"
 )
 
+// irReleaseGap is the silence after which a held key is considered released.
+//
+// The remotes repeat a held key about every 110ms.
+const irReleaseGap = 250 * time.Millisecond
+
+// irFrameGap is the silence after which an IR frame is considered complete.
+//
+// It is longer than the longest space within a frame and shorter than the
+// interval between repeated frames.
+const irFrameGap = 10 * time.Millisecond
+
 type irDev struct {
 	NodeBase
 	Cfg *nodes.IR
+
+	conn *lirc.Conn
+	b    msgbus.Bus
+	pin  gpio.PinIn
+	now  func() time.Time // Replaced in tests.
+
+	mu       sync.Mutex
+	learning string // Name of the code being learned.
 }
 
 func (i *irDev) init(b msgbus.Bus) error {
-	bus, err := lirc.New()
+	if len(i.Cfg.Pin) != 0 {
+		return i.initPin(b)
+	}
+	conn, err := lirc.New()
+	if err != nil {
+		return err
+	}
+	i.conn = conn
+	i.start(func() { i.run(b, conn.Channel()) })
+	return nil
+}
+
+func (i *irDev) initPin(b msgbus.Bus) error {
+	pin := gpioreg.ByName(i.Cfg.Pin)
+	if pin == nil {
+		return fmt.Errorf("%s: failed to find pin %s", i, i.Cfg.Pin)
+	}
+	// The output of the receivers is active low.
+	if err := pin.In(gpio.PullUp, gpio.BothEdges); err != nil {
+		return fmt.Errorf("%s: failed to pull up %s: %v", i, pin, err)
+	}
+	i.pin = pin
+	if i.now == nil {
+		i.now = time.Now
+	}
+	c, err := b.Subscribe("learn", msgbus.ExactlyOnce)
 	if err != nil {
 		return err
 	}
-	go i.run(b, bus)
+	i.b = b
+	i.start(func() {
+		for msg := range c {
+			i.mu.Lock()
+			i.learning = string(msg.Payload)
+			i.mu.Unlock()
+		}
+	})
+	i.start(i.runPin)
 	return nil
 }
 
-func (i *irDev) run(b msgbus.Bus, bus *lirc.Conn) {
-	c := bus.Channel()
+func (i *irDev) Close() error {
+	if i.b != nil {
+		i.b.Unsubscribe("learn")
+	}
+	err := i.NodeBase.Close()
+	if i.conn != nil {
+		if err2 := i.conn.Close(); err == nil {
+			err = err2
+		}
+	}
+	if i.pin != nil {
+		if err2 := haltPin(i.pin); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+func (i *irDev) run(b msgbus.Bus, c <-chan ir.Message) {
+	g := irGestures{hold: time.Second, double: 400 * time.Millisecond}
+	if i.Cfg.HoldMS != 0 {
+		g.hold = time.Duration(i.Cfg.HoldMS) * time.Millisecond
+	}
+	if i.Cfg.DoubleMS != 0 {
+		g.double = time.Duration(i.Cfg.DoubleMS) * time.Millisecond
+	}
 	for {
 		select {
+		case <-i.ctx.Done():
+			return
 		case msg, ok := <-c:
 			if !ok {
-				break
+				return
 			}
-			if !msg.Repeat {
-				if err := b.Publish(msgbus.Message{Topic: "ir", Payload: []byte(msg.Key)}, msgbus.ExactlyOnce); err != nil {
+			for _, m := range g.on(msg, time.Now()) {
+				if err := b.Publish(m, msgbus.ExactlyOnce); err != nil {
 					log.Printf("%s: failed to publish: %v", i, err)
 				}
 			}
 		}
 	}
 }
+
+// irGestures derives the repeat, hold and double press events from the lirc
+// key messages.
+type irGestures struct {
+	hold   time.Duration
+	double time.Duration
+
+	key     ir.Key    // Key of the last press.
+	start   time.Time // Start of the last press.
+	last    time.Time // Last message of the last press.
+	repeats int
+	held    bool
+	doubled bool // The last press was the second of a double press.
+}
+
+// on returns the messages to publish for a lirc message received at now.
+func (g *irGestures) on(msg ir.Message, now time.Time) []msgbus.Message {
+	k := string(msg.Key)
+	if msg.Repeat {
+		if msg.Key != g.key || now.Sub(g.last) >= irReleaseGap {
+			// The press was missed.
+			return nil
+		}
+		g.last = now
+		g.repeats++
+		out := irGesture(k, "repeat", strconv.Itoa(g.repeats))
+		if !g.held && now.Sub(g.start) >= g.hold {
+			g.held = true
+			out = append(out, irGesture(k, "hold", k)...)
+		}
+		return out
+	}
+	out := []msgbus.Message{{Topic: "ir", Payload: []byte(k)}}
+	doubled := msg.Key == g.key && !g.doubled && !g.held && now.Sub(g.last) < g.double
+	if doubled {
+		out = append(out, irGesture(k, "double", k)...)
+	}
+	g.key = msg.Key
+	g.start = now
+	g.last = now
+	g.repeats = 0
+	g.held = false
+	g.doubled = doubled
+	return out
+}
+
+// irGesture returns the messages of a gesture on key k.
+//
+// The value is published to "ir/<key>/<gesture>" for the rules, and the key to
+// the "<gesture>" property for Homie and Home Assistant.
+func irGesture(k, gesture, value string) []msgbus.Message {
+	return []msgbus.Message{
+		{Topic: "ir/" + k + "/" + gesture, Payload: []byte(value)},
+		{Topic: gesture, Payload: []byte(k)},
+	}
+}
+
+// runPin decodes the frames received on the pin.
+func (i *irDev) runPin() {
+	for {
+		p := i.readFrame()
+		if p == nil {
+			return
+		}
+		// A lone pulse is noise. A NEC repeat frame has no code.
+		if len(p) < 3 || ircode.IsNECRepeat(p) {
+			continue
+		}
+		i.onCode(ircode.Decode(p))
+	}
+}
+
+// readFrame returns the pulses of the next frame, or nil once the node is
+// closed.
+func (i *irDev) readFrame() ircode.Pulses {
+	var p ircode.Pulses
+	var last time.Time
+	started := false
+	for i.ctx.Err() == nil {
+		timeout := edgePollPeriod
+		if started {
+			timeout = irFrameGap
+		}
+		if !i.pin.WaitForEdge(timeout) {
+			if started {
+				return p
+			}
+			continue
+		}
+		now := i.now()
+		if !started {
+			// A frame starts with a mark.
+			if i.pin.Read() == gpio.Low {
+				started = true
+				last = now
+			}
+			continue
+		}
+		p = append(p, now.Sub(last))
+		last = now
+	}
+	return nil
+}
+
+// onCode publishes a received code, and learns it if requested.
+func (i *irDev) onCode(c ircode.Code) {
+	s := c.String()
+	if err := i.b.Publish(msgbus.Message{Topic: "code", Payload: []byte(s)}, msgbus.ExactlyOnce); err != nil {
+		log.Printf("%s: failed to publish: %v", i, err)
+	}
+	i.mu.Lock()
+	name := i.learning
+	i.learning = ""
+	i.mu.Unlock()
+	if len(name) == 0 {
+		return
+	}
+	log.Printf("%s: learned %s as %s", i, s, name)
+	if err := i.b.Publish(msgbus.Message{Topic: "learned", Payload: []byte(name + "=" + s)}, msgbus.ExactlyOnce); err != nil {
+		log.Printf("%s: failed to publish: %v", i, err)
+	}
+}
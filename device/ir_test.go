@@ -0,0 +1,145 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/ircode"
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/ir"
+)
+
+func TestIRLearn(t *testing.T) {
+	pin := &gpiotestPin{}
+	pin.N = "TEST_IR"
+	pin.EdgesChan = make(chan gpio.Level)
+	if err := gpioreg.Register(pin); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister(pin.Name())
+
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("remote/#", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drain the subscription so the node is never blocked publishing.
+	states := make(chan string, 100)
+	go func() {
+		for msg := range c {
+			states <- msg.Topic + " " + string(msg.Payload)
+		}
+	}()
+	n := &nodes.Node{Name: "Remote", Config: &nodes.IR{Pin: "TEST_IR"}}
+	p, err := genNodeDev("remote", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := ircode.Code{Protocol: ircode.NEC, Value: 0xEF10DF20}
+	pulses := code.Pulses()
+	// A repeat frame of a held key, which must be ignored.
+	repeat := ircode.Pulses{9000 * time.Microsecond, 2250 * time.Microsecond, 560 * time.Microsecond}
+	// The time of each edge.
+	times := make(chan time.Time, len(repeat)+len(pulses)+2)
+	now := time.Now()
+	for _, p := range []ircode.Pulses{repeat, pulses} {
+		now = now.Add(time.Second)
+		times <- now
+		for _, d := range p {
+			now = now.Add(d)
+			times <- now
+		}
+	}
+	i := p.(*irDev)
+	i.now = func() time.Time { return <-times }
+	if err := p.init(msgbus.RebasePub(msgbus.RebaseSub(b, "remote"), "remote")); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if err := b.Publish(msgbus.Message{Topic: "remote/learn", Payload: []byte("tv-power")}, msgbus.ExactlyOnce); err != nil {
+		t.Fatal(err)
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		i.mu.Lock()
+		l := i.learning
+		i.mu.Unlock()
+		if l == "tv-power" {
+			break
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("learn not received")
+		}
+	}
+	// The receiver is active low.
+	for _, n := range []int{len(repeat), len(pulses)} {
+		level := gpio.Low
+		for j := 0; j <= n; j++ {
+			pin.EdgesChan <- level
+			level = !level
+		}
+		// Let the frame end.
+		time.Sleep(5 * irFrameGap)
+	}
+	waitState(t, states, "remote/code nec:0xEF10DF20")
+	waitState(t, states, "remote/learned tv-power=nec:0xEF10DF20")
+	i.mu.Lock()
+	l := i.learning
+	i.mu.Unlock()
+	if l != "" {
+		t.Fatalf("still learning %q", l)
+	}
+}
+
+func TestIRGestures(t *testing.T) {
+	const up = ir.KEY_VOLUMEUP
+	data := []struct {
+		ms       int // Time of the message.
+		key      ir.Key
+		repeat   bool
+		expected []string
+	}{
+		// Held for 1s.
+		{0, up, false, []string{"ir KEY_VOLUMEUP"}},
+		{200, up, true, []string{"ir/KEY_VOLUMEUP/repeat 1", "repeat KEY_VOLUMEUP"}},
+		{400, up, true, []string{"ir/KEY_VOLUMEUP/repeat 2", "repeat KEY_VOLUMEUP"}},
+		{600, up, true, []string{"ir/KEY_VOLUMEUP/repeat 3", "repeat KEY_VOLUMEUP"}},
+		{800, up, true, []string{"ir/KEY_VOLUMEUP/repeat 4", "repeat KEY_VOLUMEUP"}},
+		{1000, up, true, []string{"ir/KEY_VOLUMEUP/repeat 5", "repeat KEY_VOLUMEUP", "ir/KEY_VOLUMEUP/hold KEY_VOLUMEUP", "hold KEY_VOLUMEUP"}},
+		{1200, up, true, []string{"ir/KEY_VOLUMEUP/repeat 6", "repeat KEY_VOLUMEUP"}},
+		// No double press after a long press.
+		{1500, up, false, []string{"ir KEY_VOLUMEUP"}},
+		// The press of a repeat was missed.
+		{1900, up, true, nil},
+		// Double press, then a third press isn't a double press.
+		{5000, up, false, []string{"ir KEY_VOLUMEUP"}},
+		{5300, up, false, []string{"ir KEY_VOLUMEUP", "ir/KEY_VOLUMEUP/double KEY_VOLUMEUP", "double KEY_VOLUMEUP"}},
+		{5600, up, false, []string{"ir KEY_VOLUMEUP"}},
+		// Another key in between.
+		{6000, ir.KEY_MUTE, false, []string{"ir KEY_MUTE"}},
+		{6200, up, false, []string{"ir KEY_VOLUMEUP"}},
+		{6300, ir.KEY_MUTE, true, nil},
+		// Too slow to be a double press.
+		{7000, up, false, []string{"ir KEY_VOLUMEUP"}},
+	}
+	g := irGestures{hold: time.Second, double: 400 * time.Millisecond}
+	now := time.Now()
+	for i, line := range data {
+		var actual []string
+		for _, m := range g.on(ir.Message{Key: line.key, Repeat: line.repeat}, now.Add(time.Duration(line.ms)*time.Millisecond)) {
+			actual = append(actual, m.Topic+" "+string(m.Payload))
+		}
+		if !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("#%d: %q != %q", i, line.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/maruel/dlibox/ircode"
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/physic"
+)
+
+type irTxDev struct {
+	NodeBase
+	Cfg *nodes.IRTx
+
+	b    msgbus.Bus
+	pin  gpio.PinIO
+	wait func(d time.Duration) // Replaced in tests.
+
+	mu     sync.Mutex // Serializes the transmissions.
+	toggle uint32     // RC5 toggle bit of the next transmission.
+}
+
+func (i *irTxDev) init(b msgbus.Bus) error {
+	pin := gpioreg.ByName(i.Cfg.Pin)
+	if pin == nil {
+		return fmt.Errorf("%s: failed to find pin %s", i, i.Cfg.Pin)
+	}
+	if err := pin.Out(gpio.Low); err != nil {
+		return fmt.Errorf("%s: failed to set %s low: %v", i, pin, err)
+	}
+	i.pin = pin
+	if i.wait == nil {
+		i.wait = spin
+	}
+	c, err := b.Subscribe("send", msgbus.ExactlyOnce)
+	if err != nil {
+		return err
+	}
+	i.b = b
+	i.start(func() {
+		for msg := range c {
+			if err := i.send(string(msg.Payload)); err != nil {
+				log.Printf("%s: %v", i, err)
+			}
+		}
+	})
+	return nil
+}
+
+// Close stops listening for commands, turns the LED off and halts the pin.
+//
+// It is safe to call even if init failed midway.
+func (i *irTxDev) Close() error {
+	if i.b != nil {
+		i.b.Unsubscribe("send")
+	}
+	err := i.NodeBase.Close()
+	if i.pin != nil {
+		if err2 := i.pin.Out(gpio.Low); err == nil {
+			err = err2
+		}
+		if err2 := i.pin.Halt(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// send transmits the code named s in Codes, or s itself.
+func (i *irTxDev) send(s string) error {
+	if n, ok := i.Cfg.Codes[s]; ok {
+		s = n
+	}
+	c, err := ircode.Parse(s)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if c.Protocol == ircode.RC5 {
+		// The receivers ignore a repeated toggle bit, as for a held key.
+		c.Value = c.Value&^ircode.RC5Toggle | i.toggle
+		i.toggle ^= ircode.RC5Toggle
+	}
+	return i.transmit(c.Pulses())
+}
+
+// transmit modulates the pulses on the pin.
+func (i *irTxDev) transmit(p ircode.Pulses) error {
+	carrier := i.Cfg.Carrier
+	if carrier == 0 {
+		carrier = 38 * physic.KiloHertz
+	}
+	// Don't let the goroutine be moved during the transmission.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for j, d := range p {
+		var err error
+		if j%2 == 0 {
+			err = i.pin.PWM(gpio.DutyMax/3, carrier)
+		} else {
+			err = i.pin.Out(gpio.Low)
+		}
+		if err != nil {
+			i.pin.Out(gpio.Low)
+			return fmt.Errorf("failed to transmit on %s: %v", i.pin, err)
+		}
+		i.wait(d)
+	}
+	return i.pin.Out(gpio.Low)
+}
+
+// spin waits for d without yielding, since the scheduler is too coarse for
+// the IR timings of a few hundreds µs.
+//
+// TODO(maruel): Use a DMA driven output when periph.io supports one.
+func spin(d time.Duration) {
+	for start := time.Now(); time.Since(start) < d; {
+	}
+}
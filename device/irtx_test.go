@@ -0,0 +1,102 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/ircode"
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/physic"
+)
+
+func TestIRTx(t *testing.T) {
+	pin := &irTxPin{}
+	pin.N = "TEST_IRTX"
+	if err := gpioreg.Register(pin); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister(pin.Name())
+
+	b := msgbus.New()
+	defer b.Close()
+	cfg := &nodes.IRTx{Pin: "TEST_IRTX", Codes: map[string]string{"tv-power": "nec:0xEF10DF20"}}
+	p, err := genNodeDev("tx", &nodes.Node{Name: "Blaster", Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulses := make(chan irPulse, 1000)
+	p.(*irTxDev).wait = func(d time.Duration) {
+		pulses <- irPulse{pin.mark, d}
+	}
+	if err := p.init(msgbus.RebasePub(msgbus.RebaseSub(b, "tx"), "tx")); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []struct {
+		send     string
+		expected ircode.Code
+	}{
+		{"tv-power", ircode.Code{Protocol: ircode.NEC, Value: 0xEF10DF20}},
+		{"rc5:0x300C", ircode.Code{Protocol: ircode.RC5, Value: 0x300C}},
+		// The toggle bit flips on each transmission.
+		{"rc5:0x300C", ircode.Code{Protocol: ircode.RC5, Value: 0x380C}},
+	}
+	for i, line := range data {
+		if err := b.Publish(msgbus.Message{Topic: "tx/send", Payload: []byte(line.send)}, msgbus.BestEffort); err != nil {
+			t.Fatal(err)
+		}
+		for j, d := range line.expected.Pulses() {
+			select {
+			case p := <-pulses:
+				if p.mark != (j%2 == 0) || p.d != d {
+					t.Fatalf("#%d: pulse %d: %v", i, j, p)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("#%d: pulse %d not sent", i, j)
+			}
+		}
+	}
+	pin.Lock()
+	f := pin.F
+	pin.Unlock()
+	if f != 38*physic.KiloHertz {
+		t.Fatal(f)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := pin.Read(); l != gpio.Low {
+		t.Fatalf("expected off after Close, got %s", l)
+	}
+	if !pin.isHalted() {
+		t.Fatal("not halted")
+	}
+}
+
+type irPulse struct {
+	mark bool
+	d    time.Duration
+}
+
+// irTxPin records if the carrier is on.
+type irTxPin struct {
+	gpiotestPin
+	mark bool // Only accessed by the transmitting goroutine.
+}
+
+func (p *irTxPin) PWM(d gpio.Duty, f physic.Frequency) error {
+	p.mark = d != 0
+	return p.gpiotestPin.PWM(d, f)
+}
+
+func (p *irTxPin) Out(l gpio.Level) error {
+	p.mark = false
+	return p.gpiotestPin.Out(l)
+}
@@ -0,0 +1,374 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/maruel/dlibox/nodes"
+	"periph.io/x/periph/conn/display"
+	"periph.io/x/periph/conn/physic"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/devices/apa102"
+	"periph.io/x/periph/experimental/devices/nrzled"
+)
+
+// openSegments opens the physical strips of cfg and stitches them into one
+// logical strip.
+//
+// The Fake strip is drawn to fake.
+func openSegments(cfg *nodes.Anim1D, fake io.Writer) (*segmentedStrip, error) {
+	segs := cfg.Segments
+	if len(segs) == 0 {
+		segs = []nodes.Segment{{SPI: cfg.SPI, NumberLights: cfg.NumberLights}}
+	}
+	out := &segmentedStrip{n: cfg.NumberLights}
+	for _, seg := range segs {
+		s := segment{offset: seg.Offset, n: seg.NumberLights, reversed: seg.Reversed}
+		var p spi.PortCloser
+		if !cfg.Fake {
+			var err error
+			if p, err = spireg.Open(seg.SPI.ID); err != nil {
+				out.Close()
+				return nil, err
+			}
+			s.s = p
+		}
+		var err error
+		if s.d, err = openLEDs(cfg, seg.NumberLights, seg.SPI.Hz, p, fake); err != nil {
+			if p != nil {
+				p.Close()
+			}
+			out.Close()
+			return nil, err
+		}
+		out.segs = append(out.segs, s)
+	}
+	return out, nil
+}
+
+// openLEDs returns the physical LED strip of n lights described by cfg.
+//
+// s is the SPI port to use at speed hz, nil for Fake. The Fake strip is drawn
+// to fake.
+func openLEDs(cfg *nodes.Anim1D, n int, hz physic.Frequency, s spi.PortCloser, fake io.Writer) (display.Drawer, error) {
+	switch {
+	case cfg.APA102:
+		if err := s.LimitSpeed(hz); err != nil {
+			return nil, err
+		}
+		opts := apa102.DefaultOpts
+		opts.NumPixels = n
+		opts.Temperature = 6500
+		return apa102.New(s, &opts)
+	case cfg.WS2812B:
+		order := cfg.ColorOrder
+		if len(order) == 0 {
+			order = "GRB"
+		}
+		return newNRZStrip(s, n, order, false)
+	case cfg.SK6812:
+		order := cfg.ColorOrder
+		if len(order) == 0 {
+			order = "GRBW"
+		}
+		return newNRZStrip(s, n, order, cfg.WhiteFromRGB)
+	case cfg.Fake:
+		return newTermStrip(fake, n), nil
+	default:
+		return nil, errors.New("anim1d: no chipset selected")
+	}
+}
+
+// segmentedStrip is a logical LED strip made of physical strips.
+type segmentedStrip struct {
+	n    int // Number of lights in the logical strip.
+	segs []segment
+}
+
+// segment is a physical LED strip, part of a segmentedStrip.
+type segment struct {
+	d        display.Drawer // Also implements io.Writer.
+	s        io.Closer      // SPI port, nil for Fake.
+	offset   int
+	n        int
+	reversed bool
+	buf      []byte // Only used when reversed.
+}
+
+func (s *segmentedStrip) String() string {
+	names := make([]string, 0, len(s.segs))
+	for _, seg := range s.segs {
+		names = append(names, fmt.Sprintf("%s", seg.d))
+	}
+	return strings.Join(names, "+")
+}
+
+// Halt turns all the lights off.
+func (s *segmentedStrip) Halt() error {
+	var err error
+	for _, seg := range s.segs {
+		if err2 := seg.d.Halt(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// Close releases the SPI ports.
+func (s *segmentedStrip) Close() error {
+	var err error
+	for _, seg := range s.segs {
+		if seg.s != nil {
+			if err2 := seg.s.Close(); err == nil {
+				err = err2
+			}
+		}
+	}
+	return err
+}
+
+// ColorModel implements display.Drawer.
+func (s *segmentedStrip) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+// Bounds implements display.Drawer.
+func (s *segmentedStrip) Bounds() image.Rectangle {
+	return image.Rect(0, 0, s.n, 1)
+}
+
+// Draw implements display.Drawer.
+func (s *segmentedStrip) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	_, err := s.Write(imageToRGB(s.n, r, src, sp))
+	return err
+}
+
+// Write accepts a stream of RGB pixels for the logical strip and writes the
+// part of each segment to it.
+func (s *segmentedStrip) Write(pixels []byte) (int, error) {
+	if len(pixels)%3 != 0 || len(pixels) > 3*s.n {
+		return 0, errors.New("anim1d: invalid RGB stream length")
+	}
+	for i := range s.segs {
+		seg := &s.segs[i]
+		start := 3 * seg.offset
+		if start >= len(pixels) {
+			continue
+		}
+		end := 3 * (seg.offset + seg.n)
+		if end > len(pixels) {
+			end = len(pixels)
+		}
+		p := pixels[start:end]
+		if seg.reversed {
+			if len(seg.buf) < len(p) {
+				seg.buf = make([]byte, 3*seg.n)
+			}
+			for j := 0; j < len(p); j += 3 {
+				k := len(p) - 3 - j
+				copy(seg.buf[k:k+3], p[j:j+3])
+			}
+			p = seg.buf[:len(p)]
+		}
+		if _, err := seg.d.(io.Writer).Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return len(pixels), nil
+}
+
+// nrzStrip drives NRZ LEDs like the WS2812B and the SK6812 over SPI.
+//
+// It takes the RGB pixels rendered by anim1d and converts them to the channel
+// order of the LEDs. The intensity is applied in software since the LEDs
+// don't support it.
+type nrzStrip struct {
+	d     *nrzled.Dev
+	n     int   // Number of pixels.
+	order []int // Index in RGBW of each channel on the wire.
+	white bool  // Derive the white channel from RGB.
+	buf   []byte
+
+	// Intensity is the maximum brightness, 255 being full brightness.
+	Intensity uint8
+}
+
+// newNRZStrip returns a strip of n NRZ LEDs.
+//
+// order is the channel order on the wire; when it includes 'W' the LEDs are
+// RGBW. white derives the white channel from RGB.
+func newNRZStrip(s spi.Port, n int, order string, white bool) (*nrzStrip, error) {
+	idx := make([]int, len(order))
+	for i, c := range order {
+		if idx[i] = strings.IndexRune("RGBW", c); idx[i] == -1 {
+			return nil, fmt.Errorf("anim1d: invalid ColorOrder %q", order)
+		}
+	}
+	// The SPI encoder of nrzled ignores the channel order and the white channel,
+	// so the bytes are sent as a raw stream of 3 bytes "pixels" already in wire
+	// order.
+	raw := (n*len(order) + 2) / 3
+	opts := nrzled.Opts{NumPixels: raw, Channels: 3, Freq: 2500 * physic.KiloHertz}
+	d, err := nrzled.NewSPI(s, &opts)
+	if err != nil {
+		return nil, err
+	}
+	return &nrzStrip{
+		d:         d,
+		n:         n,
+		order:     idx,
+		white:     white,
+		buf:       make([]byte, 3*raw),
+		Intensity: 255,
+	}, nil
+}
+
+func (n *nrzStrip) String() string {
+	return n.d.String()
+}
+
+// Halt turns the lights off.
+func (n *nrzStrip) Halt() error {
+	return n.d.Halt()
+}
+
+// ColorModel implements display.Drawer.
+func (n *nrzStrip) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+// Bounds implements display.Drawer.
+func (n *nrzStrip) Bounds() image.Rectangle {
+	return image.Rect(0, 0, n.n, 1)
+}
+
+// Draw implements display.Drawer.
+func (n *nrzStrip) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	_, err := n.Write(imageToRGB(n.n, r, src, sp))
+	return err
+}
+
+// Write accepts a stream of RGB pixels.
+func (n *nrzStrip) Write(pixels []byte) (int, error) {
+	if len(pixels)%3 != 0 || len(pixels) > 3*n.n {
+		return 0, errors.New("anim1d: invalid RGB stream length")
+	}
+	var rgbw [4]byte
+	j := 0
+	for i := 0; i < len(pixels); i += 3 {
+		rgbw[0] = scale(pixels[i], n.Intensity)
+		rgbw[1] = scale(pixels[i+1], n.Intensity)
+		rgbw[2] = scale(pixels[i+2], n.Intensity)
+		rgbw[3] = 0
+		if n.white {
+			w := min3(rgbw[0], rgbw[1], rgbw[2])
+			rgbw[0] -= w
+			rgbw[1] -= w
+			rgbw[2] -= w
+			rgbw[3] = w
+		}
+		for _, k := range n.order {
+			n.buf[j] = rgbw[k]
+			j++
+		}
+	}
+	for ; j < len(n.buf); j++ {
+		n.buf[j] = 0
+	}
+	if _, err := n.d.Write(n.buf); err != nil {
+		return 0, err
+	}
+	return len(pixels), nil
+}
+
+// termStrip draws the LED strip as a line of colored blocks in an ANSI
+// terminal supporting 24 bits colors.
+type termStrip struct {
+	w   io.Writer
+	n   int // Number of pixels.
+	buf []byte
+
+	// Intensity is the maximum brightness, 255 being full brightness.
+	Intensity uint8
+}
+
+func newTermStrip(w io.Writer, n int) *termStrip {
+	return &termStrip{w: w, n: n, Intensity: 255}
+}
+
+func (t *termStrip) String() string {
+	return "Fake"
+}
+
+// Halt implements display.Drawer.
+func (t *termStrip) Halt() error {
+	return nil
+}
+
+// ColorModel implements display.Drawer.
+func (t *termStrip) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+// Bounds implements display.Drawer.
+func (t *termStrip) Bounds() image.Rectangle {
+	return image.Rect(0, 0, t.n, 1)
+}
+
+// Draw implements display.Drawer.
+func (t *termStrip) Draw(r image.Rectangle, src image.Image, sp image.Point) error {
+	_, err := t.Write(imageToRGB(t.n, r, src, sp))
+	return err
+}
+
+// Write accepts a stream of RGB pixels and redraws the line.
+func (t *termStrip) Write(pixels []byte) (int, error) {
+	if len(pixels)%3 != 0 || len(pixels) > 3*t.n {
+		return 0, errors.New("anim1d: invalid RGB stream length")
+	}
+	t.buf = append(t.buf[:0], '\r')
+	for i := 0; i < len(pixels); i += 3 {
+		t.buf = append(t.buf, fmt.Sprintf("\033[48;2;%d;%d;%dm ", scale(pixels[i], t.Intensity), scale(pixels[i+1], t.Intensity), scale(pixels[i+2], t.Intensity))...)
+	}
+	t.buf = append(t.buf, "\033[0m"...)
+	if _, err := t.w.Write(t.buf); err != nil {
+		return 0, err
+	}
+	return len(pixels), nil
+}
+
+// imageToRGB converts the row of src at sp to a stream of RGB pixels for a strip
+// of n pixels, drawn at r.
+func imageToRGB(n int, r image.Rectangle, src image.Image, sp image.Point) []byte {
+	out := make([]byte, 3*n)
+	r = r.Intersect(image.Rect(0, 0, n, 1))
+	for x := r.Min.X; x < r.Max.X; x++ {
+		c := color.NRGBAModel.Convert(src.At(sp.X+x-r.Min.X, sp.Y)).(color.NRGBA)
+		out[3*x], out[3*x+1], out[3*x+2] = c.R, c.G, c.B
+	}
+	return out
+}
+
+// scale returns v scaled by intensity.
+func scale(v, intensity uint8) uint8 {
+	return uint8((uint16(v)*uint16(intensity) + 127) / 255)
+}
+
+func min3(a, b, c uint8) uint8 {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/maruel/dlibox/nodes"
+	"periph.io/x/periph/conn/spi/spitest"
+)
+
+func TestNRZStrip(t *testing.T) {
+	data := []struct {
+		cfg      nodes.Anim1D
+		expected []byte
+	}{
+		// GRB by default.
+		{nodes.Anim1D{WS2812B: true, NumberLights: 2}, []byte{0x20, 0x10, 0x30, 0x02, 0x01, 0x03}},
+		{nodes.Anim1D{WS2812B: true, NumberLights: 2, ColorOrder: "BRG"}, []byte{0x30, 0x10, 0x20, 0x03, 0x01, 0x02}},
+		// The white channel is off, padded to a multiple of 3 bytes.
+		{nodes.Anim1D{SK6812: true, NumberLights: 2}, []byte{0x20, 0x10, 0x30, 0, 0x02, 0x01, 0x03, 0, 0}},
+		{nodes.Anim1D{SK6812: true, NumberLights: 2, WhiteFromRGB: true}, []byte{0x10, 0, 0x20, 0x10, 0x01, 0, 0x02, 0x01, 0}},
+	}
+	for i, line := range data {
+		r := &spitest.Record{}
+		d, err := openLEDs(&line.cfg, line.cfg.NumberLights, 0, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.(*nrzStrip).Write([]byte{0x10, 0x20, 0x30, 0x01, 0x02, 0x03}); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.Ops) != 1 {
+			t.Fatalf("#%d: %v", i, r.Ops)
+		}
+		if actual := decodeNRZ(r.Ops[0].W); !bytes.Equal(line.expected, actual) {
+			t.Fatalf("#%d: %#v != %#v", i, line.expected, actual)
+		}
+	}
+}
+
+func TestNRZStripIntensity(t *testing.T) {
+	r := &spitest.Record{}
+	d, err := openLEDs(&nodes.Anim1D{WS2812B: true, ColorOrder: "RGB"}, 1, 0, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.(*nrzStrip).Intensity = 128
+	if _, err := d.(*nrzStrip).Write([]byte{0xFF, 0x80, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := decodeNRZ(r.Ops[0].W); !bytes.Equal([]byte{0x80, 0x40, 0}, actual) {
+		t.Fatalf("%#v", actual)
+	}
+}
+
+func TestTermStrip(t *testing.T) {
+	b := bytes.Buffer{}
+	d, err := openLEDs(&nodes.Anim1D{Fake: true}, 2, 0, nil, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.(*termStrip).Write([]byte{0xFF, 0, 0, 0, 0x80, 0}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "\r\033[48;2;255;0;0m \033[48;2;0;128;0m \033[0m"
+	if s := b.String(); s != expected {
+		t.Fatalf("%q != %q", expected, s)
+	}
+}
+
+func TestSegmentedStrip(t *testing.T) {
+	var a, b bytes.Buffer
+	s := segmentedStrip{
+		n: 4,
+		segs: []segment{
+			{d: newTermStrip(&a, 2), n: 2},
+			{d: newTermStrip(&b, 2), offset: 2, n: 2, reversed: true},
+		},
+	}
+	if _, err := s.Write([]byte{1, 1, 1, 2, 2, 2, 3, 3, 3, 4, 4, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if e := "\r\033[48;2;1;1;1m \033[48;2;2;2;2m \033[0m"; a.String() != e {
+		t.Fatalf("%q != %q", e, a.String())
+	}
+	if e := "\r\033[48;2;4;4;4m \033[48;2;3;3;3m \033[0m"; b.String() != e {
+		t.Fatalf("%q != %q", e, b.String())
+	}
+}
+
+// decodeNRZ decodes the NRZ SPI stream of the nrzled package, where each bit
+// is a nibble and the stream is terminated by 3 latch bytes.
+func decodeNRZ(w []byte) []byte {
+	w = w[:len(w)-3]
+	out := make([]byte, len(w)/4)
+	for i := range out {
+		for _, b := range w[4*i : 4*i+4] {
+			out[i] <<= 2
+			if b&0xF0 == 0xE0 {
+				out[i] |= 2
+			}
+			if b&0x0F == 0x0E {
+				out[i] |= 1
+			}
+		}
+	}
+	return out
+}
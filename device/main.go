@@ -23,10 +23,13 @@ import (
 )
 
 // Main is the main function when running as a device (a node).
-func Main(server string, bus msgbus.Bus, port int) error {
-	log.Printf("device.Main(%s, ..., %d)", server, port)
+//
+// If homie is true, the device is also exposed as a Homie device.
+func Main(server string, bus msgbus.Bus, port int, homie bool) error {
+	log.Printf("device.Main(%s, ..., %d, %t)", server, port, homie)
 
 	// Everything is under the namespace "dlibox/"
+	rawBus := bus
 	bus = msgbus.RebasePub(msgbus.RebaseSub(bus, "dlibox"), "dlibox")
 	root := shared.Hostname()
 	dbus := msgbus.RebaseSub(msgbus.RebasePub(bus, root), root)
@@ -94,15 +97,27 @@ done:
 		pubErr(dbus, "failed to initialize: %v", err)
 		return err
 	}
-	d := dev{nodes: map[nodes.ID]nodeDev{}}
-	for id, n := range cfg.Nodes {
-		n, err := genNodeDev(id, n)
-		if err != nil {
-			pubErr(dbus, "failed to initialize: unknown node %q: %v", id, err)
-			return fmt.Errorf("unknown node %q: %v", id, err)
+	d := dev{}
+	if homie {
+		if d.homie, err = newHomieBridge(rawBus, dbus, root); err != nil {
+			pubErr(dbus, "failed to initialize: %v", err)
+			return err
 		}
-		d.nodes[id] = n
+		defer d.homie.Close()
+	}
+	defer d.Close()
+	if _, err := d.reconfigure(dbus, cfg); err != nil {
+		// The nodes that failed are retried when the configuration is published
+		// again, the other ones are running.
+		pubErr(dbus, "failed to initialize: %v", err)
+	}
+	w, err := watchConfig(dbus, &d)
+	if err != nil {
+		pubErr(dbus, "failed to initialize: %v", err)
+		return err
 	}
+	// Stop reconfiguring before closing the nodes.
+	defer w.Close()
 
 	if !interrupt.IsSet() {
 		shared.RetainedStr(dbus, "$online", "true")
@@ -110,13 +125,17 @@ done:
 	return shared.WatchFile()
 }
 
+// getConfig retrieves the device configuration published by the controller
+// with nodes.SerializedDev.Publish().
+//
+// b must be rebased on the device ID.
 func getConfig(b msgbus.Bus) (*nodes.Dev, error) {
 	msgs, err := msgbus.Retained(b, 10*time.Second, "$name", "$nodes")
 	if err != nil {
 		return nil, err
 	}
-	nds := nodes.SerializedDev{Name: string(msgs["$name"])}
-	nodesID := string(msgs["nodes"])
+	nds := nodes.SerializedDev{Name: string(msgs["$name"]), Nodes: map[nodes.ID]*nodes.SerializedNode{}}
+	nodesID := string(msgs["$nodes"])
 	if len(nodesID) != 0 {
 		for _, id := range strings.Split(nodesID, ",") {
 			nodeID := nodes.ID(id)
@@ -125,47 +144,42 @@ func getConfig(b msgbus.Bus) (*nodes.Dev, error) {
 			}
 			// TODO(maruel): Query all nodes concurrently to reduce the effect of round
 			// trip latency.
-			n, err := processNode(b, id)
+			n, err := processNode(msgbus.RebaseSub(b, id))
 			if err != nil {
 				return nil, fmt.Errorf("node %q: %v", nodeID, err)
 			}
 			nds.Nodes[nodeID] = n
 		}
 	}
-	return nds.ToDev()
+	d, err := nds.ToDev()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
-func processNode(b msgbus.Bus, nodeID string) (*nodes.SerializedNode, error) {
-	msgs, err := msgbus.Retained(b, 10*time.Second, "$name", "$properties", "$types")
+// processNode retrieves a node configuration.
+//
+// b must be rebased on the node ID. The properties are not retrieved since
+// they are derived from $config.
+func processNode(b msgbus.Bus) (*nodes.SerializedNode, error) {
+	msgs, err := msgbus.Retained(b, 10*time.Second, "$name", "$type", "$config")
 	if err != nil {
 		return nil, err
 	}
-
 	n := &nodes.SerializedNode{
-		Name: string(msgs["$name"]),
-		Type: nodes.Type(string(msgs["$type"])),
+		Name:   string(msgs["$name"]),
+		Type:   nodes.Type(msgs["$type"]),
+		Config: msgs["$config"],
 	}
 	if err := n.Type.Validate(); err != nil {
 		return nil, fmt.Errorf("node %q: %v", n.Name, err)
 	}
-
-	// TODO(maruel): Query concurrently.
-	propnames := strings.Split(string(msgs["$properties"]), ",")
-	for _, propname := range propnames {
-		propID := nodes.ID(propname)
-		if err := propID.Validate(); err != nil {
-			return nil, fmt.Errorf("invalid property %s/%s: %v", nodeID, propID, err)
-		}
-		pm, err := msgbus.Retained(b, 10*time.Second, "$datatype", "$format", "$settable", "$unit")
-		if err != nil {
-			return nil, err
-		}
-		n.Properties[propID] = nodes.Property{
-			Unit:     string(pm["$unit"]),
-			DataType: string(pm["$datatype"]),
-			Format:   string(pm["$format"]),
-			Settable: string(pm["$settable"]) == "true",
-		}
+	if len(n.Config) == 0 {
+		return nil, fmt.Errorf("node %q: missing $config", n.Name)
 	}
 	return n, nil
 }
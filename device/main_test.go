@@ -8,8 +8,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/dlibox/shared"
 	"github.com/maruel/interrupt"
 	"github.com/maruel/msgbus"
@@ -24,17 +27,130 @@ func TestMain(t *testing.T) {
 	shared.RetainedStr(b, "dlibox/$online", "true")
 	d := msgbus.RebasePub(b, "dlibox/"+shared.Hostname())
 	shared.RetainedStr(d, "reset", "true")
-	shared.RetainedStr(d, "$name", "foo")
-	shared.RetainedStr(d, "$nodes", "node1")
+	cfg := &nodes.Dev{
+		Name:  "foo",
+		Nodes: map[nodes.ID]*nodes.Node{"node1": {Name: "The node", Config: &nodes.Sound{}}},
+	}
+	cfg.ToSerialized().Publish(d)
 	shared.RetainedStr(d, "$ignored", "really")
-	shared.RetainedStr(d, "node1", "true")
-	shared.RetainedStr(d, "node1/$name", "The node")
-	shared.RetainedStr(d, "node1/$type", "button")
-	shared.RetainedStr(d, "node1/$unit", ".")
-	shared.RetainedStr(d, "node1/$datatype", "bool")
-	shared.RetainedStr(d, "node1/$format", ".")
-	shared.RetainedStr(d, "node1/$settable", "false")
-	//retained(d, "node1/$", "button")
 	interrupt.Set()
-	Main("", b, 0)
+	Main("", b, 0, true)
+}
+
+func TestMain_NodeError(t *testing.T) {
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	b := msgbus.New()
+	defer b.Close()
+	shared.RetainedStr(b, "dlibox/$online", "true")
+	d := msgbus.RebasePub(b, "dlibox/"+shared.Hostname())
+	shared.RetainedStr(d, "reset", "true")
+	// The pin doesn't exist, the node is retried when the configuration is
+	// published again.
+	cfg := &nodes.Dev{
+		Name: "foo",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"button": {Name: "Button", Config: &nodes.Button{Pin: "TEST_MISSING"}},
+			"sound":  {Name: "Speaker", Config: &nodes.Sound{}},
+		},
+	}
+	cfg.ToSerialized().Publish(d)
+	interrupt.Set()
+	if err := Main("", b, 0, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	// The controller publishes the configuration under the device ID, the
+	// device reads it back.
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "dlibox/dev1"), "dlibox/dev1")
+	expected := &nodes.Dev{
+		Name: "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"pir1":   {Name: "Motion", Config: &nodes.PIR{Pin: "GPIO4"}},
+			"sound1": {Name: "Speaker", Config: &nodes.Sound{DeviceID: "hw:1"}},
+		},
+	}
+	expected.ToSerialized().Publish(d)
+	if msgs, err := msgbus.Retained(d, time.Second, "pir1/pir/$datatype"); err != nil || string(msgs["pir1/pir/$datatype"]) != "boolean" {
+		t.Fatalf("property not published: %v %v", msgs, err)
+	}
+
+	actual, err := getConfig(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%#v != %#v", expected, actual)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "dlibox/dev1"), "dlibox/dev1")
+	cfg := &nodes.Dev{
+		Name: "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"sound1": {Name: "Speaker", Config: &nodes.Sound{}},
+			"sound2": {Name: "Speaker", Config: &nodes.Sound{DeviceID: "hw:1"}},
+		},
+	}
+	cfg.ToSerialized().Publish(d)
+	dv := dev{}
+	if ids, err := dv.reconfigure(d, cfg); err != nil || len(ids) != 2 {
+		t.Fatal(ids, err)
+	}
+	sound1 := dv.nodes["sound1"]
+	online, err := d.Subscribe("$online", msgbus.BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := watchConfig(d, &dv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The local bus doesn't guarantee ordering, so wait for the retained
+	// configuration to be received before changing it.
+	for start := time.Now(); w.config() == nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("configuration not received")
+		}
+	}
+
+	// sound1 is unchanged, sound2 is removed and sound3 is added.
+	cfg = &nodes.Dev{
+		Name: "Living room",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"sound1": {Name: "Speaker", Config: &nodes.Sound{}},
+			"sound3": {Name: "Speaker", Config: &nodes.Sound{DeviceID: "hw:2"}},
+		},
+	}
+	cfg.ToSerialized().Publish(d)
+	for _, s := range []string{"reconfiguring", "true"} {
+		for done := false; !done; {
+			select {
+			case msg := <-online:
+				done = string(msg.Payload) == s
+			case <-time.After(5 * time.Second):
+				t.Fatalf("didn't get $online %q", s)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dv.mu.Lock()
+	if len(dv.nodes) != 2 || dv.nodes["sound1"] != sound1 || dv.nodes["sound3"] == nil {
+		t.Fatalf("unexpected nodes %v", dv.nodes)
+	}
+	dv.mu.Unlock()
+	if err := dv.Close(); err != nil {
+		t.Fatal(err)
+	}
 }
@@ -5,30 +5,61 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"reflect"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/maruel/dlibox/nodes"
 	"github.com/maruel/msgbus"
 )
 
+// closeTimeout is the maximum duration to wait for nodes to close.
+const closeTimeout = 5 * time.Second
+
 // NodeBase is the base type for all kind of supported nodes.
+//
+// It manages the lifecycle of the node's goroutines: ctx is canceled upon
+// Close, which then waits for all the goroutines started with start() to
+// return.
 type NodeBase struct {
 	id   nodes.ID
 	name string
 	typ  nodes.Type
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 func (n *NodeBase) String() string {
 	return fmt.Sprintf("%s(%s/%s)", n.typ, n.id, n.name)
 }
 
-// Close is the default implementation that does nothing.
+// Close is the default implementation that cancels the context and waits for
+// the goroutines to return.
+//
+// Nodes holding resources must release them after the goroutines returned.
 func (n *NodeBase) Close() error {
+	n.cancel()
+	n.wg.Wait()
 	return nil
 }
 
+// start runs f in a goroutine that Close waits for.
+func (n *NodeBase) start(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
 func (n *NodeBase) wrap(err error) error {
 	return fmt.Errorf("%s: %v", n, err)
 }
@@ -43,16 +74,142 @@ type nodeDev interface {
 //
 // The device doesn't store it, it's stored on the MQTT server.
 type dev struct {
+	homie *homieBridge // Optional.
+
+	mu    sync.Mutex
+	cfg   *nodes.Dev
 	nodes map[nodes.ID]nodeDev
 }
 
-func (d *dev) init(b msgbus.Bus) error {
+// hasChanges returns true if cfg differs from the running configuration.
+func (d *dev) hasChanges(cfg *nodes.Dev) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.changedLocked(cfg)) != 0
+}
+
+// changedLocked returns the IDs of the nodes that were removed, added or
+// changed in cfg.
+func (d *dev) changedLocked(cfg *nodes.Dev) []nodes.ID {
+	var ids []nodes.ID
+	for id, n := range cfg.Nodes {
+		if d.cfg == nil || !reflect.DeepEqual(d.cfg.Nodes[id], n) {
+			ids = append(ids, id)
+		}
+	}
+	if d.cfg != nil {
+		for id := range d.cfg.Nodes {
+			if _, ok := cfg.Nodes[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// reconfigure replaces the nodes that were removed, added or changed in cfg.
+//
+// The unchanged nodes are left running. Returns the IDs of the nodes that were
+// replaced.
+func (d *dev) reconfigure(b msgbus.Bus, cfg *nodes.Dev) ([]nodes.ID, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ids := d.changedLocked(cfg)
+	// Create the new nodes first, so an invalid configuration doesn't affect
+	// the running nodes.
+	added := map[nodes.ID]nodeDev{}
+	for _, id := range ids {
+		if n := cfg.Nodes[id]; n != nil {
+			nd, err := genNodeDev(id, n)
+			if err != nil {
+				var all []nodeDev
+				for _, a := range added {
+					all = append(all, a)
+				}
+				if err2 := closeNodes(all); err2 != nil {
+					log.Printf("%v", err2)
+				}
+				return nil, fmt.Errorf("unknown node %q: %v", id, err)
+			}
+			added[id] = nd
+		}
+	}
+	if d.nodes == nil {
+		d.nodes = map[nodes.ID]nodeDev{}
+	}
+	var old []nodeDev
+	for _, id := range ids {
+		if n := d.nodes[id]; n != nil {
+			old = append(old, n)
+			delete(d.nodes, id)
+		}
+	}
+	err := closeNodes(old)
+	// The nodes that failed to initialize are not recorded in d.cfg, so they are
+	// retried the next time the configuration is published.
+	running := &nodes.Dev{Name: cfg.Name, Nodes: make(map[nodes.ID]*nodes.Node, len(cfg.Nodes))}
+	for id, n := range cfg.Nodes {
+		running.Nodes[id] = n
+	}
+	for _, id := range ids {
+		if n := added[id]; n != nil {
+			s := string(id)
+			if err2 := n.init(msgbus.RebasePub(msgbus.RebaseSub(b, s), s)); err2 != nil {
+				if err == nil {
+					err = err2
+				}
+				if err2 := n.Close(); err2 != nil {
+					log.Printf("%s: %v", n, err2)
+				}
+				delete(running.Nodes, id)
+				continue
+			}
+			d.nodes[id] = n
+		}
+	}
+	d.cfg = running
+	if d.homie != nil {
+		d.homie.publish(cfg)
+	}
+	return ids, err
+}
+
+// Close closes all the nodes.
+func (d *dev) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var all []nodeDev
+	for _, n := range d.nodes {
+		all = append(all, n)
+	}
+	d.nodes = nil
+	d.cfg = nil
+	return closeNodes(all)
+}
+
+// closeNodes closes the nodes in parallel, waiting at most closeTimeout.
+func closeNodes(all []nodeDev) error {
+	errs := make(chan error, len(all))
+	for _, n := range all {
+		go func(n nodeDev) {
+			if err := n.Close(); err != nil {
+				errs <- fmt.Errorf("%s: %v", n, err)
+				return
+			}
+			errs <- nil
+		}(n)
+	}
+	timeout := time.After(closeTimeout)
 	var err error
-	for id, n := range d.nodes {
-		s := string(id)
-		bdev := msgbus.RebasePub(msgbus.RebaseSub(b, s), s)
-		if err2 := n.init(bdev); err == nil {
-			err = err2
+	for range all {
+		select {
+		case err2 := <-errs:
+			if err == nil {
+				err = err2
+			}
+		case <-timeout:
+			return errors.New("timed out closing the nodes")
 		}
 	}
 	return err
@@ -63,7 +220,11 @@ var knownTypes = map[interface{}]interface{}{
 	&nodes.Button{}:  &buttonDev{},
 	&nodes.Display{}: &displayDev{},
 	&nodes.IR{}:      &irDev{},
+	&nodes.IRTx{}:    &irTxDev{},
 	&nodes.PIR{}:     &pirDev{},
+	&nodes.PWM{}:     &pwmDev{},
+	&nodes.Relay{}:   &relayDev{},
+	&nodes.Sensor{}:  &sensorDev{},
 	&nodes.Sound{}:   &soundDev{},
 }
 
@@ -97,7 +258,8 @@ func genNodeDev(id nodes.ID, n *nodes.Node) (nodeDev, error) {
 	}
 	v := reflect.New(r)
 	e := v.Elem()
-	e.Field(0).Set(reflect.ValueOf(NodeBase{id: id, name: n.Name, typ: n.Type()}))
+	ctx, cancel := context.WithCancel(context.Background())
+	e.Field(0).Set(reflect.ValueOf(NodeBase{id: id, name: n.Name, typ: n.Type(), ctx: ctx, cancel: cancel}))
 	e.Field(1).Set(reflect.ValueOf(n.Config))
 	/*
 		switch v := n.Config.(type) {
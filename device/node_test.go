@@ -5,9 +5,20 @@
 package device
 
 import (
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/gpio/gpiotest"
+	"periph.io/x/periph/conn/physic"
+	"periph.io/x/periph/conn/spi"
+	"periph.io/x/periph/conn/spi/spireg"
+	"periph.io/x/periph/conn/spi/spitest"
 )
 
 func TestGetNodeDev(t *testing.T) {
@@ -23,3 +34,179 @@ func TestGetNodeDev(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDevClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	button := &gpiotestPin{}
+	button.N = "TEST_BUTTON"
+	button.EdgesChan = make(chan gpio.Level)
+	pir := &gpiotestPin{}
+	pir.N = "TEST_PIR"
+	pir.EdgesChan = make(chan gpio.Level)
+	for _, p := range []gpio.PinIO{button, pir} {
+		if err := gpioreg.Register(p); err != nil {
+			t.Fatal(err)
+		}
+		defer gpioreg.Unregister(p.Name())
+	}
+	port := &spiPort{}
+	if err := spireg.Register("TEST_SPI", nil, -1, func() (spi.PortCloser, error) { return port, nil }); err != nil {
+		t.Fatal(err)
+	}
+	defer spireg.Unregister("TEST_SPI")
+
+	b := msgbus.New()
+	cfg := &nodes.Dev{
+		Name: "dev",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"anim1d": {Name: "Lights", Config: &nodes.Anim1D{APA102: true, SPI: nodes.SPIRef{ID: "TEST_SPI", Hz: physic.MegaHertz}, NumberLights: 10, FPS: 60}},
+			"button": {Name: "Button", Config: &nodes.Button{Pin: "TEST_BUTTON"}},
+			"pir":    {Name: "Motion", Config: &nodes.PIR{Pin: "TEST_PIR"}},
+			"sound":  {Name: "Speaker", Config: &nodes.Sound{}},
+		},
+	}
+	d := dev{}
+	if _, err := d.reconfigure(b, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// The goroutines are waiting for edges.
+	for _, p := range []*gpiotestPin{button, pir} {
+		select {
+		case p.EdgesChan <- gpio.High:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: edge not consumed", p)
+		}
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []*gpiotestPin{button, pir} {
+		if !p.isHalted() {
+			t.Fatalf("%s: not halted", p)
+		}
+	}
+	if !port.isClosed() {
+		t.Fatal("SPI port not closed")
+	}
+	// The bus publishes asynchronously, give it a moment to settle.
+	for start := time.Now(); runtime.NumGoroutine() > before; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("leaked %d goroutines:\n%s", runtime.NumGoroutine()-before, buf[:runtime.Stack(buf, true)])
+		}
+	}
+}
+
+func TestReconfigure_Retry(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	cfg := &nodes.Dev{
+		Name: "dev",
+		Nodes: map[nodes.ID]*nodes.Node{
+			"button": {Name: "Button", Config: &nodes.Button{Pin: "TEST_RETRY"}},
+			"sound":  {Name: "Speaker", Config: &nodes.Sound{}},
+		},
+	}
+	d := dev{}
+	defer d.Close()
+	// The pin doesn't exist yet.
+	if _, err := d.reconfigure(b, cfg); err == nil {
+		t.Fatal("expected error")
+	}
+	if d.nodes["button"] != nil || d.nodes["sound"] == nil {
+		t.Fatalf("unexpected nodes %v", d.nodes)
+	}
+	if !d.hasChanges(cfg) {
+		t.Fatal("the failed node must be retried")
+	}
+
+	p := &gpiotestPin{}
+	p.N = "TEST_RETRY"
+	p.EdgesChan = make(chan gpio.Level)
+	if err := gpioreg.Register(p); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister(p.Name())
+	ids, err := d.reconfigure(b, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "button" || d.nodes["button"] == nil {
+		t.Fatalf("unexpected reconfiguration %v %v", ids, d.nodes)
+	}
+	if d.hasChanges(cfg) {
+		t.Fatal("unexpected changes")
+	}
+}
+
+// gpiotestPin is a gpiotest.Pin that records that it was halted.
+type gpiotestPin struct {
+	gpiotest.Pin
+	halted bool
+}
+
+func (p *gpiotestPin) Halt() error {
+	p.Lock()
+	defer p.Unlock()
+	p.halted = true
+	return nil
+}
+
+func (p *gpiotestPin) isHalted() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.halted
+}
+
+// spiPort is a spitest.Record that records that it was closed.
+type spiPort struct {
+	spitest.Record
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *spiPort) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.Record.Close()
+}
+
+func (s *spiPort) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func TestReconfigure_Same(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	// Each published configuration is decoded anew.
+	newCfg := func() *nodes.Dev {
+		return &nodes.Dev{
+			Name: "dev",
+			Nodes: map[nodes.ID]*nodes.Node{
+				"screen": {Name: "Screen", Config: &nodes.Display{
+					PNG: true, W: 24, H: 8,
+					Widgets: []nodes.Widget{{Kind: nodes.WidgetClock}},
+				}},
+			},
+		}
+	}
+	d := dev{}
+	defer d.Close()
+	if _, err := d.reconfigure(b, newCfg()); err != nil {
+		t.Fatal(err)
+	}
+	n := d.nodes["screen"]
+	ids, err := d.reconfigure(b, newCfg())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 || d.nodes["screen"] != n {
+		t.Fatalf("the display was recreated: %v", ids)
+	}
+}
@@ -18,6 +18,8 @@ import (
 type pirDev struct {
 	NodeBase
 	Cfg *nodes.PIR
+
+	pin gpio.PinIn
 }
 
 func (p *pirDev) init(b msgbus.Bus) error {
@@ -28,13 +30,26 @@ func (p *pirDev) init(b msgbus.Bus) error {
 	if err := pin.In(gpio.PullDown, gpio.BothEdges); err != nil {
 		return fmt.Errorf("%s: failed to pull down %s: %v", p, pin, err)
 	}
-	go p.run(b, pin)
+	p.pin = pin
+	p.start(func() { p.run(b, pin) })
 	return nil
 }
 
+func (p *pirDev) Close() error {
+	err := p.NodeBase.Close()
+	if p.pin != nil {
+		if err2 := haltPin(p.pin); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
 func (p *pirDev) run(b msgbus.Bus, pin gpio.PinIn) {
-	for {
-		pin.WaitForEdge(-1)
+	for p.ctx.Err() == nil {
+		if !pin.WaitForEdge(edgePollPeriod) {
+			continue
+		}
 		if pin.Read() == gpio.High {
 			log.Printf("%s: high", p)
 			// TODO(maruel): sub-second resolution?
@@ -0,0 +1,173 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+)
+
+// pwmFadeStep is the interval between the duty cycle updates while fading.
+const pwmFadeStep = 20 * time.Millisecond
+
+type pwmDev struct {
+	NodeBase
+	Cfg *nodes.PWM
+
+	b   msgbus.Bus
+	pin gpio.PinOut
+}
+
+func (p *pwmDev) init(b msgbus.Bus) error {
+	pin := gpioreg.ByName(p.Cfg.Pin)
+	if pin == nil {
+		return fmt.Errorf("%s: failed to find pin %s", p, p.Cfg.Pin)
+	}
+	p.b = b
+	p.pin = pin
+	f := pwmFader{}
+	if err := p.write(&f, 0); err != nil {
+		return err
+	}
+	c, err := b.Subscribe("level/set", msgbus.ExactlyOnce)
+	if err != nil {
+		return err
+	}
+	p.start(func() { p.run(&f, c) })
+	return nil
+}
+
+// Close stops listening for commands, turns the output off and releases the
+// pin.
+//
+// It is safe to call even if init failed midway.
+func (p *pwmDev) Close() error {
+	if p.b != nil {
+		// Closes the channel, which stops the goroutine.
+		p.b.Unsubscribe("level/set")
+	}
+	err := p.NodeBase.Close()
+	if p.pin == nil {
+		return err
+	}
+	if err2 := p.pin.Out(gpio.Low); err == nil {
+		err = err2
+	}
+	if err2 := p.pin.Halt(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// run applies the commands and fades the output between levels.
+func (p *pwmDev) run(f *pwmFader, c <-chan msgbus.Message) {
+	fade := time.Duration(p.Cfg.FadeMS) * time.Millisecond
+	var t *time.Ticker
+	var tick <-chan time.Time
+	defer func() {
+		if t != nil {
+			t.Stop()
+		}
+	}()
+	for {
+		now := time.Now()
+		select {
+		case <-p.ctx.Done():
+			return
+		case msg, ok := <-c:
+			if !ok {
+				return
+			}
+			v, op, err := processRel(msg.Topic, msg.Payload)
+			if err != nil {
+				log.Printf("%s: %v", p, err)
+				continue
+			}
+			f.set(v, op, now, fade)
+			if t == nil {
+				t = time.NewTicker(pwmFadeStep)
+				tick = t.C
+			}
+		case now = <-tick:
+		}
+		level, done := f.level(now)
+		if err := p.write(f, level); err != nil {
+			pubErr(p.b, "%v", err)
+		}
+		if done && t != nil {
+			t.Stop()
+			t = nil
+			tick = nil
+			shared.RetainedStr(p.b, "level", strconv.Itoa(f.target))
+		}
+	}
+}
+
+// write sets the duty cycle for level.
+func (p *pwmDev) write(f *pwmFader, level float64) error {
+	d := pwmDuty(p.Cfg, level)
+	if err := p.pin.PWM(d, p.Cfg.Hz); err != nil {
+		return fmt.Errorf("%s: failed to set %s to %s: %v", p, p.pin, d, err)
+	}
+	f.current = level
+	return nil
+}
+
+// pwmFader computes the level while transitioning to the target level.
+type pwmFader struct {
+	current  float64 // Level currently set, in percent.
+	target   int     // Target level, in percent.
+	from     float64 // Level when the transition started.
+	start    time.Time
+	duration time.Duration
+}
+
+// set starts a transition to the level v, which is relative to the current
+// target when op is 1 (add) or 2 (subtract), as returned by processRel.
+func (f *pwmFader) set(v, op int, now time.Time, duration time.Duration) {
+	switch op {
+	case 1:
+		v = f.target + v
+	case 2:
+		v = f.target - v
+	}
+	if v < 0 {
+		v = 0
+	} else if v > 100 {
+		v = 100
+	}
+	f.target = v
+	f.from = f.current
+	f.start = now
+	f.duration = duration
+}
+
+// level returns the level at time now and true once the target is reached.
+func (f *pwmFader) level(now time.Time) (float64, bool) {
+	d := now.Sub(f.start)
+	if d >= f.duration {
+		return float64(f.target), true
+	}
+	return f.from + (float64(f.target)-f.from)*float64(d)/float64(f.duration), false
+}
+
+// pwmDuty returns the duty cycle for level in percent, after gamma correction.
+func pwmDuty(cfg *nodes.PWM, level float64) gpio.Duty {
+	x := level / 100
+	if cfg.Gamma != 0 {
+		x = math.Pow(x, cfg.Gamma)
+	}
+	d := cfg.MinDuty + x*(cfg.MaxDuty-cfg.MinDuty)
+	return gpio.Duty(math.Round(d / 100 * float64(gpio.DutyMax)))
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+	"periph.io/x/periph/conn/physic"
+)
+
+func TestPWMDuty(t *testing.T) {
+	data := []struct {
+		cfg      nodes.PWM
+		level    float64
+		expected gpio.Duty
+	}{
+		{nodes.PWM{MaxDuty: 100}, 0, 0},
+		{nodes.PWM{MaxDuty: 100}, 50, gpio.DutyHalf},
+		{nodes.PWM{MaxDuty: 100}, 100, gpio.DutyMax},
+		{nodes.PWM{MaxDuty: 100, Gamma: 2}, 50, gpio.DutyMax / 4},
+		{nodes.PWM{MinDuty: 5, MaxDuty: 10}, 0, 838861},
+		{nodes.PWM{MinDuty: 5, MaxDuty: 10}, 100, 1677722},
+	}
+	for i, line := range data {
+		if actual := pwmDuty(&line.cfg, line.level); actual != line.expected {
+			t.Fatalf("#%d: %d != %d", i, line.expected, actual)
+		}
+	}
+}
+
+func TestPWMFader(t *testing.T) {
+	now := time.Now()
+	f := pwmFader{}
+	f.set(80, 0, now, time.Second)
+	if l, done := f.level(now.Add(500 * time.Millisecond)); l != 40 || done {
+		t.Fatalf("%g %t", l, done)
+	}
+	f.current = 40
+	// Relative to the target, not the current level.
+	f.set(10, 2, now.Add(500*time.Millisecond), time.Second)
+	if f.target != 70 {
+		t.Fatal(f.target)
+	}
+	if l, done := f.level(now.Add(2 * time.Second)); l != 70 || !done {
+		t.Fatalf("%g %t", l, done)
+	}
+	f.set(50, 1, now, 0)
+	if l, done := f.level(now); l != 100 || !done {
+		t.Fatalf("%g %t", l, done)
+	}
+}
+
+func TestPWM(t *testing.T) {
+	pin := &gpiotestPin{}
+	pin.N = "TEST_PWM"
+	if err := gpioreg.Register(pin); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister(pin.Name())
+
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("tape/level", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drain the subscription so the node is never blocked publishing.
+	states := make(chan string, 100)
+	go func() {
+		for msg := range c {
+			states <- string(msg.Payload)
+		}
+	}()
+	n := &nodes.Node{Name: "Tape", Config: &nodes.PWM{Pin: "TEST_PWM", Hz: physic.KiloHertz, MaxDuty: 100, FadeMS: 50}}
+	p, err := genNodeDev("tape", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.init(msgbus.RebasePub(msgbus.RebaseSub(b, "tape"), "tape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(msgbus.Message{Topic: "tape/level/set", Payload: []byte("50")}, msgbus.ExactlyOnce); err != nil {
+		t.Fatal(err)
+	}
+	waitState(t, states, "50")
+	pin.Lock()
+	d, f := pin.D, pin.F
+	pin.Unlock()
+	if d != gpio.DutyHalf || f != physic.KiloHertz {
+		t.Fatalf("%s %s", d, f)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := pin.Read(); l != gpio.Low {
+		t.Fatalf("expected off after Close, got %s", l)
+	}
+	if !pin.isHalted() {
+		t.Fatal("not halted")
+	}
+}
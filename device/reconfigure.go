@@ -0,0 +1,182 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+)
+
+// reconfigureDelay is how long to wait for the configuration to settle after
+// a change before applying it, since the controller publishes it as multiple
+// messages.
+const reconfigureDelay = 500 * time.Millisecond
+
+// configWatcher keeps a copy of the retained configuration topics published by
+// the controller and reconfigures the device when they change.
+//
+// The topics are subscribed to individually since wildcards do not match
+// topics starting with '$'.
+type configWatcher struct {
+	b       msgbus.Bus
+	d       *dev
+	changed chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup // run()
+
+	mu     sync.Mutex
+	values map[string][]byte
+	nodes  map[string]bool // Node IDs subscribed to.
+}
+
+// watchConfig applies the configuration published by the controller when it
+// changes.
+//
+// b must be rebased on the device ID.
+func watchConfig(b msgbus.Bus, d *dev) (*configWatcher, error) {
+	w := &configWatcher{
+		b:       b,
+		d:       d,
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		values:  map[string][]byte{},
+		nodes:   map[string]bool{},
+	}
+	for _, t := range []string{"$name", "$nodes"} {
+		if err := w.subscribe(t); err != nil {
+			return nil, err
+		}
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Close stops reconfiguring the device.
+//
+// The nodes are left running.
+//
+// TODO(maruel): The topics are not unsubscribed from, since msgbus panics when
+// unsubscribing while the retained messages are still being delivered. They
+// are released when the bus is closed.
+func (w *configWatcher) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	return nil
+}
+
+func (w *configWatcher) subscribe(topic string) error {
+	c, err := w.b.Subscribe(topic, msgbus.ExactlyOnce)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for msg := range c {
+			w.onMsg(msg)
+		}
+	}()
+	return nil
+}
+
+func (w *configWatcher) onMsg(msg msgbus.Message) {
+	w.mu.Lock()
+	w.values[msg.Topic] = msg.Payload
+	if msg.Topic == "$nodes" {
+		w.updateNodesLocked(string(msg.Payload))
+	}
+	w.mu.Unlock()
+	select {
+	case w.changed <- struct{}{}:
+	default:
+	}
+}
+
+// updateNodesLocked subscribes to the topics of the new nodes.
+//
+// The topics of the removed nodes are not unsubscribed from, in case the node
+// is added back.
+func (w *configWatcher) updateNodesLocked(ids string) {
+	for _, id := range strings.Split(ids, ",") {
+		if nodes.ID(id).Validate() != nil || w.nodes[id] {
+			continue
+		}
+		w.nodes[id] = true
+		for _, t := range []string{"$name", "$type", "$config"} {
+			if err := w.subscribe(id + "/" + t); err != nil {
+				log.Printf("failed to subscribe to %s/%s: %v", id, t, err)
+			}
+		}
+	}
+}
+
+func (w *configWatcher) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.changed:
+		case <-w.done:
+			return
+		}
+		// Wait for the configuration to settle.
+		for settled := false; !settled; {
+			select {
+			case <-w.changed:
+			case <-time.After(reconfigureDelay):
+				settled = true
+			case <-w.done:
+				return
+			}
+		}
+		cfg := w.config()
+		if cfg == nil || !w.d.hasChanges(cfg) {
+			continue
+		}
+		shared.RetainedStr(w.b, "$online", "reconfiguring")
+		if ids, err := w.d.reconfigure(w.b, cfg); err != nil {
+			pubErr(w.b, "failed to reconfigure: %v", err)
+		} else {
+			log.Printf("reconfigured nodes %v", ids)
+		}
+		shared.RetainedStr(w.b, "$online", "true")
+	}
+}
+
+// config returns the configuration, or nil if it is incomplete or invalid.
+func (w *configWatcher) config() *nodes.Dev {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := nodes.SerializedDev{Name: string(w.values["$name"]), Nodes: map[nodes.ID]*nodes.SerializedNode{}}
+	ids := string(w.values["$nodes"])
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, id := range strings.Split(ids, ",") {
+		n := &nodes.SerializedNode{
+			Name:   string(w.values[id+"/$name"]),
+			Type:   nodes.Type(w.values[id+"/$type"]),
+			Config: w.values[id+"/$config"],
+		}
+		if len(n.Name) == 0 || len(n.Type) == 0 || len(n.Config) == 0 {
+			// Not received yet.
+			return nil
+		}
+		s.Nodes[nodes.ID(id)] = n
+	}
+	d, err := s.ToDev()
+	if err == nil {
+		err = d.Validate()
+	}
+	if err != nil {
+		pubErr(w.b, "failed to reconfigure: %v", err)
+		return nil
+	}
+	return d
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+)
+
+type relayDev struct {
+	NodeBase
+	Cfg *nodes.Relay
+
+	b   msgbus.Bus
+	pin gpio.PinOut
+
+	mu    sync.Mutex
+	timer *time.Timer // Auto off.
+}
+
+func (r *relayDev) init(b msgbus.Bus) error {
+	pin := gpioreg.ByName(r.Cfg.Pin)
+	if pin == nil {
+		return fmt.Errorf("%s: failed to find pin %s", r, r.Cfg.Pin)
+	}
+	r.b = b
+	r.pin = pin
+	if err := r.set(r.Cfg.On); err != nil {
+		return err
+	}
+	c, err := b.Subscribe("on/set", msgbus.ExactlyOnce)
+	if err != nil {
+		return err
+	}
+	r.start(func() {
+		for msg := range c {
+			r.onMsg(msg)
+		}
+	})
+	return nil
+}
+
+// Close stops listening for commands, turns the output off and releases the
+// pin.
+//
+// It is safe to call even if init failed midway.
+func (r *relayDev) Close() error {
+	if r.b != nil {
+		// Closes the channel, which stops the goroutine.
+		r.b.Unsubscribe("on/set")
+	}
+	err := r.NodeBase.Close()
+	if r.pin == nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	if err2 := r.write(false); err == nil {
+		err = err2
+	}
+	if err2 := r.pin.Halt(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (r *relayDev) onMsg(msg msgbus.Message) {
+	on, err := strconv.ParseBool(string(msg.Payload))
+	if err != nil {
+		log.Printf("%s: invalid state %q", r, msg.Payload)
+		return
+	}
+	if err := r.set(on); err != nil {
+		pubErr(r.b, "%v", err)
+	}
+}
+
+// set drives the output and arms the auto off timer.
+func (r *relayDev) set(on bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if r.ctx.Err() != nil {
+		// Close() is in progress.
+		return nil
+	}
+	if err := r.write(on); err != nil {
+		return err
+	}
+	if on && r.Cfg.AutoOffMS != 0 {
+		r.timer = time.AfterFunc(time.Duration(r.Cfg.AutoOffMS)*time.Millisecond, func() {
+			if err := r.set(false); err != nil {
+				pubErr(r.b, "%v", err)
+			}
+		})
+	}
+	return nil
+}
+
+// write drives the pin and publishes the resulting state.
+func (r *relayDev) write(on bool) error {
+	l := gpio.Level(on != r.Cfg.ActiveLow)
+	if err := r.pin.Out(l); err != nil {
+		return fmt.Errorf("%s: failed to set %s to %s: %v", r, r.pin, l, err)
+	}
+	shared.RetainedStr(r.b, "on", strconv.FormatBool(on))
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/gpio"
+	"periph.io/x/periph/conn/gpio/gpioreg"
+)
+
+func TestRelay(t *testing.T) {
+	pin := &gpiotestPin{}
+	pin.N = "TEST_RELAY"
+	if err := gpioreg.Register(pin); err != nil {
+		t.Fatal(err)
+	}
+	defer gpioreg.Unregister(pin.Name())
+
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("relay/on", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Drain the subscription so the relay is never blocked publishing.
+	states := make(chan string, 100)
+	go func() {
+		for msg := range c {
+			states <- string(msg.Payload)
+		}
+	}()
+	n := &nodes.Node{Name: "Fan", Config: &nodes.Relay{Pin: "TEST_RELAY", ActiveLow: true, On: true, AutoOffMS: 10}}
+	r, err := genNodeDev("relay", n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.init(msgbus.RebasePub(msgbus.RebaseSub(b, "relay"), "relay")); err != nil {
+		t.Fatal(err)
+	}
+	// On upon boot, then turned off by the timer.
+	waitState(t, states, "true")
+	waitState(t, states, "false")
+	if l := pin.Read(); l != gpio.High {
+		t.Fatalf("expected off, got %s", l)
+	}
+
+	for _, s := range []string{"invalid", "true"} {
+		if err := b.Publish(msgbus.Message{Topic: "relay/on/set", Payload: []byte(s)}, msgbus.ExactlyOnce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitState(t, states, "true")
+	waitState(t, states, "false")
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if l := pin.Read(); l != gpio.High {
+		t.Fatalf("expected off after Close, got %s", l)
+	}
+	if !pin.isHalted() {
+		t.Fatal("not halted")
+	}
+}
+
+// waitState waits for the relay to publish the state s.
+func waitState(t *testing.T, c <-chan string, s string) {
+	for {
+		select {
+		case v := <-c:
+			if v == s {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", s)
+		}
+	}
+}
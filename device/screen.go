@@ -0,0 +1,272 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/psf"
+)
+
+const (
+	defaultFont        = "Terminus12x6"
+	defaultMarkeeSpeed = 30
+	defaultRefresh     = time.Second
+	// markeeFrame is the interval between redraws while the markee scrolls.
+	markeeFrame = 50 * time.Millisecond
+)
+
+// screen renders the widgets of a Display.
+//
+// It is independent of the display hardware.
+type screen struct {
+	cfg     *nodes.Display
+	widgets []nodes.Widget
+	fonts   map[string]*psf.Font
+	fg, bg  color.Color
+
+	mu          sync.Mutex
+	markee      string
+	markeeStart time.Time
+	content     *template.Template
+	values      map[string]string
+}
+
+// newScreen returns a screen for cfg, using the fonts loaded with load.
+func newScreen(cfg *nodes.Display, load func(name string) (*psf.Font, error), fg, bg color.Color) (*screen, error) {
+	s := &screen{
+		cfg: cfg,
+		// Copied so the defaults below are not written into cfg, which is compared
+		// with the next published configuration.
+		widgets: append([]nodes.Widget(nil), cfg.Widgets...),
+		fonts:   map[string]*psf.Font{},
+		fg:      fg,
+		bg:      bg,
+		values:  map[string]string{},
+	}
+	if len(s.widgets) == 0 {
+		if cfg.IsPaper() {
+			s.widgets = []nodes.Widget{{Kind: nodes.WidgetContent}}
+		} else {
+			s.widgets = []nodes.Widget{
+				{Kind: nodes.WidgetMarkee, H: 20, Font: "Terminus20x10"},
+				{Kind: nodes.WidgetContent, Y: 20},
+			}
+		}
+	}
+	dw, dh := cfg.Size()
+	for i := range s.widgets {
+		w := &s.widgets[i]
+		if len(w.Font) == 0 {
+			w.Font = defaultFont
+		}
+		if w.W == 0 {
+			w.W = dw - w.X
+		}
+		if w.H == 0 {
+			w.H = dh - w.Y
+		}
+		if s.fonts[w.Font] == nil {
+			f, err := load(w.Font)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load font %s: %v", w.Font, err)
+			}
+			s.fonts[w.Font] = f
+		}
+	}
+	return s, nil
+}
+
+// topics returns the MQTT topics which values are used.
+func (s *screen) topics() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range s.cfg.Topics {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	for _, w := range s.widgets {
+		if len(w.Topic) != 0 && !seen[w.Topic] {
+			seen[w.Topic] = true
+			out = append(out, w.Topic)
+		}
+	}
+	return out
+}
+
+// refresh returns the interval until the next redraw.
+func (s *screen) refresh() time.Duration {
+	s.mu.Lock()
+	scrolling := len(s.markee) != 0
+	s.mu.Unlock()
+	if scrolling && s.hasWidget(nodes.WidgetMarkee) {
+		return markeeFrame
+	}
+	if s.cfg.RefreshMS != 0 {
+		return time.Duration(s.cfg.RefreshMS) * time.Millisecond
+	}
+	if s.cfg.IsPaper() {
+		return nodes.PaperRefreshMS * time.Millisecond
+	}
+	return defaultRefresh
+}
+
+func (s *screen) hasWidget(kind string) bool {
+	for _, w := range s.widgets {
+		if w.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// setMarkee sets the scrolling text; it restarts from the right.
+func (s *screen) setMarkee(text string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markee = text
+	s.markeeStart = now
+}
+
+// setContent sets the content template.
+//
+// When text is not a valid template, it is drawn as is and the parse error is
+// returned.
+func (s *screen) setContent(text string) error {
+	t, err := template.New("content").Parse(text)
+	if err != nil {
+		t = template.Must(template.New("content").Parse("{{" + strconv.Quote(text) + "}}"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.content = t
+	return err
+}
+
+// setValue sets the value of a topic.
+func (s *screen) setValue(topic, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[topic] = value
+}
+
+// draw renders the widgets at time now on dst.
+func (s *screen) draw(dst draw.Image, now time.Time) {
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{s.bg}, image.Point{}, draw.Src)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.widgets {
+		w := &s.widgets[i]
+		r := image.Rect(w.X, w.Y, w.X+w.W, w.Y+w.H)
+		c := &clip{dst, r.Intersect(dst.Bounds())}
+		f := s.fonts[w.Font]
+		switch w.Kind {
+		case nodes.WidgetMarkee:
+			if len(s.markee) == 0 {
+				continue
+			}
+			speed := s.cfg.MarkeeSpeed
+			if speed == 0 {
+				speed = defaultMarkeeSpeed
+			}
+			// Scroll from the right edge until the text is fully out on the left.
+			width := f.W * len([]rune(s.markee))
+			offset := int(now.Sub(s.markeeStart).Seconds()*float64(speed)) % (width + w.W)
+			s.drawText(c, f, r.Max.X-offset, r.Min.Y, s.markee)
+		case nodes.WidgetContent:
+			if s.content == nil {
+				continue
+			}
+			var b bytes.Buffer
+			if err := s.content.Execute(&b, &nodes.DisplayData{Now: now, Values: s.values}); err != nil {
+				b.Reset()
+				b.WriteString(err.Error())
+			}
+			y := r.Min.Y
+			for _, line := range strings.Split(b.String(), "\n") {
+				s.drawText(c, f, r.Min.X, y, line)
+				y += f.H
+			}
+		case nodes.WidgetClock:
+			layout := w.Format
+			if len(layout) == 0 {
+				layout = "15:04"
+			}
+			s.drawText(c, f, r.Min.X, r.Min.Y, now.Format(layout))
+		case nodes.WidgetValue:
+			format := w.Format
+			if len(format) == 0 {
+				format = "%s"
+			}
+			if v, ok := s.values[w.Topic]; ok {
+				s.drawText(c, f, r.Min.X, r.Min.Y, fmt.Sprintf(format, v))
+			}
+		case nodes.WidgetProgress:
+			s.drawProgress(c, r, w)
+		}
+	}
+}
+
+// drawText draws a line of text, leaving the runes missing from the font
+// blank.
+func (s *screen) drawText(dst draw.Image, f *psf.Font, x, y int, text string) {
+	for _, r := range text {
+		if _, ok := f.Letters[r]; ok {
+			f.Draw(dst, x, y, s.fg, nil, string(r))
+		}
+		x += f.W
+	}
+}
+
+// drawProgress draws the outline of the bar, filled in proportion of the value
+// of the topic.
+func (s *screen) drawProgress(dst draw.Image, r image.Rectangle, w *nodes.Widget) {
+	fg := &image.Uniform{s.fg}
+	for _, e := range []image.Rectangle{
+		{r.Min, image.Pt(r.Max.X, r.Min.Y+1)},
+		{image.Pt(r.Min.X, r.Max.Y-1), r.Max},
+		{r.Min, image.Pt(r.Min.X+1, r.Max.Y)},
+		{image.Pt(r.Max.X-1, r.Min.Y), r.Max},
+	} {
+		draw.Draw(dst, e, fg, image.Point{}, draw.Src)
+	}
+	v, err := strconv.ParseFloat(s.values[w.Topic], 64)
+	if err != nil {
+		return
+	}
+	ratio := (v - w.Min) / (w.Max - w.Min)
+	if ratio < 0 {
+		ratio = 0
+	} else if ratio > 1 {
+		ratio = 1
+	}
+	inner := r.Inset(2)
+	inner.Max.X = inner.Min.X + int(float64(inner.Dx())*ratio+0.5)
+	draw.Draw(dst, inner, fg, image.Point{}, draw.Src)
+}
+
+// clip restricts the drawing on an image to r.
+type clip struct {
+	draw.Image
+	r image.Rectangle
+}
+
+func (c *clip) Set(x, y int, col color.Color) {
+	if (image.Point{x, y}).In(c.r) {
+		c.Image.Set(x, y, col)
+	}
+}
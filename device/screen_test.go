@@ -0,0 +1,112 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"image"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/psf"
+	"periph.io/x/periph/devices/ssd1306/image1bit"
+)
+
+func TestScreenContent(t *testing.T) {
+	cfg := &nodes.Display{SSD1306: true, W: 32, H: 8, Topics: []string{"env/temp"}}
+	s, img := newTestScreen(t, cfg, nodes.Widget{Kind: nodes.WidgetContent})
+	if err := s.setContent("{{index .Values \"env/temp\"}}\n#"); err != nil {
+		t.Fatal(err)
+	}
+	s.setValue("env/temp", "##")
+	s.draw(img, time.Now())
+	expectPixels(t, img, []image.Point{{0, 0}, {15, 1}, {0, 2}, {7, 3}}, []image.Point{{16, 0}, {8, 2}, {0, 4}})
+
+	// An invalid template is drawn as is.
+	if err := s.setContent("#{{"); err == nil {
+		t.Fatal("expected error")
+	}
+	s.draw(img, time.Now())
+	expectPixels(t, img, []image.Point{{0, 0}}, []image.Point{{8, 0}, {0, 2}})
+}
+
+func TestScreenMarkee(t *testing.T) {
+	cfg := &nodes.Display{SSD1306: true, W: 32, H: 8, MarkeeSpeed: 10}
+	s, img := newTestScreen(t, cfg, nodes.Widget{Kind: nodes.WidgetMarkee})
+	if s.refresh() != time.Second {
+		t.Fatal(s.refresh())
+	}
+	now := time.Now()
+	s.setMarkee("#", now)
+	if s.refresh() != markeeFrame {
+		t.Fatal(s.refresh())
+	}
+	s.draw(img, now.Add(time.Second))
+	expectPixels(t, img, []image.Point{{22, 0}, {29, 1}}, []image.Point{{21, 0}, {30, 0}})
+	// The text wraps around once fully out on the left.
+	s.draw(img, now.Add(4*time.Second))
+	expectPixels(t, img, nil, []image.Point{{0, 0}, {31, 0}})
+}
+
+func TestScreenPaper(t *testing.T) {
+	cfg := &nodes.Display{EPD: true, Model: "1in54"}
+	s, err := newScreen(cfg, func(name string) (*psf.Font, error) { return &psf.Font{H: 2, W: 8}, nil }, image1bit.Off, image1bit.On)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setMarkee("#", time.Now())
+	if s.hasWidget(nodes.WidgetMarkee) || s.refresh() != time.Minute {
+		t.Fatal(s.refresh())
+	}
+}
+
+func TestScreenWidgets(t *testing.T) {
+	cfg := &nodes.Display{SSD1306: true, W: 32, H: 16}
+	s, img := newTestScreen(t, cfg,
+		nodes.Widget{Kind: nodes.WidgetValue, W: 8, H: 2, Topic: "a"},
+		nodes.Widget{Kind: nodes.WidgetProgress, Y: 4, W: 20, H: 6, Topic: "b", Max: 100},
+		nodes.Widget{Kind: nodes.WidgetClock, Y: 12, Format: "#"})
+	if d := s.topics(); len(d) != 2 || d[0] != "a" || d[1] != "b" {
+		t.Fatal(d)
+	}
+	s.setValue("a", "##")
+	s.setValue("b", "50")
+	s.draw(img, time.Now())
+	// The value is clipped to its area.
+	expectPixels(t, img, []image.Point{{7, 0}}, []image.Point{{8, 0}})
+	// The outline and half of the bar.
+	expectPixels(t, img, []image.Point{{0, 4}, {19, 9}, {2, 6}, {9, 7}}, []image.Point{{10, 6}, {1, 6}, {20, 4}})
+	expectPixels(t, img, []image.Point{{0, 12}, {7, 13}}, []image.Point{{8, 12}})
+}
+
+func newTestScreen(t *testing.T, cfg *nodes.Display, w ...nodes.Widget) (*screen, *image1bit.VerticalLSB) {
+	cfg.I2C.ID = "1"
+	cfg.Widgets = w
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// An 8x2 font with only a full block for '#'.
+	load := func(name string) (*psf.Font, error) {
+		return &psf.Font{H: 2, W: 8, Letters: map[rune][]byte{'#': {0xFF, 0xFF}}}, nil
+	}
+	s, err := newScreen(cfg, load, image1bit.On, image1bit.Off)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, image1bit.NewVerticalLSB(image.Rect(0, 0, cfg.W, cfg.H))
+}
+
+func expectPixels(t *testing.T, img *image1bit.VerticalLSB, on, off []image.Point) {
+	for _, p := range on {
+		if img.BitAt(p.X, p.Y) != image1bit.On {
+			t.Fatalf("%s is off", p)
+		}
+	}
+	for _, p := range off {
+		if img.BitAt(p.X, p.Y) != image1bit.Off {
+			t.Fatalf("%s is on", p)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/i2c"
+	"periph.io/x/periph/conn/i2c/i2creg"
+	"periph.io/x/periph/conn/physic"
+	"periph.io/x/periph/devices/bmxx80"
+	"periph.io/x/periph/experimental/devices/bh1750"
+)
+
+type sensorDev struct {
+	NodeBase
+	Cfg *nodes.Sensor
+
+	bus   i2c.BusCloser
+	s     physic.SenseEnv // BMxx80.
+	light lightSensor     // BH1750.
+}
+
+// lightSensor is implemented by bh1750.Dev.
+type lightSensor interface {
+	Sense() (physic.LuminousFlux, error)
+	Halt() error
+}
+
+func (s *sensorDev) init(b msgbus.Bus) error {
+	var err error
+	if s.bus, err = i2creg.Open(s.Cfg.I2C.ID); err != nil {
+		return s.wrap(err)
+	}
+	if s.Cfg.BH1750 {
+		var d *bh1750.Dev
+		if d, err = bh1750.NewI2C(s.bus, s.Cfg.Addr); err != nil {
+			return s.wrap(err)
+		}
+		s.light = d
+	} else if s.s, err = bmxx80.NewI2C(s.bus, s.Cfg.Addr, &bmxx80.DefaultOpts); err != nil {
+		return s.wrap(err)
+	}
+	s.start(func() { s.run(b) })
+	return nil
+}
+
+// Close stops polling, turns the sensor off and releases the I²C bus.
+//
+// It is safe to call even if init failed midway.
+func (s *sensorDev) Close() error {
+	err := s.NodeBase.Close()
+	if s.s != nil {
+		if err2 := s.s.Halt(); err == nil {
+			err = err2
+		}
+	}
+	if s.light != nil {
+		if err2 := s.light.Halt(); err == nil {
+			err = err2
+		}
+	}
+	if s.bus != nil {
+		if err2 := s.bus.Close(); err == nil {
+			err = err2
+		}
+	}
+	return err
+}
+
+// run polls the sensor and publishes the measurements that changed enough.
+//
+// A failure is only reported when the error changes, not on every poll.
+func (s *sensorDev) run(b msgbus.Bus) {
+	r := sensorReport{
+		threshold: map[nodes.ID]float64{
+			"temperature": s.Cfg.Threshold.Temperature,
+			"pressure":    s.Cfg.Threshold.Pressure,
+			"humidity":    s.Cfg.Threshold.Humidity,
+			"illuminance": s.Cfg.Threshold.Illuminance,
+		},
+	}
+	t := time.NewTicker(time.Duration(s.Cfg.PeriodMS) * time.Millisecond)
+	defer t.Stop()
+	lastErr := ""
+	for {
+		if m, err := s.sense(); err != nil {
+			if e := err.Error(); e != lastErr {
+				lastErr = e
+				pubErr(b, "%s: failed to sense: %v", s, err)
+			}
+		} else {
+			if len(lastErr) != 0 {
+				lastErr = ""
+				log.Printf("%s: sensing again", s)
+			}
+			for id, v := range r.update(m) {
+				// Retained so the last measurement is known without waiting for the
+				// next change.
+				shared.RetainedStr(b, string(id), strconv.FormatFloat(v, 'f', 2, 64))
+			}
+		}
+		select {
+		case <-t.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// sense returns the measurements in the units of the node's properties.
+func (s *sensorDev) sense() (map[nodes.ID]float64, error) {
+	if s.light != nil {
+		l, err := s.light.Sense()
+		if err != nil {
+			return nil, err
+		}
+		// bh1750 returns the illuminance in lux as lumens.
+		return map[nodes.ID]float64{"illuminance": float64(l) / float64(physic.Lumen)}, nil
+	}
+	var e physic.Env
+	if err := s.s.Sense(&e); err != nil {
+		return nil, err
+	}
+	return s.measures(&e), nil
+}
+
+// measures converts e to the units of the node's properties.
+func (s *sensorDev) measures(e *physic.Env) map[nodes.ID]float64 {
+	m := map[nodes.ID]float64{
+		"temperature": e.Temperature.Celsius(),
+		"pressure":    float64(e.Pressure) / float64(physic.KiloPascal),
+	}
+	if s.Cfg.BME280 {
+		m["humidity"] = float64(e.Humidity) / float64(physic.PercentRH)
+	}
+	return m
+}
+
+// sensorReport keeps the last reported measurements to only report the ones
+// that changed by at least their threshold.
+type sensorReport struct {
+	threshold map[nodes.ID]float64
+	last      map[nodes.ID]float64
+}
+
+// update returns the measurements in m to report.
+func (r *sensorReport) update(m map[nodes.ID]float64) map[nodes.ID]float64 {
+	if r.last == nil {
+		r.last = map[nodes.ID]float64{}
+	}
+	out := map[nodes.ID]float64{}
+	for id, v := range m {
+		if last, ok := r.last[id]; ok && math.Abs(v-last) < r.threshold[id] {
+			continue
+		}
+		r.last[id] = v
+		out[id] = v
+	}
+	return out
+}
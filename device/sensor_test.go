@@ -0,0 +1,202 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package device
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maruel/dlibox/nodes"
+	"github.com/maruel/msgbus"
+	"periph.io/x/periph/conn/physic"
+)
+
+func TestSensorReport(t *testing.T) {
+	r := sensorReport{threshold: map[nodes.ID]float64{"temperature": 0.5}}
+	data := []struct {
+		in       map[nodes.ID]float64
+		expected map[nodes.ID]float64
+	}{
+		{
+			map[nodes.ID]float64{"temperature": 20, "pressure": 100},
+			map[nodes.ID]float64{"temperature": 20, "pressure": 100},
+		},
+		// No threshold for pressure, every change is reported.
+		{
+			map[nodes.ID]float64{"temperature": 20.4, "pressure": 100.1},
+			map[nodes.ID]float64{"pressure": 100.1},
+		},
+		// The change is relative to the last reported value, not the last
+		// measurement.
+		{
+			map[nodes.ID]float64{"temperature": 19.5, "pressure": 100.1},
+			map[nodes.ID]float64{"temperature": 19.5, "pressure": 100.1},
+		},
+	}
+	for i, line := range data {
+		if actual := r.update(line.in); !reflect.DeepEqual(line.expected, actual) {
+			t.Fatalf("#%d: %v != %v", i, line.expected, actual)
+		}
+	}
+}
+
+func TestSensorRun(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("+", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &sensorDev{
+		NodeBase: NodeBase{id: "env", name: "Weather", typ: "sensor", ctx: ctx, cancel: cancel},
+		Cfg:      &nodes.Sensor{BME280: true, I2C: nodes.I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1, Threshold: nodes.SensorThreshold{Temperature: 1, Pressure: 1, Humidity: 1}},
+		s:        &fakeEnv{e: physic.Env{Temperature: physic.ZeroCelsius + 21500*physic.MilliKelvin, Pressure: 101325 * physic.Pascal, Humidity: 45 * physic.PercentRH}},
+	}
+	s.start(func() { s.run(b) })
+	got := map[string]string{}
+	for len(got) != 3 {
+		select {
+		case msg := <-c:
+			got[msg.Topic] = string(msg.Payload)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out; got %v", got)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"temperature": "21.50", "pressure": "101.33", "humidity": "45.00"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("%v != %v", expected, got)
+	}
+	if !s.s.(*fakeEnv).isHalted() {
+		t.Fatal("sensor not halted")
+	}
+}
+
+func TestSensorRun_Light(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("illuminance", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &fakeLight{l: 320 * physic.Lumen}
+	s := &sensorDev{
+		NodeBase: NodeBase{id: "light", name: "Light", typ: "sensor", ctx: ctx, cancel: cancel},
+		Cfg:      &nodes.Sensor{BH1750: true, I2C: nodes.I2CRef{ID: "1"}, Addr: 0x23, PeriodMS: 1},
+		light:    l,
+	}
+	s.start(func() { s.run(b) })
+	select {
+	case msg := <-c:
+		if got := string(msg.Payload); got != "320.00" {
+			t.Fatalf("320.00 != %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !l.halted {
+		t.Fatal("sensor not halted")
+	}
+}
+
+func TestSensorRun_Error(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	c, err := b.Subscribe("$error", msgbus.ExactlyOnce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &fakeEnv{err: errors.New("i2c: nack")}
+	s := &sensorDev{
+		NodeBase: NodeBase{id: "env", name: "Weather", typ: "sensor", ctx: ctx, cancel: cancel},
+		Cfg:      &nodes.Sensor{BME280: true, I2C: nodes.I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1},
+		s:        f,
+	}
+	s.start(func() { s.run(b) })
+	// The local bus may deliver a message more than once; only distinct
+	// errors matter.
+	got := map[string]bool{}
+	for f.count() < 10 {
+		select {
+		case msg := <-c:
+			got[string(msg.Payload)] = true
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one error, got %v", got)
+	}
+}
+
+// fakeEnv is a physic.SenseEnv always returning the same measurements, or err.
+type fakeEnv struct {
+	physic.SenseEnv
+	e   physic.Env
+	err error
+
+	mu     sync.Mutex
+	sensed int
+	halted bool
+}
+
+func (f *fakeEnv) Sense(e *physic.Env) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sensed++
+	if f.err != nil {
+		return f.err
+	}
+	*e = f.e
+	return nil
+}
+
+func (f *fakeEnv) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sensed
+}
+
+func (f *fakeEnv) Halt() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.halted = true
+	return nil
+}
+
+func (f *fakeEnv) isHalted() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.halted
+}
+
+// fakeLight is a lightSensor always returning the same illuminance.
+type fakeLight struct {
+	l      physic.LuminousFlux
+	halted bool
+}
+
+func (f *fakeLight) Sense() (physic.LuminousFlux, error) {
+	return f.l, nil
+}
+
+func (f *fakeLight) Halt() error {
+	f.halted = true
+	return nil
+}
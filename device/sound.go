@@ -18,6 +18,8 @@ type soundDev struct {
 	NodeBase
 	Cfg  *nodes.Sound
 	root string
+
+	b msgbus.Bus
 }
 
 func (s *soundDev) init(b msgbus.Bus) error {
@@ -25,16 +27,23 @@ func (s *soundDev) init(b msgbus.Bus) error {
 	if err != nil {
 		return err
 	}
-	go func() {
-		for {
-			for msg := range c {
-				s.onMsg(msg)
-			}
+	s.b = b
+	s.start(func() {
+		for msg := range c {
+			s.onMsg(msg)
 		}
-	}()
+	})
 	return nil
 }
 
+func (s *soundDev) Close() error {
+	if s.b != nil {
+		// Closes the channel, which stops the goroutine.
+		s.b.Unsubscribe("sound")
+	}
+	return s.NodeBase.Close()
+}
+
 func (s *soundDev) onMsg(m msgbus.Message) {
 	p := filepath.Join(s.root, filepath.Base(string(m.Payload))+".wav")
 	if _, err := os.Stat(p); err != nil {
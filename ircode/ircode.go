@@ -0,0 +1,236 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+// Package ircode encodes and decodes InfraRed remote control codes as pulse
+// timings.
+//
+// A code is written as "<protocol>:<value>", for example "nec:0xEF10DF20",
+// "rc5:0x300C" or "raw:9000,4500,560,560,560". The raw values are in
+// microseconds.
+package ircode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Protocol is an IR remote protocol.
+type Protocol string
+
+// Supported protocols.
+const (
+	// NEC is 32 bits pulse distance encoded after a 9ms leader, sent LSB
+	// first. Value bit 0 is the first bit sent, so the usual address, inverted
+	// address, command and inverted command are the bytes 0 to 3.
+	NEC Protocol = "nec"
+	// RC5 is 14 bits Manchester encoded, sent MSB first. Value bit 13 is the
+	// start bit, always set, and RC5Toggle flips on each key press.
+	RC5 Protocol = "rc5"
+	// Raw is the pulse timings as is, for unknown protocols.
+	Raw Protocol = "raw"
+)
+
+// RC5Toggle is the toggle bit of a RC5 Value.
+const RC5Toggle = 1 << 11
+
+// Pulses is a sequence of alternating marks, where the carrier is on, and
+// spaces, where the carrier is off. It starts and ends with a mark.
+type Pulses []time.Duration
+
+// Code is an IR remote code.
+type Code struct {
+	Protocol Protocol
+	Value    uint32 // Unused for Raw.
+	Raw      Pulses // Only used for Raw.
+}
+
+// Parse parses a code as returned by Code.String().
+func Parse(s string) (Code, error) {
+	i := strings.IndexByte(s, ':')
+	if i == -1 {
+		return Code{}, fmt.Errorf("ircode: missing protocol in %q", s)
+	}
+	c := Code{Protocol: Protocol(s[:i])}
+	v := s[i+1:]
+	switch c.Protocol {
+	case NEC, RC5:
+		n, err := strconv.ParseUint(v, 0, 32)
+		if err != nil {
+			return Code{}, fmt.Errorf("ircode: invalid value %q", v)
+		}
+		c.Value = uint32(n)
+		if c.Protocol == RC5 && (c.Value >= 1<<rc5Bits || c.Value&(1<<(rc5Bits-1)) == 0) {
+			return Code{}, fmt.Errorf("ircode: invalid rc5 value %q", v)
+		}
+	case Raw:
+		for _, t := range strings.Split(v, ",") {
+			us, err := strconv.Atoi(strings.TrimSpace(t))
+			if err != nil || us <= 0 {
+				return Code{}, fmt.Errorf("ircode: invalid pulse %q", t)
+			}
+			c.Raw = append(c.Raw, time.Duration(us)*time.Microsecond)
+		}
+		if len(c.Raw)%2 == 0 {
+			return Code{}, errors.New("ircode: raw pulses must end with a mark")
+		}
+	default:
+		return Code{}, fmt.Errorf("ircode: unknown protocol %q", c.Protocol)
+	}
+	return c, nil
+}
+
+func (c Code) String() string {
+	switch c.Protocol {
+	case NEC:
+		return fmt.Sprintf("nec:0x%08X", c.Value)
+	case RC5:
+		return fmt.Sprintf("rc5:0x%04X", c.Value)
+	default:
+		us := make([]string, len(c.Raw))
+		for i, d := range c.Raw {
+			us[i] = strconv.Itoa(int((d + time.Microsecond/2) / time.Microsecond))
+		}
+		return "raw:" + strings.Join(us, ",")
+	}
+}
+
+// Pulses returns the pulses to transmit the code.
+func (c Code) Pulses() Pulses {
+	switch c.Protocol {
+	case NEC:
+		p := make(Pulses, 0, 2+2*necBits+1)
+		p = append(p, necLeaderMark, necLeaderSpace)
+		for i := uint(0); i < necBits; i++ {
+			if c.Value&(1<<i) != 0 {
+				p = append(p, necMark, necOne)
+			} else {
+				p = append(p, necMark, necZero)
+			}
+		}
+		return append(p, necMark)
+	case RC5:
+		// Each bit is two halves; a one is a space then a mark. The leading
+		// space of the start bit and the trailing space are idle time.
+		var halves []bool
+		for i := rc5Bits - 1; i >= 0; i-- {
+			one := c.Value&(1<<uint(i)) != 0
+			halves = append(halves, !one, one)
+		}
+		var p Pulses
+		for i, h := range halves {
+			if i != 0 && h == halves[i-1] {
+				p[len(p)-1] += rc5Half
+			} else if h || len(p) != 0 {
+				p = append(p, rc5Half)
+			}
+		}
+		if len(p)%2 == 0 {
+			p = p[:len(p)-1]
+		}
+		return p
+	default:
+		return append(Pulses(nil), c.Raw...)
+	}
+}
+
+// Decode returns the code of a frame of pulses.
+//
+// The pulses are kept as Raw when they don't match a known protocol.
+func Decode(p Pulses) Code {
+	if v, ok := decodeNEC(p); ok {
+		return Code{Protocol: NEC, Value: v}
+	}
+	if v, ok := decodeRC5(p); ok {
+		return Code{Protocol: RC5, Value: v}
+	}
+	return Code{Protocol: Raw, Raw: append(Pulses(nil), p...)}
+}
+
+// IsNECRepeat returns true if the pulses are a NEC repeat frame, sent every
+// 108ms while a key is held.
+//
+// It has no value; it repeats the last code received.
+func IsNECRepeat(p Pulses) bool {
+	return len(p) == 3 && near(p[0], necLeaderMark) && near(p[1], necRepeatSpace) && near(p[2], necMark)
+}
+
+//
+
+const (
+	necBits        = 32
+	necLeaderMark  = 9000 * time.Microsecond
+	necLeaderSpace = 4500 * time.Microsecond
+	necRepeatSpace = 2250 * time.Microsecond
+	necMark        = 560 * time.Microsecond
+	necZero        = 560 * time.Microsecond
+	necOne         = 1690 * time.Microsecond
+
+	rc5Bits = 14
+	rc5Half = 889 * time.Microsecond
+)
+
+func decodeNEC(p Pulses) (uint32, bool) {
+	if len(p) != 2+2*necBits+1 || !near(p[0], necLeaderMark) || !near(p[1], necLeaderSpace) {
+		return 0, false
+	}
+	var v uint32
+	for i := uint(0); i < necBits; i++ {
+		if !near(p[2+2*i], necMark) {
+			return 0, false
+		}
+		switch s := p[3+2*i]; {
+		case near(s, necOne):
+			v |= 1 << i
+		case !near(s, necZero):
+			return 0, false
+		}
+	}
+	return v, near(p[len(p)-1], necMark)
+}
+
+func decodeRC5(p Pulses) (uint32, bool) {
+	// The idle line before the frame is the first half of the start bit.
+	halves := []bool{false}
+	for i, d := range p {
+		n := 0
+		switch {
+		case near(d, rc5Half):
+			n = 1
+		case near(d, 2*rc5Half):
+			n = 2
+		default:
+			return 0, false
+		}
+		for ; n > 0; n-- {
+			halves = append(halves, i%2 == 0)
+		}
+	}
+	// The trailing space of a zero is idle time.
+	if len(halves) == 2*rc5Bits-1 {
+		halves = append(halves, false)
+	}
+	if len(halves) != 2*rc5Bits {
+		return 0, false
+	}
+	var v uint32
+	for i := 0; i < 2*rc5Bits; i += 2 {
+		if halves[i] == halves[i+1] {
+			return 0, false
+		}
+		v <<= 1
+		if halves[i+1] {
+			v |= 1
+		}
+	}
+	return v, true
+}
+
+// near returns true if d is within 25% of expected, the precision of common
+// demodulating receivers.
+func near(d, expected time.Duration) bool {
+	return d > expected*3/4 && d < expected*5/4
+}
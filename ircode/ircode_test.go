@@ -0,0 +1,107 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package ircode
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	data := []struct {
+		in    string
+		valid bool
+	}{
+		{"nec:0xEF10DF20", true},
+		{"rc5:0x300C", true},
+		{"raw:9000,4500,560", true},
+		{"nec:", false},
+		{"nec:0x1EF10DF20", false},
+		{"rc5:0x100C", false},
+		{"rc5:0x700C", false},
+		{"raw:9000,4500", false},
+		{"raw:9000,-1,560", false},
+		{"sony:0x10", false},
+		{"0x10", false},
+	}
+	for i, line := range data {
+		c, err := Parse(line.in)
+		if (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if err == nil && c.String() != line.in {
+			t.Fatalf("#%d: %q != %q", i, line.in, c.String())
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := []Code{
+		{Protocol: NEC, Value: 0xEF10DF20},
+		{Protocol: NEC},
+		{Protocol: RC5, Value: 0x300C},
+		{Protocol: RC5, Value: 0x3FFF},
+		{Protocol: RC5, Value: 0x2000},
+		{Protocol: RC5, Value: 0x2AAA},
+		{Protocol: Raw, Raw: Pulses{time.Millisecond, time.Millisecond, time.Millisecond}},
+	}
+	for i, c := range data {
+		p := c.Pulses()
+		if len(p)%2 != 1 {
+			t.Fatalf("#%d: must end with a mark: %v", i, p)
+		}
+		if d := Decode(p); !reflect.DeepEqual(c, d) {
+			t.Fatalf("#%d: %s != %s", i, c, d)
+		}
+	}
+}
+
+func TestDecodeJitter(t *testing.T) {
+	// Demodulating receivers typically stretch the marks.
+	p := Code{Protocol: NEC, Value: 0x12345678}.Pulses()
+	for i := range p {
+		if i%2 == 0 {
+			p[i] += 100 * time.Microsecond
+		} else {
+			p[i] -= 100 * time.Microsecond
+		}
+	}
+	if c := Decode(p); c.Protocol != NEC || c.Value != 0x12345678 {
+		t.Fatal(c)
+	}
+}
+
+func TestIsNECRepeat(t *testing.T) {
+	data := []struct {
+		p        Pulses
+		expected bool
+	}{
+		{Pulses{9000 * time.Microsecond, 2250 * time.Microsecond, 560 * time.Microsecond}, true},
+		{Pulses{9100 * time.Microsecond, 2150 * time.Microsecond, 650 * time.Microsecond}, true},
+		{Pulses{9000 * time.Microsecond, 4500 * time.Microsecond, 560 * time.Microsecond}, false},
+		{Pulses{889 * time.Microsecond, 889 * time.Microsecond, 889 * time.Microsecond}, false},
+		{Code{Protocol: NEC, Value: 0x12345678}.Pulses(), false},
+	}
+	for i, line := range data {
+		if actual := IsNECRepeat(line.p); actual != line.expected {
+			t.Fatalf("#%d: %t != %t", i, line.expected, actual)
+		}
+	}
+}
+
+func TestRC5Pulses(t *testing.T) {
+	// Start bits 1, 1, toggle 0, then 1 and zeros: the first half space and the
+	// last half space are idle time.
+	c := Code{Protocol: RC5, Value: 0x3400}
+	h := rc5Half
+	expected := Pulses{h, h, 2 * h, 2 * h, 2 * h}
+	for i := 0; i < 18; i++ {
+		expected = append(expected, h)
+	}
+	if p := c.Pulses(); !reflect.DeepEqual(expected, p) {
+		t.Fatalf("%v != %v", expected, p)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package nodes
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
+)
+
+// HomieRoot is the root topic of the devices following the Homie convention.
+//
+// https://homieiot.github.io/specification/
+const HomieRoot = "homie"
+
+// HomieVersion is the version of the Homie convention published by the dlibox
+// devices. Devices following the version 3 and 4 are understood.
+const HomieVersion = "4.0.0"
+
+// Homie device states.
+const (
+	HomieInit         = "init"
+	HomieReady        = "ready"
+	HomieDisconnected = "disconnected"
+	HomieSleeping     = "sleeping"
+	HomieLost         = "lost"
+	HomieAlert        = "alert"
+)
+
+// Homie is a node of a third party device following the Homie convention.
+//
+// It is discovered by the controller, not configured, so it is not part of
+// TypesMap and can't be used in the controller's settings.
+type Homie struct {
+	Type       string // $type of the node, free form.
+	Properties map[ID]Property
+}
+
+// Validate implements Validator.
+func (h *Homie) Validate() error {
+	for id := range h.Properties {
+		if err := id.Validate(); err != nil {
+			return fmt.Errorf("homie: %v", err)
+		}
+	}
+	return nil
+}
+
+func (h *Homie) toProperties() map[ID]Property {
+	return h.Properties
+}
+
+// PublishHomie publishes the device description as a Homie device.
+//
+// b must be rebased on homie/<device ID>. $state is "init" while the
+// description is being updated, then "ready".
+//
+// TODO(maruel): $extensions is not published since an empty retained message
+// deletes it.
+func (s *SerializedDev) PublishHomie(b msgbus.Bus) {
+	shared.RetainedStr(b, "$state", HomieInit)
+	shared.RetainedStr(b, "$homie", HomieVersion)
+	shared.RetainedStr(b, "$name", s.Name)
+	ids := make([]string, 0, len(s.Nodes))
+	for id := range s.Nodes {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s.Nodes[ID(id)].publishHomie(msgbus.RebasePub(b, id))
+	}
+	shared.RetainedStr(b, "$nodes", strings.Join(ids, ","))
+	shared.RetainedStr(b, "$state", HomieReady)
+}
+
+func (s *SerializedNode) publishHomie(b msgbus.Bus) {
+	shared.RetainedStr(b, "$name", s.Name)
+	shared.RetainedStr(b, "$type", string(s.Type))
+	props := make([]string, 0, len(s.Properties))
+	for id := range s.Properties {
+		props = append(props, string(id))
+	}
+	sort.Strings(props)
+	for _, id := range props {
+		p := s.Properties[ID(id)]
+		bp := msgbus.RebasePub(b, id)
+		shared.RetainedStr(bp, "$name", id)
+		shared.RetainedStr(bp, "$datatype", p.DataType)
+		// Empty values clear the retained message.
+		shared.RetainedStr(bp, "$format", p.Format)
+		shared.RetainedStr(bp, "$unit", p.Unit)
+		shared.RetainedStr(bp, "$settable", fmt.Sprintf("%t", p.Settable))
+		shared.RetainedStr(bp, "$retained", fmt.Sprintf("%t", !p.Event))
+	}
+	shared.RetainedStr(b, "$properties", strings.Join(props, ","))
+}
+
+// ErrHomieIncomplete is returned by ParseHomie when an attribute is missing.
+var ErrHomieIncomplete = errors.New("homie: incomplete device description")
+
+// ParseHomie returns the description of a device following the Homie
+// convention.
+//
+// attrs contains the attributes of the device, keyed by their topic relative to
+// the device, e.g. "$name" or "node/prop/$datatype". All the nodes are of type
+// Homie.
+func ParseHomie(attrs map[string][]byte) (*Dev, error) {
+	get := func(k string) (string, error) {
+		v, ok := attrs[k]
+		if !ok {
+			return "", ErrHomieIncomplete
+		}
+		return string(v), nil
+	}
+	v, err := get("$homie")
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(v, "3.") && !strings.HasPrefix(v, "4.") {
+		return nil, fmt.Errorf("homie: unsupported version %q", v)
+	}
+	d := &Dev{Nodes: map[ID]*Node{}}
+	if d.Name, err = get("$name"); err != nil {
+		return nil, err
+	}
+	ids, err := get("$nodes")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range splitList(ids) {
+		if err := ID(id).Validate(); err != nil {
+			return nil, fmt.Errorf("homie: node %v", err)
+		}
+		n := &Node{}
+		h := &Homie{Properties: map[ID]Property{}}
+		if n.Name, err = get(id + "/$name"); err != nil {
+			return nil, err
+		}
+		// $type is optional in version 4.
+		h.Type = string(attrs[id+"/$type"])
+		props, err := get(id + "/$properties")
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range splitList(props) {
+			if err := ID(p).Validate(); err != nil {
+				return nil, fmt.Errorf("homie: node %s: property %v", id, err)
+			}
+			k := id + "/" + p + "/"
+			prop := Property{
+				Unit:     string(attrs[k+"$unit"]),
+				DataType: string(attrs[k+"$datatype"]),
+				Format:   string(attrs[k+"$format"]),
+				Settable: string(attrs[k+"$settable"]) == "true",
+				// $retained defaults to true.
+				Event: string(attrs[k+"$retained"]) == "false",
+			}
+			if len(prop.DataType) == 0 {
+				prop.DataType = "string"
+			}
+			h.Properties[ID(p)] = prop
+		}
+		n.Config = h
+		d.Nodes[ID(id)] = n
+	}
+	return d, nil
+}
+
+// HomieAttrs returns the attribute topics to retrieve to describe a device, as
+// known so far from attrs.
+//
+// The topics are relative to the device. The list grows as the $nodes and
+// $properties attributes are received.
+func HomieAttrs(attrs map[string][]byte) []string {
+	out := []string{"$homie", "$name", "$state", "$nodes"}
+	for _, id := range splitList(string(attrs["$nodes"])) {
+		if ID(id).Validate() != nil {
+			continue
+		}
+		out = append(out, id+"/$name", id+"/$type", id+"/$properties")
+		for _, p := range splitList(string(attrs[id+"/$properties"])) {
+			if ID(p).Validate() != nil {
+				continue
+			}
+			for _, a := range []string{"$unit", "$datatype", "$format", "$settable", "$retained"} {
+				out = append(out, id+"/"+p+"/"+a)
+			}
+		}
+	}
+	return out
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+//
+// Homie version 3 array nodes are suffixed with "[]", which is stripped.
+func splitList(s string) []string {
+	var out []string
+	for _, i := range strings.Split(s, ",") {
+		if i = strings.TrimSuffix(i, "[]"); len(i) != 0 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
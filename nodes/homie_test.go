@@ -0,0 +1,67 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package nodes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/maruel/msgbus"
+)
+
+func TestHomie(t *testing.T) {
+	b := msgbus.New()
+	defer b.Close()
+	d := msgbus.RebaseSub(msgbus.RebasePub(b, "homie/dev1"), "homie/dev1")
+	dev := &Dev{
+		Name: "Living room",
+		Nodes: map[ID]*Node{
+			"pir":   {Name: "Motion", Config: &PIR{Pin: "GPIO4"}},
+			"sound": {Name: "Speaker", Config: &Sound{}},
+		},
+	}
+	dev.ToSerialized().PublishHomie(d)
+
+	// Retrieve the attributes the way a controller would, as they are
+	// discovered.
+	attrs := map[string][]byte{}
+	for n := -1; n != len(attrs); {
+		n = len(attrs)
+		msgs, err := msgbus.Retained(d, 100*time.Millisecond, HomieAttrs(attrs)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range msgs {
+			attrs[k] = v
+		}
+	}
+	if s := string(attrs["$state"]); s != HomieReady {
+		t.Fatalf("unexpected state %q", s)
+	}
+	actual, err := ParseHomie(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Dev{
+		Name: "Living room",
+		Nodes: map[ID]*Node{
+			"pir":   {Name: "Motion", Config: &Homie{Type: "pir", Properties: map[ID]Property{"pir": {DataType: "boolean", Event: true}}}},
+			"sound": {Name: "Speaker", Config: &Homie{Type: "sound", Properties: map[ID]Property{"speakers": {DataType: "string", Settable: true}}}},
+		},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("%#v != %#v", expected, actual)
+	}
+
+	delete(attrs, "sound/$properties")
+	if _, err := ParseHomie(attrs); err != ErrHomieIncomplete {
+		t.Fatalf("unexpected error %v", err)
+	}
+	attrs["$homie"] = []byte("2.0.0")
+	if _, err := ParseHomie(attrs); err == nil || err == ErrHomieIncomplete {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
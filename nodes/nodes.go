@@ -12,7 +12,11 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/maruel/dlibox/shared"
+	"github.com/maruel/msgbus"
 )
 
 // Validator ensures a configuration is valid.
@@ -39,9 +43,8 @@ func (i ID) Validate() error {
 // The controller stores this data on the MQTT server upon startup and
 // upon configuration update.
 //
-// The device fetches this data from the MQTT server upon startup. So when a
-// device configuration occurs, the device should restart. This is really just
-// restarting the Go process so this is nearly instant.
+// The device fetches this data from the MQTT server upon startup, then watches
+// it and only reinitializes the nodes that changed.
 type Dev struct {
 	// Name is the display name of this nodes collection: the device.
 	Name  string
@@ -52,7 +55,7 @@ type Dev struct {
 //
 // Serialization should never fail.
 func (d *Dev) ToSerialized() *SerializedDev {
-	nds := &SerializedDev{Name: d.Name}
+	nds := &SerializedDev{Name: d.Name, Nodes: make(map[ID]*SerializedNode, len(d.Nodes))}
 	for id, n := range d.Nodes {
 		c, err := json.Marshal(n.Config)
 		if err != nil {
@@ -97,6 +100,39 @@ func (n *Node) Type() Type {
 	return Type(strings.ToLower(reflect.TypeOf(n.Config).Elem().Name()))
 }
 
+// nodeJSON is the form of Node as stored in the controller's settings.
+type nodeJSON struct {
+	Name   string
+	Type   Type
+	Config json.RawMessage
+}
+
+// MarshalJSON implements json.Marshaler.
+//
+// The Type is saved along Config so the right struct can be decoded.
+func (n *Node) MarshalJSON() ([]byte, error) {
+	c, err := json.Marshal(n.Config)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&nodeJSON{Name: n.Name, Type: n.Type(), Config: c})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *Node) UnmarshalJSON(b []byte) error {
+	var j nodeJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	c, err := decodeCfg(j.Type, j.Config)
+	if err != nil {
+		return fmt.Errorf("node %s: %v", j.Name, err)
+	}
+	n.Name = j.Name
+	n.Config = c
+	return nil
+}
+
 // Validate implements Validator.
 func (n *Node) Validate() error {
 	if len(n.Name) == 0 {
@@ -117,6 +153,22 @@ type SerializedDev struct {
 	Nodes map[ID]*SerializedNode
 }
 
+// Publish publishes the device description as retained messages.
+//
+// b must be rebased on the device ID. The device retrieves it upon startup.
+func (s *SerializedDev) Publish(b msgbus.Bus) {
+	ids := make([]string, 0, len(s.Nodes))
+	for id := range s.Nodes {
+		ids = append(ids, string(id))
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		s.Nodes[ID(id)].publish(msgbus.RebasePub(b, id))
+	}
+	shared.RetainedStr(b, "$nodes", strings.Join(ids, ","))
+	shared.RetainedStr(b, "$name", s.Name)
+}
+
 // ToDev is used by the device to deserialize the configuration.
 func (s *SerializedDev) ToDev() (*Dev, error) {
 	// Parse every nodes, return a processed config.
@@ -142,16 +194,46 @@ type SerializedNode struct {
 	Config     []byte          `json:"$config"`
 }
 
+func (s *SerializedNode) publish(b msgbus.Bus) {
+	shared.RetainedStr(b, "$name", s.Name)
+	shared.RetainedStr(b, "$type", string(s.Type))
+	props := make([]string, 0, len(s.Properties))
+	for id := range s.Properties {
+		props = append(props, string(id))
+	}
+	sort.Strings(props)
+	for _, id := range props {
+		p := s.Properties[ID(id)]
+		bp := msgbus.RebasePub(b, id)
+		// Empty values clear the retained message.
+		shared.RetainedStr(bp, "$unit", p.Unit)
+		shared.RetainedStr(bp, "$datatype", p.DataType)
+		shared.RetainedStr(bp, "$format", p.Format)
+		shared.RetainedStr(bp, "$settable", fmt.Sprintf("%t", p.Settable))
+	}
+	shared.RetainedStr(b, "$properties", strings.Join(props, ","))
+	shared.Retained(b, "$config", s.Config)
+}
+
 func (s *SerializedNode) toNode(id ID) (*Node, error) {
-	r := TypesMap[s.Type]
+	v, err := decodeCfg(s.Type, s.Config)
+	if err != nil {
+		return nil, fmt.Errorf("node %s: %v", id, err)
+	}
+	return &Node{Name: s.Name, Config: v}, nil
+}
+
+// decodeCfg decodes the node configuration of type t.
+func decodeCfg(t Type, raw []byte) (NodeCfg, error) {
+	r := TypesMap[t]
 	if r == nil {
-		return nil, fmt.Errorf("node %s: unknown type %s", id, s.Type)
+		return nil, fmt.Errorf("unknown type %s", t)
 	}
 	v := reflect.New(r).Interface().(NodeCfg)
-	if err := json.Unmarshal(s.Config, v); err != nil {
-		return nil, fmt.Errorf("node %s: failed to unmarshal config: %v", id, err)
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config: %v", err)
 	}
-	return &Node{Name: s.Name, Config: v}, nil
+	return v, nil
 }
 
 // Property defines one property of a node.
@@ -163,8 +245,11 @@ type Property struct {
 	DataType string `json:"$datatype"`
 	Format   string `json:"$format"`
 	Settable bool   `json:"$settable"`
+	// Event is true when the values are events, like a button click, instead
+	// of a state. They are not retained.
+	Event bool `json:"$event"`
 }
 
 //
 
-var re = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]+$`)
+var re = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]*$`)
@@ -9,7 +9,9 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
+	"github.com/maruel/dlibox/ircode"
 	"periph.io/x/periph/conn/physic"
 )
 
@@ -28,36 +30,85 @@ type SPIRef struct {
 	Hz physic.Frequency
 }
 
-// Anim1D is an APA102 LED strip.
+// Anim1D is a LED strip.
+//
+// Exactly one chipset must be selected. APA102 is connected over SPI. The NRZ
+// LEDs, WS2812B and SK6812, are driven by the MOSI line of a SPI port. Fake
+// draws the strip in the terminal, for desks without hardware.
+//
+// The strip is either a single physical strip on SPI, or multiple Segments
+// stitched into one logical strip.
 type Anim1D struct {
-	APA102 bool
-	I2C    I2CRef
-	SPI    SPIRef
+	APA102  bool
+	WS2812B bool
+	SK6812  bool // RGBW.
+	Fake    bool
+	// I2C is not supported by any of the chipsets, it is rejected by Validate.
+	I2C I2CRef
+	SPI SPIRef
+	// Segments are the physical strips, when there is more than one. SPI must
+	// be empty in this case.
+	Segments []Segment
 	// NumberLights is the number of lights controlled by this device. If lower
 	// than the actual number of lights, the remaining lights will flash oddly.
+	//
+	// With Segments, it is the number of lights of the logical strip.
 	NumberLights int
 	FPS          int
+	// ColorOrder is the order of the channels on the wire for the NRZ LEDs. It
+	// defaults to "GRB" for WS2812B and "GRBW" for SK6812.
+	ColorOrder string
+	// WhiteFromRGB drives the white channel of RGBW LEDs with the part common
+	// to red, green and blue, which is removed from them. Otherwise the white
+	// channel is off.
+	WhiteFromRGB bool
 }
 
 // Validate implements Validator.
 func (a *Anim1D) Validate() error {
-	if !a.APA102 {
-		return errors.New("anim1d: only APA102 is supported")
+	n := 0
+	for _, b := range []bool{a.APA102, a.WS2812B, a.SK6812, a.Fake} {
+		if b {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("anim1d: exactly one of APA102, WS2812B, SK6812 or Fake is required")
 	}
 	if len(a.I2C.ID) != 0 {
+		// TODO(maruel): Support I²C LED drivers once periph.io has one.
+		return errors.New("anim1d: I2C is not supported by any chipset")
+	}
+	if a.NumberLights <= 0 || a.NumberLights > 1000000 {
+		return errors.New("anim1d: NumberLights is required")
+	}
+	if len(a.Segments) != 0 {
+		if a.Fake {
+			return errors.New("anim1d: Segments is not supported with Fake")
+		}
 		if len(a.SPI.ID) != 0 || a.SPI.Hz != 0 {
-			return errors.New("anim1d: can't use both I2C and SPI")
+			return errors.New("anim1d: can't use both SPI and Segments")
 		}
-	} else {
-		if len(a.SPI.ID) == 0 {
-			return errors.New("anim1d: SPI.ID is required")
+		for i := range a.Segments {
+			if err := a.Segments[i].validate(a); err != nil {
+				return fmt.Errorf("anim1d: segment %d: %v", i, err)
+			}
 		}
-		if a.SPI.Hz < physic.KiloHertz {
-			return errors.New("anim1d: SPI.Hz is required")
+	} else if !a.Fake {
+		if err := validateSPI(a.SPI, a.APA102); err != nil {
+			return fmt.Errorf("anim1d: %v", err)
 		}
 	}
-	if a.NumberLights <= 0 || a.NumberLights > 1000000 {
-		return errors.New("anim1d: NumberLights is required")
+	if len(a.ColorOrder) != 0 {
+		if !a.WS2812B && !a.SK6812 {
+			return errors.New("anim1d: ColorOrder is only supported with NRZ LEDs")
+		}
+		if err := validateColorOrder(a.ColorOrder, a.SK6812); err != nil {
+			return err
+		}
+	}
+	if a.WhiteFromRGB && !a.SK6812 {
+		return errors.New("anim1d: WhiteFromRGB is only supported with SK6812")
 	}
 	if a.FPS <= 0 || a.FPS > 240 {
 		return errors.New("anim1d: FPS is required")
@@ -65,6 +116,61 @@ func (a *Anim1D) Validate() error {
 	return nil
 }
 
+// Segment is a physical LED strip, part of the logical strip of an Anim1D.
+type Segment struct {
+	SPI SPIRef
+	// Offset is the index in the logical strip of the first light of the
+	// segment.
+	Offset       int
+	NumberLights int
+	// Reversed is set when the segment is wired from its end, e.g. when going
+	// back along the other wall after a corner.
+	Reversed bool
+}
+
+func (s *Segment) validate(a *Anim1D) error {
+	if err := validateSPI(s.SPI, a.APA102); err != nil {
+		return err
+	}
+	if s.NumberLights <= 0 {
+		return errors.New("NumberLights is required")
+	}
+	if s.Offset < 0 || s.Offset+s.NumberLights > a.NumberLights {
+		return fmt.Errorf("lights [%d, %d) out of the strip of %d lights", s.Offset, s.Offset+s.NumberLights, a.NumberLights)
+	}
+	return nil
+}
+
+// validateSPI ensures the SPI port is specified. The speed is only used by
+// APA102, the NRZ LEDs use a fixed speed.
+func validateSPI(s SPIRef, apa102 bool) error {
+	if len(s.ID) == 0 {
+		return errors.New("SPI.ID is required")
+	}
+	if apa102 && s.Hz < physic.KiloHertz {
+		return errors.New("SPI.Hz is required")
+	}
+	return nil
+}
+
+// validateColorOrder ensures o is a permutation of "RGB", or "RGBW" when rgbw
+// is true.
+func validateColorOrder(o string, rgbw bool) error {
+	want := "RGB"
+	if rgbw {
+		want = "RGBW"
+	}
+	if len(o) != len(want) {
+		return fmt.Errorf("anim1d: ColorOrder %q must be a permutation of %q", o, want)
+	}
+	for _, c := range want {
+		if strings.Count(o, string(c)) != 1 {
+			return fmt.Errorf("anim1d: ColorOrder %q must be a permutation of %q", o, want)
+		}
+	}
+	return nil
+}
+
 func (a *Anim1D) toProperties() map[ID]Property {
 	return map[ID]Property{
 		"anim1d": {
@@ -75,8 +181,27 @@ func (a *Anim1D) toProperties() map[ID]Property {
 }
 
 // Button represents a physical GPIO input pin of type Button.
+//
+// The debounced state is retained in "button", true while pressed. The
+// gestures are published as events: "click" for a short press, "double" for
+// two short presses within DoubleMS, "long" once a press lasts LongMS, then
+// "hold" every RepeatMS with the number of repeats until the release.
 type Button struct {
 	Pin string
+	// ActiveLow is true when the button connects the pin to the ground, the
+	// pin is then pulled up. Otherwise the pin is pulled down.
+	ActiveLow bool
+	// DebounceMS is the duration the pin must be stable for a change to be
+	// considered. A pin still bouncing after a few periods is read as is.
+	// Defaults to 20.
+	DebounceMS int
+	// DoubleMS is the maximum delay between two clicks of a double click. A
+	// click is published after this delay. Defaults to 300.
+	DoubleMS int
+	// LongMS is the duration of a long press. Defaults to 1000.
+	LongMS int
+	// RepeatMS is the interval between the hold events. Defaults to 250.
+	RepeatMS int
 }
 
 // Validate implements Validator.
@@ -84,39 +209,265 @@ func (b *Button) Validate() error {
 	if len(b.Pin) == 0 {
 		return errors.New("button: Pin is required")
 	}
+	if b.DebounceMS < 0 || b.DoubleMS < 0 || b.LongMS < 0 || b.RepeatMS < 0 {
+		return errors.New("button: durations must be positive")
+	}
 	return nil
 }
 
 func (b *Button) toProperties() map[ID]Property {
 	return map[ID]Property{
 		"button": {DataType: "boolean"},
+		"click":  {DataType: "boolean", Event: true},
+		"double": {DataType: "boolean", Event: true},
+		"long":   {DataType: "boolean", Event: true},
+		"hold":   {DataType: "integer", Event: true},
 	}
 }
 
-// Display is an ssd1306 display.
+// Display is a pixel display.
+//
+// Exactly one backend must be selected. SSD1306 is a monochrome OLED connected
+// over I²C, or over SPI with the DC pin. EPD is a Waveshare e-paper HAT over
+// SPI. Inky is a Pimoroni Inky pHAT or wHAT e-paper over SPI. PNG renders in
+// memory, optionally saving each frame to Path, to design layouts without
+// hardware.
+//
+// The "markee" property is a line of text scrolling horizontally. The
+// "content" property is a text/template of one or multiple lines, executed on
+// each redraw with DisplayData.
+//
+// The e-paper panels take seconds to refresh, so they don't support the markee
+// widget and their RefreshMS is at least PaperRefreshMS.
 //
-// TODO(maruel): make it more generic so other kind of display are supported.
+// TODO(maruel): Support ST7789 color TFTs once periph.io has a driver.
 type Display struct {
 	SSD1306 bool
+	EPD     bool
+	Inky    bool
+	PNG     bool
 	I2C     struct {
 		ID string
 	}
+	SPI SPIRef
+	// DC, Reset and Busy are the GPIO pins of an Inky. SSD1306 over SPI only
+	// uses DC. EPD uses the pins of the HAT.
+	DC, Reset, Busy string
+	// Model is "1in54" or "2in13" for EPD, "PHAT" or "WHAT" for Inky.
+	Model string
+	// Color is the third color of an Inky: "black", "red" or "yellow".
+	Color string
+	// Path is the file where PNG saves each frame, if set.
+	Path string
+	// W and H are the size in pixels of SSD1306 and PNG. The size of the
+	// e-paper panels is defined by their Model.
 	W, H int
+	// MarkeeSpeed is the scrolling speed of the markee in pixels per second.
+	// Defaults to 30.
+	MarkeeSpeed int
+	// RefreshMS is the interval in milliseconds between redraws when nothing
+	// scrolls, so the clocks and templates are updated. Defaults to 1000, or
+	// PaperRefreshMS for EPD and Inky.
+	RefreshMS int
+	// Topics are MQTT topics relative to the device, e.g. "env/temperature",
+	// which values are available to the content template. The topics of the
+	// widgets are available too.
+	Topics []string
+	// Widgets is the layout of the display. When empty, the markee is drawn at
+	// the top with the large font and the content below with the small font.
+	// The e-paper panels only draw the content.
+	Widgets []Widget
+}
+
+// PaperRefreshMS is the minimum RefreshMS of the e-paper panels.
+const PaperRefreshMS = 60000
+
+// DisplayData is the data available to the template of the "content" property
+// of a Display.
+//
+// For example: "{{.Now.Format "15:04"}}\nOut: {{index .Values "env/temperature"}}°C"
+type DisplayData struct {
+	Now    time.Time
+	Values map[string]string // Last value of the Topics.
+}
+
+// Widget kinds.
+const (
+	WidgetMarkee   = "markee"
+	WidgetContent  = "content"
+	WidgetClock    = "clock"
+	WidgetValue    = "value"
+	WidgetProgress = "progress"
+)
+
+// Widget is an area of a Display.
+type Widget struct {
+	// Kind is one of the Widget constants.
+	Kind string
+	// X, Y, W and H is the area of the widget in pixels. W and H default to
+	// the remaining of the display.
+	X, Y, W, H int
+	// Font is the Terminus psf font used to draw text, e.g. "Terminus20x10".
+	// Defaults to "Terminus12x6".
+	Font string
+	// Format is the time layout of a clock, defaulting to "15:04", or the fmt
+	// format of a value, defaulting to "%s".
+	Format string
+	// Topic is the MQTT topic relative to the device of a value or a progress
+	// bar.
+	Topic string
+	// Min and Max is the range of the value of a progress bar.
+	Min, Max float64
 }
 
 // Validate implements Validator.
 func (d *Display) Validate() error {
-	if !d.SSD1306 {
-		return errors.New("display: only SSD1306 is supported")
+	n := 0
+	for _, b := range []bool{d.SSD1306, d.EPD, d.Inky, d.PNG} {
+		if b {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("display: exactly one of SSD1306, EPD, Inky or PNG is required")
+	}
+	switch {
+	case d.SSD1306:
+		if (len(d.I2C.ID) == 0) == (len(d.SPI.ID) == 0) {
+			return errors.New("display: exactly one of I2C.ID or SPI.ID is required")
+		}
+		if (len(d.SPI.ID) == 0) != (len(d.DC) == 0) {
+			return errors.New("display: DC is required with SPI and only with SPI")
+		}
+		if len(d.Reset) != 0 || len(d.Busy) != 0 {
+			return errors.New("display: Reset and Busy are not supported by SSD1306")
+		}
+	case d.EPD:
+		if len(d.SPI.ID) == 0 {
+			return errors.New("display: SPI.ID is required")
+		}
+		if len(d.DC) != 0 || len(d.Reset) != 0 || len(d.Busy) != 0 {
+			return errors.New("display: EPD uses the pins of the HAT")
+		}
+	case d.Inky:
+		if len(d.SPI.ID) == 0 {
+			return errors.New("display: SPI.ID is required")
+		}
+		if len(d.DC) == 0 || len(d.Reset) == 0 || len(d.Busy) == 0 {
+			return errors.New("display: DC, Reset and Busy are required")
+		}
+		switch d.Color {
+		case "black", "red", "yellow":
+		default:
+			return fmt.Errorf("display: invalid Color %q", d.Color)
+		}
+	case d.PNG:
+		if len(d.I2C.ID) != 0 || len(d.SPI.ID) != 0 || len(d.DC) != 0 || len(d.Reset) != 0 || len(d.Busy) != 0 {
+			return errors.New("display: PNG doesn't use a bus")
+		}
+	}
+	if !d.Inky && len(d.Color) != 0 {
+		return errors.New("display: Color is only supported by Inky")
+	}
+	if !d.PNG && len(d.Path) != 0 {
+		return errors.New("display: Path is only supported by PNG")
+	}
+	if d.IsPaper() {
+		if d.W != 0 || d.H != 0 {
+			return errors.New("display: W and H are defined by Model")
+		}
+		if w, _ := d.Size(); w == 0 {
+			return fmt.Errorf("display: invalid Model %q", d.Model)
+		}
+	} else {
+		if len(d.Model) != 0 {
+			return errors.New("display: Model is only supported by EPD and Inky")
+		}
+		if d.W <= 0 {
+			return errors.New("display: W is required")
+		}
+		if d.H <= 0 {
+			return errors.New("display: H is required")
+		}
 	}
-	if len(d.I2C.ID) == 0 {
-		return errors.New("display: I2C.ID is required")
+	if d.MarkeeSpeed < 0 {
+		return errors.New("display: MarkeeSpeed must be positive")
 	}
-	if d.W == 0 {
-		return errors.New("display: W is required")
+	if d.RefreshMS < 0 {
+		return errors.New("display: RefreshMS must be positive")
+	}
+	if d.IsPaper() && d.RefreshMS != 0 && d.RefreshMS < PaperRefreshMS {
+		return fmt.Errorf("display: RefreshMS must be at least %d for e-paper", PaperRefreshMS)
+	}
+	for _, t := range d.Topics {
+		if err := validateRelTopic(t); err != nil {
+			return fmt.Errorf("display: %v", err)
+		}
+	}
+	for i := range d.Widgets {
+		if err := d.Widgets[i].validate(d); err != nil {
+			return fmt.Errorf("display: widget %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// IsPaper returns true for the e-paper panels.
+func (d *Display) IsPaper() bool {
+	return d.EPD || d.Inky
+}
+
+// Size returns the size of the display in pixels, or 0, 0 if the Model is
+// unknown.
+func (d *Display) Size() (int, int) {
+	switch {
+	case d.EPD && d.Model == "1in54":
+		return 200, 200
+	case d.EPD && d.Model == "2in13":
+		return 128, 250
+	case d.Inky && d.Model == "PHAT":
+		return 104, 212
+	case d.Inky && d.Model == "WHAT":
+		return 400, 300
+	case d.EPD || d.Inky:
+		return 0, 0
+	default:
+		return d.W, d.H
+	}
+}
+
+func (w *Widget) validate(d *Display) error {
+	if w.Kind == WidgetMarkee && d.IsPaper() {
+		return errors.New("markee is not supported by e-paper")
+	}
+	switch w.Kind {
+	case WidgetMarkee, WidgetContent, WidgetClock:
+		if len(w.Topic) != 0 {
+			return fmt.Errorf("Topic is not supported by %s", w.Kind)
+		}
+	case WidgetValue, WidgetProgress:
+		if err := validateRelTopic(w.Topic); err != nil {
+			return err
+		}
+		if w.Kind == WidgetProgress && w.Max <= w.Min {
+			return errors.New("Max must be greater than Min")
+		}
+	default:
+		return fmt.Errorf("unknown Kind %q", w.Kind)
+	}
+	if dw, dh := d.Size(); w.X < 0 || w.Y < 0 || w.W < 0 || w.H < 0 || w.X >= dw || w.Y >= dh {
+		return errors.New("area out of the display")
+	}
+	return nil
+}
+
+// validateRelTopic ensures t is a topic relative to the device.
+func validateRelTopic(t string) error {
+	if len(t) == 0 {
+		return errors.New("topic is required")
 	}
-	if d.H == 0 {
-		return errors.New("display: H is required")
+	if strings.HasPrefix(t, "/") || strings.HasSuffix(t, "/") || strings.ContainsAny(t, "+#") {
+		return fmt.Errorf("invalid topic %q", t)
 	}
 	return nil
 }
@@ -136,18 +487,104 @@ func (d *Display) toProperties() map[ID]Property {
 
 // IR is an InfraRed Remote receiver.
 //
-// In practice, only lirc is supported.
+// Without Pin, the keys decoded by lirc are published to "ir". While a key is
+// held, the number of repeats is published to "ir/<key>/repeat", then the key
+// is published once to "ir/<key>/hold" after HoldMS. A second press within
+// DoubleMS of the release publishes the key to "ir/<key>/double", in
+// addition to "ir". Each gesture also publishes the key to the "repeat",
+// "hold" or "double" property, since a property can't depend on the key.
+//
+// With Pin, the codes are decoded from the pulses of a demodulating receiver
+// like the TSOP38238 and published to "code", in the format of package
+// ircode. Setting "learn" to a name publishes the next code received to
+// "learned" as "<name>=<code>"; the controller stores it in its table of
+// named codes, which is available to all the IRTx nodes.
 type IR struct {
+	Pin string
+	// HoldMS is the duration a key must be held to be a long press. Defaults
+	// to 1000.
+	HoldMS int
+	// DoubleMS is the maximum delay between the release of a key and the
+	// next press to be a double press. Defaults to 400.
+	DoubleMS int
 }
 
 // Validate implements Validator.
 func (i *IR) Validate() error {
+	if i.HoldMS < 0 {
+		return errors.New("ir: HoldMS must be positive")
+	}
+	if i.DoubleMS < 0 {
+		return errors.New("ir: DoubleMS must be positive")
+	}
+	if len(i.Pin) != 0 && (i.HoldMS != 0 || i.DoubleMS != 0) {
+		// TODO(maruel): Detect the NEC repeat frames and the RC5 toggle bit.
+		return errors.New("ir: HoldMS and DoubleMS are only supported with lirc")
+	}
 	return nil
 }
 
 func (i *IR) toProperties() map[ID]Property {
+	if len(i.Pin) == 0 {
+		return map[ID]Property{
+			"ir":     {DataType: "string", Event: true},
+			"repeat": {DataType: "string", Event: true},
+			"hold":   {DataType: "string", Event: true},
+			"double": {DataType: "string", Event: true},
+		}
+	}
 	return map[ID]Property{
-		"ir": {DataType: "string"},
+		"code":    {DataType: "string", Event: true},
+		"learn":   {DataType: "string", Settable: true},
+		"learned": {DataType: "string", Event: true},
+	}
+}
+
+// IRTx is an InfraRed transmitter, an IR LED driven by a GPIO pin.
+//
+// Setting "send" to the name of a code in Codes, or to a code in the format
+// of package ircode, transmits it.
+type IRTx struct {
+	Pin string
+	// Carrier is the frequency of the carrier. Defaults to 38kHz.
+	Carrier physic.Frequency
+	// Codes are the named codes specific to this transmitter. The controller
+	// adds the codes of its table, usually learned with an IR node, when it
+	// publishes the configuration.
+	Codes map[string]string
+}
+
+// Validate implements Validator.
+func (i *IRTx) Validate() error {
+	if len(i.Pin) == 0 {
+		return errors.New("irtx: Pin is required")
+	}
+	if i.Carrier != 0 && (i.Carrier < 30*physic.KiloHertz || i.Carrier > 60*physic.KiloHertz) {
+		return fmt.Errorf("irtx: Carrier %s is out of range", i.Carrier)
+	}
+	if err := ValidateIRCodes(i.Codes); err != nil {
+		return fmt.Errorf("irtx: %v", err)
+	}
+	return nil
+}
+
+// ValidateIRCodes validates a table of codes in the format of package ircode
+// keyed by their name.
+func ValidateIRCodes(codes map[string]string) error {
+	for name, c := range codes {
+		if err := ID(name).Validate(); err != nil {
+			return fmt.Errorf("code %v", err)
+		}
+		if _, err := ircode.Parse(c); err != nil {
+			return fmt.Errorf("code %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (i *IRTx) toProperties() map[ID]Property {
+	return map[ID]Property{
+		"send": {DataType: "string", Settable: true},
 	}
 }
 
@@ -166,10 +603,172 @@ func (p *PIR) Validate() error {
 
 func (p *PIR) toProperties() map[ID]Property {
 	return map[ID]Property{
-		"pir": {DataType: "boolean"},
+		"pir": {DataType: "boolean", Event: true},
 	}
 }
 
+// PWM is a GPIO output pin driven with PWM, e.g. a single color LED tape
+// dimmer or a servo.
+//
+// The level is set in percent on "level/set", either absolute or relative with
+// "+N" and "-N", and its actual value is published as the retained "level"
+// property once the transition is done.
+type PWM struct {
+	Pin string
+	// Hz is the PWM frequency, e.g. 50Hz for a servo.
+	Hz physic.Frequency
+	// MinDuty and MaxDuty are the duty cycles in percent for the levels 0% and
+	// 100%. Use 0 and 100 for a dimmer, around 5 and 10 for a servo.
+	MinDuty float64
+	MaxDuty float64
+	// Gamma is the gamma correction applied to the level, e.g. 2.2 for LEDs. 0
+	// means linear.
+	Gamma float64
+	// FadeMS is the duration of the transitions in milliseconds.
+	FadeMS int
+}
+
+// Validate implements Validator.
+func (p *PWM) Validate() error {
+	if len(p.Pin) == 0 {
+		return errors.New("pwm: Pin is required")
+	}
+	if p.Hz <= 0 {
+		return errors.New("pwm: Hz is required")
+	}
+	if p.MinDuty < 0 || p.MaxDuty > 100 || p.MinDuty >= p.MaxDuty {
+		return fmt.Errorf("pwm: invalid duty range [%g, %g]", p.MinDuty, p.MaxDuty)
+	}
+	if p.Gamma < 0 || p.Gamma > 5 {
+		return fmt.Errorf("pwm: invalid Gamma %g", p.Gamma)
+	}
+	if p.FadeMS < 0 {
+		return errors.New("pwm: FadeMS must be positive")
+	}
+	return nil
+}
+
+func (p *PWM) toProperties() map[ID]Property {
+	return map[ID]Property{
+		"level": {
+			Unit:     "%",
+			DataType: "integer",
+			Format:   "0:100",
+			Settable: true,
+		},
+	}
+}
+
+// Relay is a GPIO output pin, e.g. driving a relay.
+//
+// The output is turned on and off with "true" and "false" on "on/set" and its
+// actual state is published as the retained "on" property.
+type Relay struct {
+	Pin string
+	// ActiveLow is set when the pin is driven low to turn the output on.
+	ActiveLow bool
+	// On is the state upon boot.
+	On bool
+	// AutoOffMS turns the output off after this delay in milliseconds when
+	// non-zero.
+	AutoOffMS int
+}
+
+// Validate implements Validator.
+func (r *Relay) Validate() error {
+	if len(r.Pin) == 0 {
+		return errors.New("relay: Pin is required")
+	}
+	if r.AutoOffMS < 0 {
+		return errors.New("relay: AutoOffMS must be positive")
+	}
+	return nil
+}
+
+func (r *Relay) toProperties() map[ID]Property {
+	return map[ID]Property{
+		"on": {
+			DataType: "boolean",
+			Settable: true,
+		},
+	}
+}
+
+// Sensor is an environmental sensor connected over I²C.
+//
+// A Bosch BME280 measures the temperature, the pressure and the humidity, a
+// BMP180 only the temperature and the pressure. A BMP280 can be used as a
+// BMP180. A Rohm BH1750 measures the ambient light.
+//
+// The measurements are published as the "temperature" (°C), "pressure" (kPa),
+// "humidity" (%) and "illuminance" (lx) properties.
+type Sensor struct {
+	BME280 bool
+	BMP180 bool
+	BH1750 bool
+	I2C    I2CRef
+	// Addr is the I²C address: 0x76 or 0x77 for a BME280 or a BMP280, 0x77 for
+	// a BMP180, 0x23 or 0x5C for a BH1750.
+	Addr uint16
+	// PeriodMS is the polling interval in milliseconds.
+	PeriodMS int
+	// Threshold is the minimum change for a measurement to be published again.
+	Threshold SensorThreshold
+}
+
+// SensorThreshold is the minimum change of each measurement to be reported.
+//
+// A zero value reports every measurement.
+type SensorThreshold struct {
+	Temperature float64 // °C
+	Pressure    float64 // kPa
+	Humidity    float64 // %
+	Illuminance float64 // lx
+}
+
+// Validate implements Validator.
+func (s *Sensor) Validate() error {
+	n := 0
+	for _, b := range []bool{s.BME280, s.BMP180, s.BH1750} {
+		if b {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("sensor: one of BME280, BMP180 or BH1750 is required")
+	}
+	if len(s.I2C.ID) == 0 {
+		return errors.New("sensor: I2C.ID is required")
+	}
+	switch {
+	case (s.BME280 || s.BMP180) && (s.Addr == 0x76 || s.Addr == 0x77):
+	case s.BH1750 && (s.Addr == 0x23 || s.Addr == 0x5C):
+	default:
+		return fmt.Errorf("sensor: invalid Addr 0x%x", s.Addr)
+	}
+	if s.PeriodMS < 100 || s.PeriodMS > 24*60*60*1000 {
+		return errors.New("sensor: PeriodMS is required")
+	}
+	if s.Threshold.Temperature < 0 || s.Threshold.Pressure < 0 || s.Threshold.Humidity < 0 || s.Threshold.Illuminance < 0 {
+		return errors.New("sensor: Threshold must be positive")
+	}
+	return nil
+}
+
+func (s *Sensor) toProperties() map[ID]Property {
+	if s.BH1750 {
+		return map[ID]Property{"illuminance": {DataType: "float", Unit: "lx"}}
+	}
+	p := map[ID]Property{
+		"temperature": {DataType: "float", Unit: "°C"},
+		"pressure":    {DataType: "float", Unit: "kPa"},
+	}
+	if s.BME280 {
+		p["humidity"] = Property{DataType: "float", Unit: "%"}
+	}
+	return p
+}
+
 // Sound is a sound output device.
 type Sound struct {
 	DeviceID string // Empty to use the default sound card.
@@ -208,10 +807,27 @@ var knownTypes = []Validator{
 	&Button{},
 	&Display{},
 	&IR{},
+	&IRTx{},
 	&PIR{},
+	&PWM{},
+	&Relay{},
+	&Sensor{},
 	&Sound{},
 }
 
+// CommandTopic returns the topic, relative to the node, to send a command to
+// the property prop of a node of type t.
+//
+// Most nodes listen to the property topic itself. The output nodes follow the
+// Homie convention of a "/set" suffix, since they publish their actual state
+// on the property topic.
+func CommandTopic(t Type, prop ID) string {
+	if t == "pwm" || t == "relay" {
+		return string(prop) + "/set"
+	}
+	return string(prop)
+}
+
 // TypesMap is the list of known types and their associated name.
 var TypesMap map[Type]reflect.Type
 
@@ -0,0 +1,178 @@
+// Copyright 2018 Marc-Antoine Ruel. All rights reserved.
+// Use of this source code is governed under the Apache License, Version 2.0
+// that can be found in the LICENSE file.
+
+package nodes
+
+import (
+	"testing"
+
+	"periph.io/x/periph/conn/physic"
+)
+
+func TestAnim1DValidate(t *testing.T) {
+	spi := SPIRef{ID: "SPI0.0", Hz: physic.MegaHertz}
+	data := []struct {
+		cfg   Anim1D
+		valid bool
+	}{
+		{Anim1D{APA102: true, SPI: spi, NumberLights: 10, FPS: 60}, true},
+		{Anim1D{APA102: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{APA102: true, WS2812B: true, SPI: spi, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{SPI: spi, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{WS2812B: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60}, true},
+		{Anim1D{WS2812B: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60, ColorOrder: "BGR"}, true},
+		{Anim1D{WS2812B: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60, ColorOrder: "RRB"}, false},
+		{Anim1D{WS2812B: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60, ColorOrder: "GRBW"}, false},
+		{Anim1D{WS2812B: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60, WhiteFromRGB: true}, false},
+		{Anim1D{SK6812: true, SPI: SPIRef{ID: "SPI0.0"}, NumberLights: 10, FPS: 60, ColorOrder: "WRGB", WhiteFromRGB: true}, true},
+		{Anim1D{APA102: true, SPI: spi, NumberLights: 10, FPS: 60, ColorOrder: "RGB"}, false},
+		{Anim1D{Fake: true, NumberLights: 10, FPS: 60}, true},
+		{Anim1D{APA102: true, I2C: I2CRef{ID: "1"}, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{APA102: true, Segments: []Segment{{SPI: spi, NumberLights: 6}, {SPI: spi, Offset: 6, NumberLights: 4, Reversed: true}}, NumberLights: 10, FPS: 60}, true},
+		{Anim1D{APA102: true, Segments: []Segment{{SPI: spi, Offset: 6, NumberLights: 6}}, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{APA102: true, SPI: spi, Segments: []Segment{{SPI: spi, NumberLights: 6}}, NumberLights: 10, FPS: 60}, false},
+		{Anim1D{Fake: true, Segments: []Segment{{SPI: spi, NumberLights: 6}}, NumberLights: 10, FPS: 60}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestButtonValidate(t *testing.T) {
+	data := []struct {
+		cfg   Button
+		valid bool
+	}{
+		{Button{Pin: "GPIO5"}, true},
+		{Button{Pin: "GPIO5", ActiveLow: true, DebounceMS: 10, DoubleMS: 250, LongMS: 2000, RepeatMS: 500}, true},
+		{Button{}, false},
+		{Button{Pin: "GPIO5", DebounceMS: -1}, false},
+		{Button{Pin: "GPIO5", RepeatMS: -1}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestSensorValidate(t *testing.T) {
+	data := []struct {
+		cfg   Sensor
+		valid bool
+	}{
+		{Sensor{BME280: true, I2C: I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1000}, true},
+		{Sensor{BMP180: true, I2C: I2CRef{ID: "1"}, Addr: 0x77, PeriodMS: 1000}, true},
+		{Sensor{BMP180: true, I2C: I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1000}, true},
+		{Sensor{BMP180: true, I2C: I2CRef{ID: "1"}, Addr: 0x23, PeriodMS: 1000}, false},
+		{Sensor{BH1750: true, I2C: I2CRef{ID: "1"}, Addr: 0x23, PeriodMS: 1000, Threshold: SensorThreshold{Illuminance: 10}}, true},
+		{Sensor{BH1750: true, I2C: I2CRef{ID: "1"}, Addr: 0x77, PeriodMS: 1000}, false},
+		{Sensor{BME280: true, BH1750: true, I2C: I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1000}, false},
+		{Sensor{I2C: I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1000}, false},
+		{Sensor{BME280: true, Addr: 0x76, PeriodMS: 1000}, false},
+		{Sensor{BME280: true, I2C: I2CRef{ID: "1"}, Addr: 0x76}, false},
+		{Sensor{BME280: true, I2C: I2CRef{ID: "1"}, Addr: 0x76, PeriodMS: 1000, Threshold: SensorThreshold{Humidity: -1}}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestDisplayValidate(t *testing.T) {
+	base := func(w ...Widget) Display {
+		d := Display{SSD1306: true, W: 128, H: 64, Widgets: w}
+		d.I2C.ID = "1"
+		return d
+	}
+	withTopics := base()
+	withTopics.Topics = []string{"env/temperature"}
+	badTopic := base()
+	badTopic.Topics = []string{"env/#"}
+	spiSSD1306 := Display{SSD1306: true, SPI: SPIRef{ID: "SPI0.0"}, DC: "GPIO25", W: 128, H: 64}
+	noDC := spiSSD1306
+	noDC.DC = ""
+	both := spiSSD1306
+	both.I2C.ID = "1"
+	data := []struct {
+		cfg   Display
+		valid bool
+	}{
+		{base(), true},
+		{withTopics, true},
+		{badTopic, false},
+		{base(Widget{Kind: WidgetMarkee, H: 20}, Widget{Kind: WidgetContent, Y: 20}), true},
+		{base(Widget{Kind: WidgetClock, Format: "15:04:05"}), true},
+		{base(Widget{Kind: WidgetClock, Topic: "a"}), false},
+		{base(Widget{Kind: WidgetValue, Topic: "env/humidity"}), true},
+		{base(Widget{Kind: WidgetValue}), false},
+		{base(Widget{Kind: WidgetValue, Topic: "/env/humidity"}), false},
+		{base(Widget{Kind: WidgetProgress, Topic: "tape/level", Max: 100}), true},
+		{base(Widget{Kind: WidgetProgress, Topic: "tape/level"}), false},
+		{base(Widget{Kind: WidgetMarkee, Y: 64}), false},
+		{base(Widget{Kind: "image"}), false},
+		{spiSSD1306, true},
+		{noDC, false},
+		{both, false},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "2in13"}, true},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "2in13", W: 128, H: 250}, false},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "PHAT"}, false},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "1in54", Widgets: []Widget{{Kind: WidgetClock, Y: 199}}}, true},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "1in54", Widgets: []Widget{{Kind: WidgetMarkee}}}, false},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "1in54", RefreshMS: 1000}, false},
+		{Display{EPD: true, SPI: SPIRef{ID: "SPI0.0"}, Model: "1in54", RefreshMS: PaperRefreshMS}, true},
+		{Display{Inky: true, SPI: SPIRef{ID: "SPI0.0"}, DC: "GPIO22", Reset: "GPIO27", Busy: "GPIO17", Model: "WHAT", Color: "red"}, true},
+		{Display{Inky: true, SPI: SPIRef{ID: "SPI0.0"}, DC: "GPIO22", Reset: "GPIO27", Busy: "GPIO17", Model: "WHAT"}, false},
+		{Display{Inky: true, SPI: SPIRef{ID: "SPI0.0"}, DC: "GPIO22", Model: "WHAT", Color: "red"}, false},
+		{Display{PNG: true, W: 240, H: 240, Path: "frame.png"}, true},
+		{Display{PNG: true, SSD1306: true, W: 240, H: 240}, false},
+		{Display{PNG: true, SPI: SPIRef{ID: "SPI0.0"}, W: 240, H: 240}, false},
+		{Display{PNG: true}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestIRTxValidate(t *testing.T) {
+	data := []struct {
+		cfg   IRTx
+		valid bool
+	}{
+		{IRTx{Pin: "GPIO17"}, true},
+		{IRTx{Pin: "GPIO17", Carrier: 36 * physic.KiloHertz, Codes: map[string]string{"tv-power": "nec:0xEF10DF20", "amp": "rc5:0x300C"}}, true},
+		{IRTx{}, false},
+		{IRTx{Pin: "GPIO17", Carrier: physic.MegaHertz}, false},
+		{IRTx{Pin: "GPIO17", Codes: map[string]string{"TV": "nec:0xEF10DF20"}}, false},
+		{IRTx{Pin: "GPIO17", Codes: map[string]string{"tv": "nec"}}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}
+
+func TestIRValidate(t *testing.T) {
+	data := []struct {
+		cfg   IR
+		valid bool
+	}{
+		{IR{}, true},
+		{IR{HoldMS: 800, DoubleMS: 300}, true},
+		{IR{HoldMS: -1}, false},
+		{IR{Pin: "GPIO27"}, true},
+		{IR{Pin: "GPIO27", HoldMS: 800}, false},
+	}
+	for i, line := range data {
+		if err := line.cfg.Validate(); (err == nil) != line.valid {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+}